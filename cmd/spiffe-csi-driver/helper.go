@@ -0,0 +1,63 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+
+	"github.com/go-logr/logr"
+	"github.com/spiffe/spiffe-csi/pkg/driver"
+	"github.com/spiffe/spiffe-csi/pkg/mount"
+	"github.com/spiffe/spiffe-csi/pkg/sandbox"
+)
+
+// runMountHelper serves the mount requests of the driver process that
+// started the current one because of -mount-helper. The helper keeps the
+// privileges to mount that the driver process drops, only mounts onto target
+// paths below the kubelet root directory from the Workload API socket
+// directories of the plugins, and only unmounts what the volume layouts
+// mount.
+func runMountHelper(log logr.Logger, mountBackend string, backendOptions mount.BackendOptions, plugins []pluginSpec) error {
+	mounter, err := mount.NewBackend(mountBackend, backendOptions)
+	if err != nil {
+		return err
+	}
+
+	if *sandboxFlag {
+		report, err := sandbox.Apply(sandboxConfig(sandboxOptions{
+			MountBackend:   mountBackend,
+			HostPID:        *hostPIDFlag,
+			SELinuxRelabel: anySELinuxRelabel(plugins),
+			KubeletRootDir: *kubeletRootDirFlag,
+			DevModeDir:     *devModeDirFlag,
+			Plugins:        plugins,
+		}))
+		if err != nil {
+			return err
+		}
+		log.Info("Applied sandbox.", report.LogValues()...)
+	}
+
+	return mount.ServeHelper(mounter, helperPolicy(plugins))
+}
+
+// helperPolicy returns the policy the mount helper serves the plugins with.
+func helperPolicy(plugins []pluginSpec) mount.HelperPolicy {
+	policy := mount.HelperPolicy{
+		Beneath:      *kubeletRootDirFlag,
+		SelfTestDir:  *selfTestDirFlag,
+		TmpfsOptions: driver.CompositeTmpfsOptions,
+		HostPID:      *hostPIDFlag,
+	}
+	for _, plugin := range plugins {
+		policy.Sources = append(policy.Sources, plugin.WorkloadAPISocketDir)
+		if plugin.WorkloadAPISocketName != "" {
+			policy.Sockets = append(policy.Sockets, filepath.Join(plugin.WorkloadAPISocketDir, plugin.WorkloadAPISocketName))
+		}
+		for _, name := range driver.InnerMountNames(plugin.WorkloadAPISocketName, plugin.SocketMountName) {
+			if !slices.Contains(policy.InnerNames, name) {
+				policy.InnerNames = append(policy.InnerNames, name)
+			}
+		}
+	}
+	return policy
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spiffe/spiffe-csi/pkg/driver"
+	"github.com/spiffe/spiffe-csi/pkg/mount"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHelperPolicy(t *testing.T) {
+	plugins := []pluginSpec{
+		{Name: "a", WorkloadAPISocketDir: "/run/a"},
+		{Name: "b", WorkloadAPISocketDir: "/run/b", WorkloadAPISocketName: "agent.sock", SocketMountName: "socket"},
+		{Name: "c", WorkloadAPISocketDir: "/run/c", WorkloadAPISocketName: "agent.sock"},
+	}
+	assert.Equal(t, mount.HelperPolicy{
+		Beneath:      "/var/lib/kubelet",
+		InnerNames:   []string{"workload-api", "agent.sock", "socket"},
+		Sources:      []string{"/run/a", "/run/b", "/run/c"},
+		Sockets:      []string{"/run/b/agent.sock", "/run/c/agent.sock"},
+		TmpfsOptions: driver.CompositeTmpfsOptions,
+	}, helperPolicy(plugins))
+}
@@ -2,27 +2,69 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"strconv"
 
 	"github.com/go-logr/logr"
 	"github.com/go-logr/zapr"
 	"github.com/spiffe/spiffe-csi/internal/version"
 	"github.com/spiffe/spiffe-csi/pkg/driver"
 	"github.com/spiffe/spiffe-csi/pkg/logkeys"
+	"github.com/spiffe/spiffe-csi/pkg/mount"
+	"github.com/spiffe/spiffe-csi/pkg/sandbox"
 	"github.com/spiffe/spiffe-csi/pkg/server"
 	"go.uber.org/zap"
 )
 
 var (
-	nodeIDFlag               = flag.String("node-id", "", "Kubernetes Node ID. If unset, the node ID is obtained from the environment (i.e., -node-id-env)")
-	nodeIDEnvFlag            = flag.String("node-id-env", "MY_NODE_NAME", "Envvar from which to obtain the node ID. Overridden by -node-id.")
-	csiSocketPathFlag        = flag.String("csi-socket-path", "/spiffe-csi/csi.sock", "Path to the CSI socket")
-	pluginNameFlag           = flag.String("plugin-name", "csi.spiffe.io", "Plugin name to register")
-	workloadAPISocketDirFlag = flag.String("workload-api-socket-dir", "", "Path to the Workload API socket directory")
+	nodeIDFlag                 = flag.String("node-id", "", "Kubernetes Node ID. If unset, the node ID is obtained from the environment (i.e., -node-id-env)")
+	nodeIDEnvFlag              = flag.String("node-id-env", "MY_NODE_NAME", "Envvar from which to obtain the node ID. Overridden by -node-id.")
+	csiSocketPathFlag          = flag.String("csi-socket-path", "/spiffe-csi/csi.sock", "Path to the CSI socket")
+	pluginNameFlag             = flag.String("plugin-name", "csi.spiffe.io", "Plugin name to register")
+	workloadAPISocketDirFlag   = flag.String("workload-api-socket-dir", "", "Path to the Workload API socket directory")
+	workloadAPISocketNameFlag  = flag.String("workload-api-socket-name", "", "Name of the Workload API socket inside the Workload API socket directory. Required by the socket and composite volume layouts.")
+	socketMountNameFlag        = flag.String("socket-mount-name", "", "Name the Workload API socket is exposed under when only the socket is bind mounted (e.g. \"socket\"). Defaults to -workload-api-socket-name.")
+	volumeLayoutFlag           = flag.String("volume-layout", string(driver.DirectoryLayout), "Layout of published volumes. One of: directory, socket, composite")
+	compositeSocketOnlyFlag    = flag.Bool("composite-socket-only", false, "With the composite volume layout, bind mount only the Workload API socket instead of its whole directory")
+	trustDomainFlag            = flag.String("trust-domain", "", "Trust domain name written into composite volumes")
+	hardenMountsFlag           = flag.Bool("harden-mounts", false, "Make the host-side bind mounts nosuid, nodev and noexec")
+	readOnlyHostMountsFlag     = flag.Bool("read-only-host-mounts", false, "Make the host-side bind mounts read-only")
+	seLinuxRelabelFlag         = flag.Bool("selinux-relabel", false, "Relabel the Workload API socket (directory) with the SELinux label requested by volumes, without its MCS categories")
+	collapseStackedMountsFlag  = flag.Bool("collapse-stacked-mounts", false, "On startup, collapse identical mounts of the Workload API stacked on the same target path (left behind by kubelet restarts with driver versions before 0.2.12) to a single layer")
+	lazyUnmountFlag            = flag.Bool("lazy-unmount", false, "Lazily detach volume mounts that are still busy after retrying to unmount them on unpublish")
+	mountTimeoutFlag           = flag.Duration("mount-timeout", driver.DefaultMountTimeout, "How long publishing or unpublishing a volume may take before the call fails with DeadlineExceeded")
+	healthCheckTimeoutFlag     = flag.Duration("health-check-timeout", driver.DefaultHealthCheckTimeout, "How long checking the health of a volume may take before the call fails with DeadlineExceeded")
+	maxBlockingCallsFlag       = flag.Int("max-blocking-calls", driver.DefaultMaxBlockingCalls, "Number of mount and unmount operations, and separately of health check operations, that may be in progress at once per plugin, including timed out ones that have not returned yet")
+	mountPropagationFlag       = flag.String("mount-propagation", "", "Propagation type set on volume mounts after they are made. One of: private, slave, unbindable. Unset keeps the propagation inherited from the kubelet pods directory.")
+	hostPIDFlag                = flag.Int("host-pid", 0, "PID of a process in the host mount namespace (e.g. 1 with hostPID: true, or the kubelet). If set, volume mounts are checked to have propagated to its mount namespace before volumes are reported as published.")
+	selfTestDirFlag            = flag.String("self-test-dir", "", "Directory on the bidirectionally propagated kubelet pods directory mount (e.g. /var/lib/kubelet/pods) in which to check on startup that mounts propagate to the mount namespace of -host-pid")
+	mountBackendFlag           = flag.String("mount-backend", mount.LocalBackend, "Where volume mounts are made. One of: local (the mount namespace of the driver), host-namespace (the mount namespace of -host-pid, or PID 1 if unset)")
+	mountHelperFlag            = flag.Bool("mount-helper", false, "Make volume mounts in a separate helper process, which only performs mount operations on target paths below -kubelet-root-dir with the Workload API socket directories as sources, so that the process serving CSI requests keeps no mount privileges")
+	devModeFlag                = flag.Bool("dev-mode", false, "Development mode: simulate volume mounts with symlinks under -dev-mode-dir instead of mounting, so the driver runs without privileges. Never use in production.")
+	devModeDirFlag             = flag.String("dev-mode-dir", filepath.Join(os.TempDir(), "spiffe-csi-dev"), "Scratch directory in which development mode keeps track of volume mounts")
+	kubeletRootDirFlag         = flag.String("kubelet-root-dir", "/var/lib/kubelet", "Root directory of the kubelet. Target and volume paths have to match <dir>/pods/<pod UID>/volumes/kubernetes.io~csi/<volume>/mount and are resolved beneath it without following symlinks. Empty disables the checks.")
+	sourceCheckFlag            = flag.String("source-check", string(driver.SourceCheckWarn), "What to do when the Workload API socket directory or socket has unexpected ownership or permissions. One of: warn (log and carry on), enforce (refuse to start and to publish volumes)")
+	agentUIDFlag               = flag.Int("agent-uid", 0, "UID besides root that may own the Workload API socket directory and socket")
+	workloadAPISocketModeFlag  = flag.String("workload-api-socket-mode", fmt.Sprintf("%#o", driver.DefaultWorkloadAPISocketMode), "Expected permission bits of the Workload API socket, in octal")
+	allowedPeerUIDsFlag        = flag.String("allowed-peer-uids", "", "Comma-separated UIDs of the processes allowed to connect to the CSI socket (e.g. 0 for the kubelet running as root). Unset allows every UID.")
+	allowedPeerExecutablesFlag = flag.String("allowed-peer-executables", "", "Comma-separated paths of the executables allowed to connect to the CSI socket, as seen through /proc/<pid>/exe. Unset allows every executable.")
+	csiSocketModeFlag          = flag.String("csi-socket-mode", "", "Permission bits given to the CSI socket, in octal (e.g. 0600). Unset leaves them as created.")
+	csiSocketGroupFlag         = flag.Int("csi-socket-group", -1, "GID given to the CSI socket. -1 leaves it as created.")
+	sandboxFlag                = flag.Bool("sandbox", true, "Once started, drop the capabilities the driver does not need and, if volumes are not mounted by the driver process, confine its filesystem access with Landlock. Requires a build without cgo.")
+	diagnosticsFlag            = flag.Bool("diagnostics", false, "Start up as configured, apply the sandbox, print the restrictions in place as JSON and exit without serving")
+	pluginFlags                pluginsFlag
 )
 
+func init() {
+	flag.Var(&pluginFlags, "plugin", "Plugin to serve, as comma-separated name=,csi-socket-path=,workload-api-socket-dir= fields. May be repeated. Overrides -plugin-name, -csi-socket-path and -workload-api-socket-dir.")
+}
+
 func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "%s (version %s)\n", "spiffe-csi-driver", version.Version())
@@ -43,37 +85,302 @@ func main() {
 
 	nodeID := getNodeIDFromFlags()
 
+	plugins := getPluginSpecsFromFlags()
+	if err := validatePluginSpecs(plugins); err != nil {
+		log.Error(err, "Invalid plugin configuration")
+		os.Exit(1)
+	}
+
+	mountBackend := *mountBackendFlag
+	if *devModeFlag {
+		if mountBackend != mount.LocalBackend {
+			log.Error(fmt.Errorf("-dev-mode cannot be combined with -mount-backend=%s", mountBackend), "Invalid mount configuration")
+			os.Exit(1)
+		}
+		mountBackend = mount.DevBackend
+	}
+	if *mountHelperFlag && *kubeletRootDirFlag == "" {
+		log.Error(errors.New("-mount-helper requires -kubelet-root-dir"), "Invalid mount configuration")
+		os.Exit(1)
+	}
+	backendOptions := mount.BackendOptions{
+		HostPID:        *hostPIDFlag,
+		ScratchDir:     *devModeDirFlag,
+		KubeletRootDir: *kubeletRootDirFlag,
+	}
+
+	if mount.IsHelperProcess() {
+		helperLog := log.WithValues(logkeys.MountHelper, true)
+		if err := runMountHelper(helperLog, mountBackend, backendOptions, plugins); err != nil {
+			helperLog.Error(err, "Mount helper failed")
+			os.Exit(1)
+		}
+		return
+	}
+
 	log.Info("Starting.",
 		logkeys.Version, version.Version(),
 		logkeys.NodeID, nodeID,
-		logkeys.WorkloadAPISocketDir, *workloadAPISocketDirFlag,
-		logkeys.CSISocketPath, *csiSocketPathFlag,
 	)
 
-	driver, err := driver.New(driver.Config{
-		Log:                  log,
-		NodeID:               nodeID,
-		PluginName:           *pluginNameFlag,
-		WorkloadAPISocketDir: *workloadAPISocketDirFlag,
-	})
+	if *devModeFlag {
+		log.Info("Running in development mode; volumes are not mounted.", logkeys.DevModeDir, *devModeDirFlag)
+	}
+	var mounter mount.Mounter
+	var mountHelper *mount.Helper
+	if *mountHelperFlag {
+		mountHelper, err = mount.StartHelper(os.Args[1:])
+		if err != nil {
+			log.Error(err, "Failed to start mount helper")
+			os.Exit(1)
+		}
+		mounter = mountHelper
+	} else {
+		mounter, err = mount.NewBackend(mountBackend, backendOptions)
+		if err != nil {
+			log.Error(err, "Failed to create mount backend")
+			os.Exit(1)
+		}
+	}
+
+	workloadAPISocketMode, err := strconv.ParseUint(*workloadAPISocketModeFlag, 8, 32)
 	if err != nil {
-		log.Error(err, "Failed to create driver")
+		log.Error(err, "Invalid Workload API socket mode")
 		os.Exit(1)
 	}
+	var csiSocketMode uint64
+	if *csiSocketModeFlag != "" {
+		csiSocketMode, err = strconv.ParseUint(*csiSocketModeFlag, 8, 32)
+		if err != nil {
+			log.Error(err, "Invalid CSI socket mode")
+			os.Exit(1)
+		}
+	}
+	var csiSocketGroup *int
+	switch {
+	case *csiSocketGroupFlag >= 0:
+		csiSocketGroup = csiSocketGroupFlag
+	case *csiSocketGroupFlag != -1:
+		log.Error(fmt.Errorf("invalid GID %d", *csiSocketGroupFlag), "Invalid CSI socket group")
+		os.Exit(1)
+	}
+	peerPolicy, err := parsePeerPolicy(*allowedPeerUIDsFlag, *allowedPeerExecutablesFlag)
+	if err != nil {
+		log.Error(err, "Invalid peer policy")
+		os.Exit(1)
+	}
+
+	serverConfigs := make([]server.Config, 0, len(plugins))
+	for _, plugin := range plugins {
+		pluginLog := log.WithValues(logkeys.PluginName, plugin.Name)
+		pluginLog.Info("Configuring plugin.",
+			logkeys.WorkloadAPISocketDir, plugin.WorkloadAPISocketDir,
+			logkeys.CSISocketPath, plugin.CSISocketPath,
+		)
 
-	serverConfig := server.Config{
-		Log:           log,
-		CSISocketPath: *csiSocketPathFlag,
-		Driver:        driver,
+		driver, err := driver.New(driver.Config{
+			Log:                   pluginLog,
+			NodeID:                nodeID,
+			PluginName:            plugin.Name,
+			WorkloadAPISocketDir:  plugin.WorkloadAPISocketDir,
+			WorkloadAPISocketName: plugin.WorkloadAPISocketName,
+			SocketMountName:       plugin.SocketMountName,
+			VolumeLayout:          driver.VolumeLayout(plugin.VolumeLayout),
+			CompositeSocketOnly:   *plugin.CompositeSocketOnly,
+			TrustDomain:           plugin.TrustDomain,
+			HardenMounts:          *plugin.HardenMounts,
+			ReadOnlyHostMounts:    *plugin.ReadOnlyHostMounts,
+			SELinuxRelabel:        *plugin.SELinuxRelabel,
+			LazyUnmount:           *lazyUnmountFlag,
+			MountPropagation:      mount.Propagation(plugin.MountPropagation),
+			HostPID:               *hostPIDFlag,
+			Mounter:               mounter,
+			KubeletRootDir:        *kubeletRootDirFlag,
+			SourceCheck:           driver.SourceCheckMode(plugin.SourceCheck),
+			AgentUID:              *plugin.AgentUID,
+			WorkloadAPISocketMode: os.FileMode(workloadAPISocketMode),
+			MountTimeout:          *mountTimeoutFlag,
+			HealthCheckTimeout:    *healthCheckTimeoutFlag,
+			MaxBlockingCalls:      *maxBlockingCallsFlag,
+		})
+		if err != nil {
+			pluginLog.Error(err, "Failed to create driver")
+			os.Exit(1)
+		}
+
+		if *selfTestDirFlag != "" {
+			if err := driver.SelfTest(*selfTestDirFlag); err != nil {
+				pluginLog.Error(err, "Self-test failed")
+				os.Exit(1)
+			}
+			pluginLog.Info("Self-test passed.")
+		}
+
+		if *collapseStackedMountsFlag {
+			// Failing to clean up is no reason not to serve.
+			removed, err := driver.CollapseStackedMounts()
+			if err != nil {
+				pluginLog.Error(err, "Failed to collapse stacked mounts", logkeys.Removed, removed)
+			} else {
+				pluginLog.Info("Collapsed stacked mounts.", logkeys.Removed, removed)
+			}
+		}
+
+		serverConfigs = append(serverConfigs, server.Config{
+			Log:           pluginLog,
+			CSISocketPath: plugin.CSISocketPath,
+			Driver:        driver,
+			PeerPolicy:    peerPolicy,
+			SocketMode:    os.FileMode(csiSocketMode),
+			SocketGroup:   csiSocketGroup,
+		})
 	}
 
-	if err := server.Run(serverConfig); err != nil {
+	// Malformed mount info records are skipped rather than failing every
+	// lookup of a mount. Those met while starting up are reported, so that
+	// they do not go unnoticed.
+	if skipped := mount.SkippedMountInfoRecords(); skipped > 0 {
+		log.Info("Skipped malformed mount info records.", logkeys.Skipped, skipped)
+	}
+
+	// The self-tests are done, so the mount helper can stop accepting their
+	// mount points.
+	if mountHelper != nil {
+		if err := mountHelper.EndSelfTest(); err != nil {
+			log.Error(err, "Failed to end mount helper self-tests")
+			os.Exit(1)
+		}
+	}
+
+	// The CSI sockets are created before the sandbox is applied: moving a
+	// socket into place once its mode and group are set is a rename across
+	// directories, which Landlock denies outright before ABI 2, and handing
+	// it to its group takes CAP_CHOWN. Nothing is served with -diagnostics,
+	// so sockets of a driver that may be running are left alone.
+	var listeners []net.Listener
+	if !*diagnosticsFlag {
+		for _, serverConfig := range serverConfigs {
+			listener, err := server.Listen(serverConfig)
+			if err != nil {
+				serverConfig.Log.Error(err, "Failed to create CSI socket")
+				os.Exit(1)
+			}
+			listeners = append(listeners, listener)
+		}
+	}
+
+	report := sandbox.Report{Landlock: sandbox.LandlockReport{Reason: "the sandbox is disabled"}}
+	if *sandboxFlag {
+		report, err = sandbox.Apply(sandboxConfig(sandboxOptions{
+			MountBackend:    mountBackend,
+			MountHelper:     *mountHelperFlag,
+			HostPID:         *hostPIDFlag,
+			SELinuxRelabel:  anySELinuxRelabel(plugins),
+			PeerExecutables: len(peerPolicy.Executables) > 0,
+			KubeletRootDir:  *kubeletRootDirFlag,
+			DevModeDir:      *devModeDirFlag,
+			Plugins:         plugins,
+		}))
+		if err != nil {
+			log.Error(err, "Failed to apply sandbox")
+			os.Exit(1)
+		}
+		log.Info("Applied sandbox.", report.LogValues()...)
+	} else {
+		log.Info("Sandbox disabled.")
+	}
+	if *diagnosticsFlag {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			log.Error(err, "Failed to write diagnostics")
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Each plugin is served by its own gRPC server. The process exits as
+	// soon as any of them stops serving, or the mount helper exits.
+	errCh := make(chan error, len(serverConfigs)+1)
+	if mountHelper != nil {
+		go func() {
+			errCh <- fmt.Errorf("mount helper exited: %v", mountHelper.Wait())
+		}()
+	}
+	for i, serverConfig := range serverConfigs {
+		go func() {
+			if err := server.Serve(serverConfig, listeners[i]); err != nil {
+				errCh <- fmt.Errorf("plugin %q: %w", plugins[i].Name, err)
+				return
+			}
+			errCh <- nil
+		}()
+	}
+	if err := <-errCh; err != nil {
 		log.Error(err, "Failed to serve")
 		os.Exit(1)
 	}
 	log.Info("Done")
 }
 
+func getPluginSpecsFromFlags() []pluginSpec {
+	specs := []pluginSpec(pluginFlags)
+	if len(specs) == 0 {
+		specs = []pluginSpec{{
+			Name:                 *pluginNameFlag,
+			CSISocketPath:        *csiSocketPathFlag,
+			WorkloadAPISocketDir: *workloadAPISocketDirFlag,
+		}}
+	}
+	for i := range specs {
+		if specs[i].WorkloadAPISocketName == "" {
+			specs[i].WorkloadAPISocketName = *workloadAPISocketNameFlag
+		}
+		if specs[i].SocketMountName == "" {
+			specs[i].SocketMountName = *socketMountNameFlag
+		}
+		if specs[i].VolumeLayout == "" {
+			specs[i].VolumeLayout = *volumeLayoutFlag
+		}
+		if specs[i].CompositeSocketOnly == nil {
+			specs[i].CompositeSocketOnly = compositeSocketOnlyFlag
+		}
+		if specs[i].TrustDomain == "" {
+			specs[i].TrustDomain = *trustDomainFlag
+		}
+		if specs[i].HardenMounts == nil {
+			specs[i].HardenMounts = hardenMountsFlag
+		}
+		if specs[i].ReadOnlyHostMounts == nil {
+			specs[i].ReadOnlyHostMounts = readOnlyHostMountsFlag
+		}
+		if specs[i].SELinuxRelabel == nil {
+			specs[i].SELinuxRelabel = seLinuxRelabelFlag
+		}
+		if specs[i].MountPropagation == "" {
+			specs[i].MountPropagation = *mountPropagationFlag
+		}
+		if specs[i].SourceCheck == "" {
+			specs[i].SourceCheck = *sourceCheckFlag
+		}
+		if specs[i].AgentUID == nil {
+			specs[i].AgentUID = agentUIDFlag
+		}
+	}
+	return specs
+}
+
+// anySELinuxRelabel returns whether any of the plugins relabels the Workload
+// API socket.
+func anySELinuxRelabel(plugins []pluginSpec) bool {
+	for _, plugin := range plugins {
+		if *plugin.SELinuxRelabel {
+			return true
+		}
+	}
+	return false
+}
+
 func getNodeIDFromFlags() string {
 	nodeID := os.Getenv(*nodeIDEnvFlag)
 	if *nodeIDFlag != "" {
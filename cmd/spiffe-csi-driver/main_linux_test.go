@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// driverTestEnv is set when the test binary is run as the driver.
+const driverTestEnv = "SPIFFE_CSI_DRIVER_TEST"
+
+func TestMain(m *testing.M) {
+	if _, ok := os.LookupEnv(driverTestEnv); ok {
+		main()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func TestServeSandboxedWithSocketPermissions(t *testing.T) {
+	// The test binary shares the build mode of the driver process.
+	if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_GETPID, 0, 0, 0); errno == syscall.ENOTSUP {
+		t.Skip("the sandbox cannot be applied to a test binary built with cgo; run with CGO_ENABLED=0")
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("handing the CSI socket to another group requires root")
+	}
+
+	const csiSocketGroup = 1000
+	base := t.TempDir()
+	kubeletRootDir := filepath.Join(base, "kubelet")
+	workloadAPISocketDir := filepath.Join(base, "workload-api")
+	csiSocketPath := filepath.Join(base, "csi", "csi.sock")
+	for _, dir := range []string{kubeletRootDir, workloadAPISocketDir, filepath.Dir(csiSocketPath)} {
+		require.NoError(t, os.Mkdir(dir, 0755))
+	}
+
+	// With the dev backend and a kubelet root directory, the driver keeps no
+	// capabilities and is confined by Landlock, so the socket has to be
+	// given its mode and group and moved into place before the sandbox is
+	// applied.
+	exe, err := os.Executable()
+	require.NoError(t, err)
+	cmd := exec.Command(exe,
+		"-node-id", "node",
+		"-dev-mode",
+		"-dev-mode-dir", filepath.Join(base, "dev"),
+		"-kubelet-root-dir", kubeletRootDir,
+		"-workload-api-socket-dir", workloadAPISocketDir,
+		"-csi-socket-path", csiSocketPath,
+		"-csi-socket-mode", "0660",
+		"-csi-socket-group", "1000",
+	)
+	output, err := os.Create(filepath.Join(base, "output"))
+	require.NoError(t, err)
+	defer output.Close()
+	cmd.Env = append(os.Environ(), driverTestEnv+"=1")
+	cmd.Stdout, cmd.Stderr = output, output
+	require.NoError(t, cmd.Start())
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		<-exited
+		if t.Failed() {
+			logged, _ := os.ReadFile(output.Name())
+			t.Logf("driver output:\n%s", logged)
+		}
+	})
+
+	require.EventuallyWithT(t, func(c *assert.CollectT) {
+		select {
+		case err := <-exited:
+			exited <- err
+			require.FailNow(c, "driver exited", "%v", err)
+		default:
+		}
+		info, err := os.Lstat(csiSocketPath)
+		require.NoError(c, err)
+		assert.Equal(c, os.ModeSocket|0660, info.Mode())
+		assert.EqualValues(c, csiSocketGroup, info.Sys().(*syscall.Stat_t).Gid)
+	}, 10*time.Second, 10*time.Millisecond)
+
+	conn, err := grpc.NewClient("unix://"+csiSocketPath, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	resp, err := csi.NewIdentityClient(conn).GetPluginInfo(context.Background(), &csi.GetPluginInfoRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "csi.spiffe.io", resp.Name)
+	logged, err := os.ReadFile(output.Name())
+	require.NoError(t, err)
+	assert.Contains(t, string(logged), `"landlockEnforced": true`)
+}
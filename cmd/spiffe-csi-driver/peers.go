@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spiffe/spiffe-csi/pkg/server"
+)
+
+// parsePeerPolicy parses the comma-separated UIDs and executable paths of
+// the -allowed-peer-uids and -allowed-peer-executables flags.
+func parsePeerPolicy(uids, executables string) (server.PeerPolicy, error) {
+	var policy server.PeerPolicy
+	for _, s := range splitList(uids) {
+		uid, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return server.PeerPolicy{}, fmt.Errorf("invalid peer UID %q", s)
+		}
+		policy.UIDs = append(policy.UIDs, uint32(uid))
+	}
+	for _, s := range splitList(executables) {
+		if !filepath.IsAbs(s) {
+			return server.PeerPolicy{}, fmt.Errorf("invalid peer executable %q: must be an absolute path", s)
+		}
+		policy.Executables = append(policy.Executables, filepath.Clean(s))
+	}
+	return policy, nil
+}
+
+// splitList splits a comma-separated list, dropping empty elements.
+func splitList(s string) []string {
+	var list []string
+	for _, elem := range strings.Split(s, ",") {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			list = append(list, elem)
+		}
+	}
+	return list
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spiffe/spiffe-csi/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePeerPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		desc         string
+		uids         string
+		executables  string
+		expectPolicy server.PeerPolicy
+		expectErr    string
+	}{
+		{
+			desc: "unset",
+		},
+		{
+			desc:         "UIDs and executables",
+			uids:         "0, 1000,",
+			executables:  "/usr/bin/kubelet,/csi-node-driver-registrar/",
+			expectPolicy: server.PeerPolicy{UIDs: []uint32{0, 1000}, Executables: []string{"/usr/bin/kubelet", "/csi-node-driver-registrar"}},
+		},
+		{
+			desc:      "invalid UID",
+			uids:      "root",
+			expectErr: `invalid peer UID "root"`,
+		},
+		{
+			desc:      "negative UID",
+			uids:      "-1",
+			expectErr: `invalid peer UID "-1"`,
+		},
+		{
+			desc:        "relative executable",
+			executables: "kubelet",
+			expectErr:   `invalid peer executable "kubelet": must be an absolute path`,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			policy, err := parsePeerPolicy(tt.uids, tt.executables)
+			if tt.expectErr != "" {
+				require.EqualError(t, err, tt.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectPolicy, policy)
+		})
+	}
+}
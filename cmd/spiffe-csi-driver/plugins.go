@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pluginSpec describes a single CSI plugin served by this process.
+type pluginSpec struct {
+	Name                 string
+	CSISocketPath        string
+	WorkloadAPISocketDir string
+
+	// The following fields are optional and default to the value of the
+	// corresponding global flag.
+	WorkloadAPISocketName string
+	SocketMountName       string
+	VolumeLayout          string
+	CompositeSocketOnly   *bool
+	TrustDomain           string
+	HardenMounts          *bool
+	ReadOnlyHostMounts    *bool
+	SELinuxRelabel        *bool
+	MountPropagation      string
+	SourceCheck           string
+	AgentUID              *int
+}
+
+// pluginsFlag collects repeated -plugin flags. Each value is a
+// comma-separated list of key=value pairs, e.g.:
+//
+//	name=csi.example.org,csi-socket-path=/example-csi/csi.sock,workload-api-socket-dir=/example-agent-socket
+//
+// The workload-api-socket-name, socket-mount-name, volume-layout,
+// composite-socket-only, trust-domain, harden-mounts, read-only-host-mounts,
+// selinux-relabel, mount-propagation, source-check and agent-uid keys are
+// optional and override the corresponding global flags for that plugin.
+type pluginsFlag []pluginSpec
+
+func (f *pluginsFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	names := make([]string, 0, len(*f))
+	for _, spec := range *f {
+		names = append(names, spec.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func (f *pluginsFlag) Set(value string) error {
+	spec, err := parsePluginSpec(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, spec)
+	return nil
+}
+
+func parsePluginSpec(value string) (pluginSpec, error) {
+	var spec pluginSpec
+	var err error
+	for _, kv := range strings.Split(value, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return pluginSpec{}, fmt.Errorf("invalid plugin field %q: expected key=value", kv)
+		}
+		switch k {
+		case "name":
+			spec.Name = v
+		case "csi-socket-path":
+			spec.CSISocketPath = v
+		case "workload-api-socket-dir":
+			spec.WorkloadAPISocketDir = v
+		case "workload-api-socket-name":
+			spec.WorkloadAPISocketName = v
+		case "socket-mount-name":
+			spec.SocketMountName = v
+		case "volume-layout":
+			spec.VolumeLayout = v
+		case "composite-socket-only":
+			spec.CompositeSocketOnly, err = parseBoolField(k, v)
+		case "trust-domain":
+			spec.TrustDomain = v
+		case "harden-mounts":
+			spec.HardenMounts, err = parseBoolField(k, v)
+		case "read-only-host-mounts":
+			spec.ReadOnlyHostMounts, err = parseBoolField(k, v)
+		case "selinux-relabel":
+			spec.SELinuxRelabel, err = parseBoolField(k, v)
+		case "mount-propagation":
+			spec.MountPropagation = v
+		case "source-check":
+			spec.SourceCheck = v
+		case "agent-uid":
+			var uid int
+			uid, err = strconv.Atoi(v)
+			if err != nil {
+				err = fmt.Errorf("invalid plugin field %q: %w", k, err)
+			}
+			spec.AgentUID = &uid
+		default:
+			return pluginSpec{}, fmt.Errorf("unknown plugin field %q", k)
+		}
+		if err != nil {
+			return pluginSpec{}, err
+		}
+	}
+	switch {
+	case spec.Name == "":
+		return pluginSpec{}, errors.New("plugin name is required")
+	case spec.CSISocketPath == "":
+		return pluginSpec{}, fmt.Errorf("plugin %q: CSI socket path is required", spec.Name)
+	case spec.WorkloadAPISocketDir == "":
+		return pluginSpec{}, fmt.Errorf("plugin %q: workload API socket directory is required", spec.Name)
+	}
+	return spec, nil
+}
+
+func parseBoolField(k, v string) (*bool, error) {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid plugin field %q: %w", k, err)
+	}
+	return &b, nil
+}
+
+// validatePluginSpecs makes sure no two plugins claim the same name or CSI
+// socket path.
+func validatePluginSpecs(specs []pluginSpec) error {
+	names := make(map[string]struct{}, len(specs))
+	sockets := make(map[string]struct{}, len(specs))
+	for _, spec := range specs {
+		if _, ok := names[spec.Name]; ok {
+			return fmt.Errorf("plugin %q is configured more than once", spec.Name)
+		}
+		if _, ok := sockets[spec.CSISocketPath]; ok {
+			return fmt.Errorf("plugin %q: CSI socket path %q is already in use by another plugin", spec.Name, spec.CSISocketPath)
+		}
+		names[spec.Name] = struct{}{}
+		sockets[spec.CSISocketPath] = struct{}{}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPluginsFlag(t *testing.T) {
+	var f pluginsFlag
+	require.NoError(t, f.Set("name=csi.spiffe.io,csi-socket-path=/spiffe-csi/csi.sock,workload-api-socket-dir=/spire-agent-socket"))
+	require.NoError(t, f.Set("name=csi.example.org,csi-socket-path=/example-csi/csi.sock,workload-api-socket-dir=/example-agent-socket"))
+
+	assert.Equal(t, pluginsFlag{
+		{
+			Name:                 "csi.spiffe.io",
+			CSISocketPath:        "/spiffe-csi/csi.sock",
+			WorkloadAPISocketDir: "/spire-agent-socket",
+		},
+		{
+			Name:                 "csi.example.org",
+			CSISocketPath:        "/example-csi/csi.sock",
+			WorkloadAPISocketDir: "/example-agent-socket",
+		},
+	}, f)
+	assert.Equal(t, "csi.spiffe.io,csi.example.org", f.String())
+}
+
+func TestPluginsFlagPolicy(t *testing.T) {
+	var f pluginsFlag
+	require.NoError(t, f.Set("name=csi.spiffe.io,csi-socket-path=/spiffe-csi/csi.sock,workload-api-socket-dir=/spire-agent-socket"))
+	require.NoError(t, f.Set("name=csi.example.org,csi-socket-path=/example-csi/csi.sock,workload-api-socket-dir=/example-agent-socket,"+
+		"workload-api-socket-name=api.sock,socket-mount-name=socket,volume-layout=composite,composite-socket-only=true,trust-domain=example.org,"+
+		"harden-mounts=true,read-only-host-mounts=false,selinux-relabel=true,mount-propagation=private,source-check=enforce,agent-uid=1000"))
+
+	orig := pluginFlags
+	t.Cleanup(func() { pluginFlags = orig })
+	pluginFlags = f
+	specs := getPluginSpecsFromFlags()
+	require.Len(t, specs, 2)
+
+	// Unset fields take the global flags.
+	assert.Equal(t, *volumeLayoutFlag, specs[0].VolumeLayout)
+	assert.Equal(t, *sourceCheckFlag, specs[0].SourceCheck)
+	assert.False(t, *specs[0].HardenMounts)
+	assert.False(t, *specs[0].SELinuxRelabel)
+	assert.Equal(t, 0, *specs[0].AgentUID)
+
+	example := specs[1]
+	assert.Equal(t, "api.sock", example.WorkloadAPISocketName)
+	assert.Equal(t, "socket", example.SocketMountName)
+	assert.Equal(t, "composite", example.VolumeLayout)
+	assert.True(t, *example.CompositeSocketOnly)
+	assert.Equal(t, "example.org", example.TrustDomain)
+	assert.True(t, *example.HardenMounts)
+	assert.False(t, *example.ReadOnlyHostMounts)
+	assert.True(t, *example.SELinuxRelabel)
+	assert.Equal(t, "private", example.MountPropagation)
+	assert.Equal(t, "enforce", example.SourceCheck)
+	assert.Equal(t, 1000, *example.AgentUID)
+
+	assert.True(t, anySELinuxRelabel(specs))
+	assert.False(t, anySELinuxRelabel(specs[:1]))
+}
+
+func TestParsePluginSpecErrors(t *testing.T) {
+	for _, tt := range []struct {
+		desc      string
+		value     string
+		expectErr string
+	}{
+		{
+			desc:      "malformed field",
+			value:     "name",
+			expectErr: `invalid plugin field "name": expected key=value`,
+		},
+		{
+			desc:      "unknown field",
+			value:     "name=csi.spiffe.io,foo=bar",
+			expectErr: `unknown plugin field "foo"`,
+		},
+		{
+			desc:      "invalid boolean field",
+			value:     "name=csi.spiffe.io,harden-mounts=yes",
+			expectErr: `invalid plugin field "harden-mounts": strconv.ParseBool: parsing "yes": invalid syntax`,
+		},
+		{
+			desc:      "invalid agent UID",
+			value:     "name=csi.spiffe.io,agent-uid=spire",
+			expectErr: `invalid plugin field "agent-uid": strconv.Atoi: parsing "spire": invalid syntax`,
+		},
+		{
+			desc:      "missing name",
+			value:     "csi-socket-path=/spiffe-csi/csi.sock,workload-api-socket-dir=/spire-agent-socket",
+			expectErr: "plugin name is required",
+		},
+		{
+			desc:      "missing CSI socket path",
+			value:     "name=csi.spiffe.io,workload-api-socket-dir=/spire-agent-socket",
+			expectErr: `plugin "csi.spiffe.io": CSI socket path is required`,
+		},
+		{
+			desc:      "missing workload API socket directory",
+			value:     "name=csi.spiffe.io,csi-socket-path=/spiffe-csi/csi.sock",
+			expectErr: `plugin "csi.spiffe.io": workload API socket directory is required`,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, err := parsePluginSpec(tt.value)
+			require.EqualError(t, err, tt.expectErr)
+		})
+	}
+}
+
+func TestValidatePluginSpecs(t *testing.T) {
+	a := pluginSpec{Name: "a", CSISocketPath: "/a/csi.sock", WorkloadAPISocketDir: "/agent"}
+	b := pluginSpec{Name: "b", CSISocketPath: "/b/csi.sock", WorkloadAPISocketDir: "/agent"}
+
+	require.NoError(t, validatePluginSpecs([]pluginSpec{a, b}))
+
+	dupName := b
+	dupName.Name = a.Name
+	require.EqualError(t, validatePluginSpecs([]pluginSpec{a, dupName}), `plugin "a" is configured more than once`)
+
+	dupSocket := b
+	dupSocket.CSISocketPath = a.CSISocketPath
+	require.EqualError(t, validatePluginSpecs([]pluginSpec{a, dupSocket}), `plugin "b": CSI socket path "/a/csi.sock" is already in use by another plugin`)
+}
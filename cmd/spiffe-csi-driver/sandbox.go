@@ -0,0 +1,73 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/spiffe/spiffe-csi/pkg/mount"
+	"github.com/spiffe/spiffe-csi/pkg/sandbox"
+)
+
+// sandboxOptions are the parts of the driver configuration that determine
+// which privileges the driver keeps once it has started.
+type sandboxOptions struct {
+	MountBackend    string
+	MountHelper     bool
+	HostPID         int
+	SELinuxRelabel  bool
+	PeerExecutables bool
+	KubeletRootDir  string
+	DevModeDir      string
+	Plugins         []pluginSpec
+}
+
+// sandboxConfig returns the sandbox applied to the driver before it starts
+// serving.
+func sandboxConfig(opts sandboxOptions) sandbox.Config {
+	var config sandbox.Config
+
+	// Mounting takes CAP_SYS_ADMIN, opening sources and targets owned by
+	// other users CAP_DAC_READ_SEARCH, and writing the UID and GID maps of
+	// the user namespaces behind ID-mapped mounts CAP_SETUID and CAP_SETGID.
+	// With a mount helper, these fall to the helper.
+	mounts := opts.MountBackend != mount.DevBackend && !opts.MountHelper
+	if mounts {
+		config.Capabilities = append(config.Capabilities,
+			sandbox.CapDACReadSearch,
+			sandbox.CapSetGID,
+			sandbox.CapSetUID,
+			sandbox.CapSysAdmin,
+		)
+	}
+	// Joining a mount namespace takes CAP_SYS_CHROOT.
+	if mounts && opts.MountBackend == mount.HostNamespaceBackend {
+		config.Capabilities = append(config.Capabilities, sandbox.CapSysChroot)
+	}
+	// Inspecting processes the driver did not start (their mount namespace,
+	// mounts or executable) takes CAP_SYS_PTRACE.
+	if mounts && (opts.HostPID != 0 || opts.MountBackend == mount.HostNamespaceBackend) || opts.PeerExecutables {
+		config.Capabilities = append(config.Capabilities, sandbox.CapSysPtrace)
+	}
+	if mounts && opts.SELinuxRelabel {
+		config.Capabilities = append(config.Capabilities, sandbox.CapFowner)
+	}
+
+	switch {
+	case mounts:
+		config.LandlockSkipReason = "volumes are mounted by this process, which Landlock would deny"
+	case opts.KubeletRootDir == "":
+		config.LandlockSkipReason = "no kubelet root directory is configured"
+	default:
+		config.LandlockPaths = append(config.LandlockPaths, opts.KubeletRootDir)
+		for _, plugin := range opts.Plugins {
+			config.LandlockPaths = append(config.LandlockPaths,
+				plugin.WorkloadAPISocketDir,
+				filepath.Dir(plugin.CSISocketPath),
+			)
+		}
+		config.LandlockPaths = append(config.LandlockPaths, "/proc")
+		if opts.MountBackend == mount.DevBackend {
+			config.LandlockPaths = append(config.LandlockPaths, opts.DevModeDir)
+		}
+	}
+	return config
+}
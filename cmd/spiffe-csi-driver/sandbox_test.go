@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spiffe/spiffe-csi/pkg/mount"
+	"github.com/spiffe/spiffe-csi/pkg/sandbox"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSandboxConfig(t *testing.T) {
+	plugins := []pluginSpec{
+		{Name: "a", CSISocketPath: "/a/csi.sock", WorkloadAPISocketDir: "/run/a"},
+		{Name: "b", CSISocketPath: "/b/csi.sock", WorkloadAPISocketDir: "/run/b"},
+	}
+	mountSkipReason := "volumes are mounted by this process, which Landlock would deny"
+
+	for _, tt := range []struct {
+		desc         string
+		opts         sandboxOptions
+		expectConfig sandbox.Config
+	}{
+		{
+			desc: "local",
+			opts: sandboxOptions{MountBackend: mount.LocalBackend, KubeletRootDir: "/var/lib/kubelet", Plugins: plugins},
+			expectConfig: sandbox.Config{
+				Capabilities:       []sandbox.Capability{sandbox.CapDACReadSearch, sandbox.CapSetGID, sandbox.CapSetUID, sandbox.CapSysAdmin},
+				LandlockSkipReason: mountSkipReason,
+			},
+		},
+		{
+			desc: "local with host PID and SELinux relabeling",
+			opts: sandboxOptions{MountBackend: mount.LocalBackend, HostPID: 1, SELinuxRelabel: true},
+			expectConfig: sandbox.Config{
+				Capabilities: []sandbox.Capability{
+					sandbox.CapDACReadSearch, sandbox.CapSetGID, sandbox.CapSetUID, sandbox.CapSysAdmin,
+					sandbox.CapSysPtrace, sandbox.CapFowner,
+				},
+				LandlockSkipReason: mountSkipReason,
+			},
+		},
+		{
+			desc: "host namespace",
+			opts: sandboxOptions{MountBackend: mount.HostNamespaceBackend},
+			expectConfig: sandbox.Config{
+				Capabilities: []sandbox.Capability{
+					sandbox.CapDACReadSearch, sandbox.CapSetGID, sandbox.CapSetUID, sandbox.CapSysAdmin,
+					sandbox.CapSysChroot, sandbox.CapSysPtrace,
+				},
+				LandlockSkipReason: mountSkipReason,
+			},
+		},
+		{
+			desc: "local with mount helper",
+			opts: sandboxOptions{MountBackend: mount.LocalBackend, MountHelper: true, HostPID: 1, SELinuxRelabel: true, KubeletRootDir: "/var/lib/kubelet", DevModeDir: "/tmp/dev", Plugins: plugins},
+			expectConfig: sandbox.Config{
+				LandlockPaths: []string{"/var/lib/kubelet", "/run/a", "/a", "/run/b", "/b", "/proc"},
+			},
+		},
+		{
+			desc: "host namespace with mount helper and peer executables",
+			opts: sandboxOptions{MountBackend: mount.HostNamespaceBackend, MountHelper: true, PeerExecutables: true, KubeletRootDir: "/var/lib/kubelet"},
+			expectConfig: sandbox.Config{
+				Capabilities:  []sandbox.Capability{sandbox.CapSysPtrace},
+				LandlockPaths: []string{"/var/lib/kubelet", "/proc"},
+			},
+		},
+		{
+			desc: "dev",
+			opts: sandboxOptions{MountBackend: mount.DevBackend, HostPID: 1, KubeletRootDir: "/var/lib/kubelet", DevModeDir: "/tmp/dev", Plugins: plugins},
+			expectConfig: sandbox.Config{
+				LandlockPaths: []string{"/var/lib/kubelet", "/run/a", "/a", "/run/b", "/b", "/proc", "/tmp/dev"},
+			},
+		},
+		{
+			desc: "dev with peer executables",
+			opts: sandboxOptions{MountBackend: mount.DevBackend, PeerExecutables: true, KubeletRootDir: "/var/lib/kubelet", DevModeDir: "/tmp/dev"},
+			expectConfig: sandbox.Config{
+				Capabilities:  []sandbox.Capability{sandbox.CapSysPtrace},
+				LandlockPaths: []string{"/var/lib/kubelet", "/proc", "/tmp/dev"},
+			},
+		},
+		{
+			desc: "dev without kubelet root",
+			opts: sandboxOptions{MountBackend: mount.DevBackend, DevModeDir: "/tmp/dev"},
+			expectConfig: sandbox.Config{
+				LandlockSkipReason: "no kubelet root directory is configured",
+			},
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			assert.Equal(t, tt.expectConfig, sandboxConfig(tt.opts))
+		})
+	}
+}
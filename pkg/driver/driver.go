@@ -6,6 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/go-logr/logr"
@@ -16,30 +19,157 @@ import (
 	"google.golang.org/grpc/status"
 )
 
-var (
-	// We replace these in tests since bind mounting generally requires root.
-	bindMountRW  = mount.BindMountRW
-	unmount      = mount.Unmount
-	isMountPoint = mount.IsMountPoint
-)
-
 // Config is the configuration for the driver
 type Config struct {
 	Log                  logr.Logger
 	NodeID               string
 	PluginName           string
 	WorkloadAPISocketDir string
+
+	// WorkloadAPISocketName is the name of the Workload API socket inside
+	// WorkloadAPISocketDir. It is required by the socket and composite
+	// layouts.
+	WorkloadAPISocketName string
+
+	// SocketMountName is the name the Workload API socket is exposed under
+	// when only the socket is bind mounted (e.g. "socket"). Defaults to
+	// WorkloadAPISocketName.
+	SocketMountName string
+
+	// VolumeLayout is the layout of published volumes. Defaults to
+	// DirectoryLayout.
+	VolumeLayout VolumeLayout
+
+	// CompositeSocketOnly, when using the composite layout, bind mounts
+	// only the Workload API socket into the volume instead of the whole
+	// socket directory.
+	CompositeSocketOnly bool
+
+	// TrustDomain is the trust domain name written into composite volumes.
+	// If unset, no trust domain file is generated.
+	TrustDomain string
+
+	// HardenMounts makes the host-side bind mounts of the Workload API
+	// socket (directory) nosuid, nodev and noexec.
+	HardenMounts bool
+
+	// ReadOnlyHostMounts makes the host-side bind mounts of the Workload
+	// API socket (directory) read-only. Workload containers always get a
+	// read-only mount regardless, since the kubelet mounts the volume
+	// read-only into them.
+	ReadOnlyHostMounts bool
+
+	// SELinuxRelabel relabels the Workload API socket (directory) with the
+	// label requested through the SELinux "context=" mount option, with
+	// the MCS categories removed so that the socket stays accessible to
+	// every pod. Ignored if SELinux is not enabled. Without it, the
+	// "context=" mount option is only accepted by the composite layout.
+	SELinuxRelabel bool
+
+	// LazyUnmount lazily detaches (MNT_DETACH) volume mounts that are still
+	// busy after the unmount retries on unpublish.
+	LazyUnmount bool
+
+	// MountPropagation is the propagation type set on the volume mounts
+	// after they are made. By default, mounts inherit the propagation of
+	// the kubelet pods directory, which is shared.
+	MountPropagation mount.Propagation
+
+	// HostPID is the PID of a process in the host mount namespace, e.g. 1
+	// when running in the host PID namespace, or the kubelet. If set, each
+	// volume mount is checked to have propagated to the mount namespace of
+	// that process before the volume is reported as published.
+	HostPID int
+
+	// Mounter performs the mount operations. Defaults to mount.Local.
+	Mounter mount.Mounter
+
+	// KubeletRootDir is the root directory of the kubelet (e.g.
+	// /var/lib/kubelet). If set, target and volume paths have to be
+	// volume paths of the kubelet below it (see checkTargetPath), and
+	// target paths are created and removed without following symlinks in
+	// any of their components. The Mounter should then be configured to resolve
+	// mount points beneath it as well (see mount.Local.Beneath). Requires
+	// openat2 (Linux 5.6 or later), which is checked by New.
+	KubeletRootDir string
+
+	// SourceCheck controls whether failing to validate the ownership and
+	// permissions of the Workload API socket directory and socket fails
+	// driver creation and publishing, or is only logged. Defaults to
+	// SourceCheckWarn.
+	SourceCheck SourceCheckMode
+
+	// AgentUID is a UID, besides root, that may own the Workload API
+	// socket directory and socket.
+	AgentUID int
+
+	// WorkloadAPISocketMode is the permission bits the Workload API socket
+	// is expected to have. Defaults to DefaultWorkloadAPISocketMode.
+	WorkloadAPISocketMode os.FileMode
+
+	// MountTimeout bounds how long NodePublishVolume and NodeUnpublishVolume
+	// wait for the volume to be mounted or unmounted. Defaults to
+	// DefaultMountTimeout.
+	MountTimeout time.Duration
+
+	// HealthCheckTimeout bounds how long NodeGetVolumeStats waits for the
+	// volume health checks. Defaults to DefaultHealthCheckTimeout.
+	HealthCheckTimeout time.Duration
+
+	// MaxBlockingCalls is the number of mount and unmount operations, and
+	// separately the number of health check operations, that may be in
+	// progress at once, including those that timed out but have not
+	// returned yet. The two are bounded separately so that stuck health
+	// checks cannot hold up publishing and unpublishing volumes. Defaults
+	// to DefaultMaxBlockingCalls.
+	MaxBlockingCalls int
 }
 
+const (
+	// DefaultMountTimeout is the default of Config.MountTimeout. It is
+	// below the two minutes the kubelet gives CSI calls.
+	DefaultMountTimeout = time.Minute
+
+	// DefaultHealthCheckTimeout is the default of Config.HealthCheckTimeout.
+	DefaultHealthCheckTimeout = 10 * time.Second
+
+	// DefaultMaxBlockingCalls is the default of Config.MaxBlockingCalls.
+	DefaultMaxBlockingCalls = 32
+)
+
 // Driver is the ephemeral-inline CSI driver implementation
 type Driver struct {
 	csi.UnimplementedIdentityServer
 	csi.UnimplementedNodeServer
 
-	log                  logr.Logger
-	nodeID               string
-	pluginName           string
-	workloadAPISocketDir string
+	log                     logr.Logger
+	nodeID                  string
+	pluginName              string
+	workloadAPISocketDir    string
+	workloadAPISocketName   string
+	socketMountNameOverride string
+	volumeLayout            VolumeLayout
+	compositeSocketOnly     bool
+	trustDomain             string
+	bindOptions             mount.BindOptions
+	seLinuxRelabel          bool
+	seLinuxMtx              sync.Mutex
+	seLinuxLabel            string
+	unmountOptions          mount.UnmountOptions
+	propagation             mount.Propagation
+	hostPID                 int
+	mounter                 mount.Mounter
+	kubeletRootDir          string
+	sourceCheckMode         SourceCheckMode
+	sourceMtx               sync.Mutex
+	sourceErr               string
+	agentUID                int
+	workloadAPISocketMode   os.FileMode
+	inFlight                inFlight
+	mountWorkers            *workers
+	healthWorkers           *workers
+	mountTimeout            time.Duration
+	healthCheckTimeout      time.Duration
 }
 
 // New creates a new driver with the given config
@@ -49,13 +179,133 @@ func New(config Config) (*Driver, error) {
 		return nil, errors.New("node ID is required")
 	case config.WorkloadAPISocketDir == "":
 		return nil, errors.New("workload API socket directory is required")
+	case config.HostPID < 0:
+		return nil, fmt.Errorf("invalid host PID %d", config.HostPID)
+	case config.KubeletRootDir != "" && !filepath.IsAbs(config.KubeletRootDir):
+		return nil, fmt.Errorf("kubelet root directory %q must be absolute", config.KubeletRootDir)
+	case config.AgentUID < 0:
+		return nil, fmt.Errorf("invalid agent UID %d", config.AgentUID)
+	case config.WorkloadAPISocketMode&^os.ModePerm != 0:
+		return nil, fmt.Errorf("invalid workload API socket mode %#o", config.WorkloadAPISocketMode)
+	case config.MountTimeout < 0:
+		return nil, fmt.Errorf("invalid mount timeout %s", config.MountTimeout)
+	case config.HealthCheckTimeout < 0:
+		return nil, fmt.Errorf("invalid health check timeout %s", config.HealthCheckTimeout)
+	case config.MaxBlockingCalls < 0:
+		return nil, fmt.Errorf("invalid maximum number of blocking calls %d", config.MaxBlockingCalls)
 	}
-	return &Driver{
-		log:                  config.Log,
-		nodeID:               config.NodeID,
-		pluginName:           config.PluginName,
-		workloadAPISocketDir: config.WorkloadAPISocketDir,
-	}, nil
+	if config.KubeletRootDir != "" {
+		if err := mount.CheckBeneath(); err != nil {
+			return nil, fmt.Errorf("paths cannot be resolved beneath the kubelet root directory %q; unset it to run on this system: %w", config.KubeletRootDir, err)
+		}
+	}
+
+	sourceCheckMode := config.SourceCheck
+	switch sourceCheckMode {
+	case "":
+		sourceCheckMode = SourceCheckWarn
+	case SourceCheckWarn, SourceCheckEnforce:
+	default:
+		return nil, fmt.Errorf("unsupported source check mode %q: must be one of warn or enforce", sourceCheckMode)
+	}
+	workloadAPISocketMode := config.WorkloadAPISocketMode
+	if workloadAPISocketMode == 0 {
+		workloadAPISocketMode = DefaultWorkloadAPISocketMode
+	}
+	mountTimeout := config.MountTimeout
+	if mountTimeout == 0 {
+		mountTimeout = DefaultMountTimeout
+	}
+	healthCheckTimeout := config.HealthCheckTimeout
+	if healthCheckTimeout == 0 {
+		healthCheckTimeout = DefaultHealthCheckTimeout
+	}
+	maxBlockingCalls := config.MaxBlockingCalls
+	if maxBlockingCalls == 0 {
+		maxBlockingCalls = DefaultMaxBlockingCalls
+	}
+
+	volumeLayout := config.VolumeLayout
+	switch volumeLayout {
+	case "":
+		volumeLayout = DirectoryLayout
+	case DirectoryLayout:
+	case SocketLayout, CompositeLayout:
+		if config.WorkloadAPISocketName == "" {
+			return nil, fmt.Errorf("workload API socket name is required by the %s volume layout", volumeLayout)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported volume layout %q", volumeLayout)
+	}
+	for _, name := range []string{config.WorkloadAPISocketName, config.SocketMountName} {
+		if name != "" && !isPlainFileName(name) {
+			return nil, fmt.Errorf("invalid socket name %q: must be a plain file name", name)
+		}
+	}
+	switch config.SocketMountName {
+	case compositeSocketDirName, compositeEnvFileName, compositePodInfoFileName, compositeTrustDomainFileName:
+		return nil, fmt.Errorf("invalid socket mount name %q: reserved by the composite volume layout", config.SocketMountName)
+	}
+
+	mounter := config.Mounter
+	if mounter == nil {
+		mounter = mount.Local{}
+	}
+
+	if config.HostPID != 0 {
+		if err := checkHostPID(mounter, config.HostPID); err != nil {
+			return nil, err
+		}
+	}
+
+	seLinuxRelabel := config.SELinuxRelabel
+	if seLinuxRelabel && !mounter.SELinuxEnabled() {
+		config.Log.Info("SELinux is not enabled; the Workload API socket will not be relabeled")
+		seLinuxRelabel = false
+	}
+
+	propagation, err := mount.ParsePropagation(string(config.MountPropagation))
+	if err != nil {
+		return nil, err
+	}
+
+	unmountOptions := mount.DefaultUnmountOptions()
+	unmountOptions.Detach = config.LazyUnmount
+
+	d := &Driver{
+		log:                     config.Log,
+		nodeID:                  config.NodeID,
+		pluginName:              config.PluginName,
+		workloadAPISocketDir:    config.WorkloadAPISocketDir,
+		workloadAPISocketName:   config.WorkloadAPISocketName,
+		socketMountNameOverride: config.SocketMountName,
+		volumeLayout:            volumeLayout,
+		compositeSocketOnly:     config.CompositeSocketOnly,
+		trustDomain:             config.TrustDomain,
+		bindOptions: mount.BindOptions{
+			ReadOnly: config.ReadOnlyHostMounts,
+			NoSuid:   config.HardenMounts,
+			NoDev:    config.HardenMounts,
+			NoExec:   config.HardenMounts,
+		},
+		seLinuxRelabel:        seLinuxRelabel,
+		unmountOptions:        unmountOptions,
+		propagation:           propagation,
+		hostPID:               config.HostPID,
+		mounter:               mounter,
+		kubeletRootDir:        cleanOptionalPath(config.KubeletRootDir),
+		sourceCheckMode:       sourceCheckMode,
+		agentUID:              config.AgentUID,
+		workloadAPISocketMode: workloadAPISocketMode,
+		mountWorkers:          newWorkers(maxBlockingCalls),
+		healthWorkers:         newWorkers(maxBlockingCalls),
+		mountTimeout:          mountTimeout,
+		healthCheckTimeout:    healthCheckTimeout,
+	}
+	if err := d.validateSource(); err != nil {
+		return nil, err
+	}
+	return d, nil
 }
 
 /////////////////////////////////////////////////////////////////////////////
@@ -86,8 +336,8 @@ func (d *Driver) Probe(context.Context, *csi.ProbeRequest) (*csi.ProbeResponse,
 /////////////////////////////////////////////////////////////////////////////
 
 // NodePublishVolume mounts the workload API socket directory into the target path.
-func (d *Driver) NodePublishVolume(_ context.Context, req *csi.NodePublishVolumeRequest) (_ *csi.NodePublishVolumeResponse, err error) {
-	ephemeralMode := req.GetVolumeContext()["csi.storage.k8s.io/ephemeral"]
+func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (_ *csi.NodePublishVolumeResponse, err error) {
+	ephemeralMode := req.GetVolumeContext()[volumeContextEphemeral]
 
 	log := d.log.WithValues(
 		logkeys.VolumeID, req.VolumeId,
@@ -124,36 +374,104 @@ func (d *Driver) NodePublishVolume(_ context.Context, req *csi.NodePublishVolume
 	case ephemeralMode != "true":
 		return nil, status.Error(codes.InvalidArgument, "only ephemeral volumes are supported")
 	}
+	// Everything below operates on the cleaned target path, which is what
+	// checkTargetPath validates.
+	targetPath := filepath.Clean(req.TargetPath)
+	podUID, err := d.checkTargetPath(targetPath)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	switch wantUID := req.GetVolumeContext()[volumeContextPodUID]; {
+	case podUID == "":
+	case wantUID == "":
+		log.Info("Publish request is missing the pod UID volume attribute; the target path cannot be checked against the pod. Set podInfoOnMount: true on the CSIDriver.")
+	case podUID != wantUID:
+		return nil, status.Errorf(codes.InvalidArgument, "target path %q belongs to pod %q, not to pod %q", targetPath, podUID, wantUID)
+	}
+
+	mountOptions, err := d.parseMountFlags(req.VolumeCapability.GetMount().GetMountFlags())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	release, ok := d.inFlight.tryAcquire(req.VolumeId, targetPath)
+	if !ok {
+		return nil, errOperationPending(req.VolumeId, targetPath)
+	}
+	// The volume stays claimed until the publish returns, even if the call
+	// is given up on before.
+	if err := d.mountWorkers.run(ctx, d.mountTimeout, "publish", func() error {
+		return d.publishVolume(log, targetPath, req.GetVolumeContext(), mountOptions)
+	}, release); err != nil {
+		return nil, err
+	}
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// publishVolume does the work of NodePublishVolume once the request is
+// validated. It returns status errors.
+func (d *Driver) publishVolume(log logr.Logger, targetPath string, volumeContext map[string]string, mountOptions volumeMountOptions) error {
+	var err error
+	mountOptions.idmap, mountOptions.idmapRequired, err = d.volumeIDMap(targetPath, volumeContext)
+	if err != nil {
+		return err
+	}
+
+	if err := d.validateSource(); err != nil {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
 
 	// Create the target path (required by CSI interface)
-	if err := os.Mkdir(req.TargetPath, 0750); err != nil && !os.IsExist(err) {
-		return nil, status.Errorf(codes.Internal, "unable to create target path %q: %v", req.TargetPath, err)
+	if err := d.mkdirTarget(targetPath); err != nil && !os.IsExist(err) {
+		return status.Errorf(codes.Internal, "unable to create target path %q: %v", targetPath, err)
 	}
 
 	// Return if the target path is already mounted
-	if mounted, mountErr := isMountPoint(req.TargetPath); mountErr != nil {
-		return nil, status.Errorf(codes.Internal, "unable to verify mount point %q: %v", req.TargetPath, mountErr)
+	publishedMountPath := d.publishedMountPath(targetPath)
+	if mounted, mountErr := d.mounter.IsMountPoint(publishedMountPath); mountErr != nil {
+		return status.Errorf(codes.Internal, "unable to verify mount point %q: %v", publishedMountPath, mountErr)
 	} else if mounted {
+		// Only accept a mount made by this driver; anything else mounted
+		// there would otherwise be handed to the workload.
+		if own, ownErr := d.isPublished(publishedMountPath); ownErr != nil {
+			return status.Errorf(codes.Internal, "unable to verify mount point %q: %v", publishedMountPath, ownErr)
+		} else if !own {
+			return status.Errorf(codes.FailedPrecondition, "target path %q is already mounted by something other than this driver", publishedMountPath)
+		}
 		log.Info("Volume already published")
-		return &csi.NodePublishVolumeResponse{}, nil
+		return nil
 	}
 
 	// Ideally the volume is writable by the host to enable, for example,
 	// manipulation of file attributes by SELinux. However, the volume MUST NOT
 	// be writable by workload containers. We enforce that the CSI volume is
 	// marked read-only above, instructing the kubelet to mount it read-only
-	// into containers, while we mount the volume read-write to the host.
-	if err := bindMountRW(d.workloadAPISocketDir, req.TargetPath); err != nil {
-		return nil, status.Errorf(codes.Internal, "unable to mount %q: %v", req.TargetPath, err)
+	// into containers, while we mount the volume read-write to the host
+	// unless configured otherwise.
+	if err := d.publish(targetPath, volumeContext, mountOptions); err != nil {
+		if errors.Is(err, errSELinuxLabelConflict) {
+			return status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return status.Errorf(codes.Internal, "unable to mount %q: %v", targetPath, err)
+	}
+	if err := d.checkHostMount(publishedMountPath); err != nil {
+		// Leave nothing behind so that the next attempt starts over rather
+		// than taking the unpropagated mount as already published.
+		if unmountErr := d.unmountVolume(targetPath); unmountErr != nil {
+			log.Error(unmountErr, "Failed to clean up unpropagated volume mount")
+		}
+		if errors.Is(err, errMountNotPropagated) {
+			return status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return status.Error(codes.Internal, err.Error())
 	}
 
 	log.Info("Volume published")
-
-	return &csi.NodePublishVolumeResponse{}, nil
+	return nil
 }
 
 // NodeUnpublishVolume unmounts the volume from the target path.
-func (d *Driver) NodeUnpublishVolume(_ context.Context, req *csi.NodeUnpublishVolumeRequest) (_ *csi.NodeUnpublishVolumeResponse, err error) {
+func (d *Driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (_ *csi.NodeUnpublishVolumeResponse, err error) {
 	log := d.log.WithValues(
 		logkeys.VolumeID, req.VolumeId,
 		logkeys.TargetPath, req.TargetPath,
@@ -172,24 +490,41 @@ func (d *Driver) NodeUnpublishVolume(_ context.Context, req *csi.NodeUnpublishVo
 	case req.TargetPath == "":
 		return nil, status.Error(codes.InvalidArgument, "request missing required target path")
 	}
+	targetPath := filepath.Clean(req.TargetPath)
+	if _, err := d.checkTargetPath(targetPath); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	release, ok := d.inFlight.tryAcquire(req.VolumeId, targetPath)
+	if !ok {
+		return nil, errOperationPending(req.VolumeId, targetPath)
+	}
+	if err := d.mountWorkers.run(ctx, d.mountTimeout, "unpublish", func() error {
+		return d.unpublishVolume(targetPath)
+	}, release); err != nil {
+		return nil, err
+	}
 
+	log.Info("Volume unpublished")
+
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+// unpublishVolume does the work of NodeUnpublishVolume once the request is
+// validated. It returns status errors.
+func (d *Driver) unpublishVolume(targetPath string) error {
 	// Check if target is a valid mount and issue unmount request
-	if ok, err := isMountPoint(req.TargetPath); err != nil {
-		return nil, status.Errorf(codes.Internal, "unable to verify mount point %q: %v", req.TargetPath, err)
-	} else if ok {
-		if err := unmount(req.TargetPath); err != nil {
-			return nil, status.Errorf(codes.Internal, "unable to unmount %q: %v", req.TargetPath, err)
+	if err := d.unmountVolume(targetPath); err != nil {
+		if errors.Is(err, errForeignMount) {
+			return status.Error(codes.FailedPrecondition, err.Error())
 		}
+		return status.Error(codes.Internal, err.Error())
 	}
 
 	// Check and remove the mount path if present, report an error otherwise
-	if err := os.Remove(req.TargetPath); err != nil && !errors.Is(err, os.ErrNotExist) {
-		return nil, status.Errorf(codes.Internal, "unable to remove target path %q: %v", req.TargetPath, err)
+	if err := d.removeTarget(targetPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return status.Errorf(codes.Internal, "unable to remove target path %q: %v", targetPath, err)
 	}
-
-	log.Info("Volume unpublished")
-
-	return &csi.NodeUnpublishVolumeResponse{}, nil
+	return nil
 }
 
 // NodeGetCapabilities returns the capabilities of the node service.
@@ -223,18 +558,37 @@ func (d *Driver) NodeGetInfo(context.Context, *csi.NodeGetInfoRequest) (*csi.Nod
 }
 
 // NodeGetVolumeStats returns the health condition of a volume.
-func (d *Driver) NodeGetVolumeStats(_ context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
+func (d *Driver) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
 	log := d.log.WithValues(
 		logkeys.VolumeID, req.VolumeId,
 		logkeys.VolumePath, req.VolumePath,
 	)
 
+	volumePath := cleanOptionalPath(req.VolumePath)
+	if _, err := d.checkTargetPath(volumePath); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// The health check may mount a re-created socket again.
+	release, ok := d.inFlight.tryAcquire(req.VolumeId, volumePath)
+	if !ok {
+		return nil, errOperationPending(req.VolumeId, volumePath)
+	}
+
+	var checkErr error
+	if err := d.healthWorkers.run(ctx, d.healthCheckTimeout, "health check", func() error {
+		checkErr = d.checkWorkloadAPIMount(volumePath)
+		return nil
+	}, release); err != nil {
+		log.Error(err, "Failed to check volume health")
+		return nil, err
+	}
+
 	volumeConditionAbnormal := false
 	volumeConditionMessage := "mounted"
-	if err := d.checkWorkloadAPIMount(req.VolumePath); err != nil {
+	if checkErr != nil {
 		volumeConditionAbnormal = true
-		volumeConditionMessage = err.Error()
-		log.Error(err, "Volume is unhealthy")
+		volumeConditionMessage = checkErr.Error()
+		log.Error(checkErr, "Volume is unhealthy")
 	} else {
 		log.Info("Volume is healthy")
 	}
@@ -248,20 +602,37 @@ func (d *Driver) NodeGetVolumeStats(_ context.Context, req *csi.NodeGetVolumeSta
 }
 
 func (d *Driver) checkWorkloadAPIMount(volumePath string) error {
-	// Check whether or not it is a mount point.
-	if ok, err := isMountPoint(volumePath); err != nil {
-		return fmt.Errorf("failed to determine root for volume path mount: %w", err)
-	} else if !ok {
-		return errors.New("volume path is not mounted")
+	// Check whether or not it is a mount point. For the socket layout, the
+	// checks on the socket mount point are done with the rest of the layout
+	// checks below.
+	if d.volumeLayout != SocketLayout {
+		if err := d.checkMountPoint(volumePath); err != nil {
+			return err
+		}
 	}
-	// If a mount point, try to list files... this should fail if the mount is
-	// broken for whatever reason.
+	// Try to list files... this should fail if the mount is broken for
+	// whatever reason.
 	if _, err := os.ReadDir(volumePath); err != nil {
 		return fmt.Errorf("unable to list contents of volume path: %w", err)
 	}
+	return d.checkLayout(volumePath)
+}
+
+func (d *Driver) checkMountPoint(volumePath string) error {
+	if ok, err := d.mounter.IsMountPoint(volumePath); err != nil {
+		return fmt.Errorf("failed to determine root for volume path mount: %w", err)
+	} else if !ok {
+		return errors.New("volume path is not mounted")
+	}
 	return nil
 }
 
+// errOperationPending is returned for a call on a volume that another call
+// is still operating on.
+func errOperationPending(volumeID, path string) error {
+	return status.Errorf(codes.Aborted, "an operation on volume %q at %q is already in progress", volumeID, path)
+}
+
 func isVolumeCapabilityPlainMount(volumeCapability *csi.VolumeCapability) bool {
 	mount := volumeCapability.GetMount()
 	switch {
@@ -269,12 +640,14 @@ func isVolumeCapabilityPlainMount(volumeCapability *csi.VolumeCapability) bool {
 		return false
 	case mount.FsType != "":
 		return false
-	case len(mount.MountFlags) != 0:
-		return false
 	}
 	return true
 }
 
+func isPlainFileName(name string) bool {
+	return name != "." && name != ".." && filepath.Base(name) == name
+}
+
 func isVolumeCapabilityAccessModeReadOnly(accessMode *csi.VolumeCapability_AccessMode) bool {
 	return accessMode.Mode == csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY
 }
@@ -0,0 +1,82 @@
+package driver
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/go-logr/logr"
+	"github.com/spiffe/spiffe-csi/pkg/mount"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// TestCompositeVolumePropagation publishes composite volumes with real
+// mounts onto a shared mount that has a peer in another mount namespace,
+// standing in for the kubelet pods directory and the host mount namespace.
+func TestCompositeVolumePropagation(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("mounting requires root")
+	}
+
+	for _, p := range []mount.Propagation{mount.PropagationUnchanged, mount.PropagationPrivate, mount.PropagationSlave, mount.PropagationUnbindable} {
+		name := string(p)
+		if p == mount.PropagationUnchanged {
+			name = "unchanged"
+		}
+		t.Run(name, func(t *testing.T) {
+			podsDir := t.TempDir()
+			require.NoError(t, mount.MountTmpfs(podsDir, "size=1m"))
+			t.Cleanup(func() { _ = unix.Unmount(podsDir, unix.MNT_DETACH) })
+			require.NoError(t, unix.Mount("", podsDir, "", unix.MS_SHARED, ""))
+
+			// The copy of the pods directory in the mount namespace
+			// standing in for the host is a peer of the original.
+			host := exec.Command("sleep", "60")
+			host.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNS}
+			require.NoError(t, host.Start())
+			t.Cleanup(func() {
+				_ = host.Process.Kill()
+				_ = host.Wait()
+			})
+
+			workloadAPISocketDir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(workloadAPISocketDir, "spire-agent.sock"), nil, 0600))
+			d, err := New(Config{
+				Log:                   logr.Discard(),
+				NodeID:                testNodeID,
+				WorkloadAPISocketDir:  workloadAPISocketDir,
+				WorkloadAPISocketName: "spire-agent.sock",
+				VolumeLayout:          CompositeLayout,
+				MountPropagation:      p,
+				HostPID:               host.Process.Pid,
+				Mounter:               mount.Local{},
+			})
+			require.NoError(t, err)
+
+			targetPath := filepath.Join(podsDir, "target-path")
+			_, err = d.NodePublishVolume(context.Background(), newPublishRequest("volumeID", targetPath))
+			require.NoError(t, err)
+
+			for _, path := range []string{targetPath, filepath.Join(targetPath, "workload-api")} {
+				mounted, err := mount.IsMountedIn(host.Process.Pid, path)
+				require.NoError(t, err)
+				assert.True(t, mounted, "%q is not mounted in the host mount namespace", path)
+			}
+			if p != mount.PropagationUnchanged {
+				require.NoError(t, mount.VerifyPropagation(targetPath, p))
+			}
+
+			_, err = d.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+				VolumeId:   "volumeID",
+				TargetPath: targetPath,
+			})
+			require.NoError(t, err)
+		})
+	}
+}
@@ -8,13 +8,20 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
 	"github.com/google/go-cmp/cmp"
 	"github.com/spiffe/spiffe-csi/internal/version"
+	"github.com/spiffe/spiffe-csi/pkg/mount"
+	"github.com/spiffe/spiffe-csi/pkg/mount/fake"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
@@ -25,39 +32,11 @@ import (
 	"google.golang.org/protobuf/testing/protocmp"
 )
 
-const (
-	testNodeID         = "nodeID"
-	unmountFailureTest = "unmount failure"
-	isMountFailureTest = "isMount failure"
-)
-
-var (
-	testDescription string
-)
-
-func init() {
-	bindMountRW = func(src, dst string) error {
-		return writeMeta(dst, src)
-	}
-	unmount = func(dst string) error {
-		return os.Remove(metaPath(dst))
-	}
-	isMountPoint = func(path string) (bool, error) {
-		if testDescription == unmountFailureTest {
-			return true, nil
-		}
-
-		if testDescription == isMountFailureTest {
-			return false, fmt.Errorf("mock invalid mount point")
-		}
-
-		// Check if the meta file exists to simulate a real mount check.
-		_, err := os.Stat(metaPath(path))
-		return err == nil, nil
-	}
-}
+const testNodeID = "nodeID"
 
 func TestNew(t *testing.T) {
+	t.Parallel()
+
 	workloadAPISocketDir := t.TempDir()
 
 	t.Run("node ID is required", func(t *testing.T) {
@@ -74,6 +53,148 @@ func TestNew(t *testing.T) {
 		require.EqualError(t, err, "workload API socket directory is required")
 	})
 
+	t.Run("invalid host PID", func(t *testing.T) {
+		_, err := New(Config{
+			NodeID:               testNodeID,
+			WorkloadAPISocketDir: workloadAPISocketDir,
+			HostPID:              -1,
+		})
+		require.EqualError(t, err, "invalid host PID -1")
+	})
+
+	t.Run("host PID in the mount namespace of the driver", func(t *testing.T) {
+		m := fake.New()
+		m.SetSharesMountNamespace(true)
+		_, err := New(Config{
+			NodeID:               testNodeID,
+			WorkloadAPISocketDir: workloadAPISocketDir,
+			HostPID:              1,
+			Mounter:              m,
+		})
+		require.EqualError(t, err, "PID 1 is in the mount namespace of the driver; run the driver with hostPID: true")
+	})
+
+	t.Run("kubelet root directory must be absolute", func(t *testing.T) {
+		_, err := New(Config{
+			NodeID:               testNodeID,
+			WorkloadAPISocketDir: workloadAPISocketDir,
+			KubeletRootDir:       "var/lib/kubelet",
+		})
+		require.EqualError(t, err, `kubelet root directory "var/lib/kubelet" must be absolute`)
+	})
+
+	t.Run("invalid agent UID", func(t *testing.T) {
+		_, err := New(Config{
+			NodeID:               testNodeID,
+			WorkloadAPISocketDir: workloadAPISocketDir,
+			AgentUID:             -1,
+		})
+		require.EqualError(t, err, "invalid agent UID -1")
+	})
+
+	t.Run("invalid workload API socket mode", func(t *testing.T) {
+		_, err := New(Config{
+			NodeID:                testNodeID,
+			WorkloadAPISocketDir:  workloadAPISocketDir,
+			WorkloadAPISocketMode: os.ModeSetuid | 0777,
+		})
+		require.EqualError(t, err, "invalid workload API socket mode 040000777")
+	})
+
+	t.Run("invalid mount timeout", func(t *testing.T) {
+		_, err := New(Config{
+			NodeID:               testNodeID,
+			WorkloadAPISocketDir: workloadAPISocketDir,
+			MountTimeout:         -time.Second,
+		})
+		require.EqualError(t, err, "invalid mount timeout -1s")
+	})
+
+	t.Run("invalid health check timeout", func(t *testing.T) {
+		_, err := New(Config{
+			NodeID:               testNodeID,
+			WorkloadAPISocketDir: workloadAPISocketDir,
+			HealthCheckTimeout:   -time.Second,
+		})
+		require.EqualError(t, err, "invalid health check timeout -1s")
+	})
+
+	t.Run("invalid maximum number of blocking calls", func(t *testing.T) {
+		_, err := New(Config{
+			NodeID:               testNodeID,
+			WorkloadAPISocketDir: workloadAPISocketDir,
+			MaxBlockingCalls:     -1,
+		})
+		require.EqualError(t, err, "invalid maximum number of blocking calls -1")
+	})
+
+	t.Run("unsupported source check mode", func(t *testing.T) {
+		_, err := New(Config{
+			NodeID:               testNodeID,
+			WorkloadAPISocketDir: workloadAPISocketDir,
+			SourceCheck:          "bogus",
+		})
+		require.EqualError(t, err, `unsupported source check mode "bogus": must be one of warn or enforce`)
+	})
+
+	t.Run("unsupported mount propagation", func(t *testing.T) {
+		_, err := New(Config{
+			NodeID:               testNodeID,
+			WorkloadAPISocketDir: workloadAPISocketDir,
+			MountPropagation:     "shared",
+		})
+		require.EqualError(t, err, `unsupported mount propagation "shared": must be one of private, slave or unbindable`)
+	})
+
+	t.Run("unsupported volume layout", func(t *testing.T) {
+		_, err := New(Config{
+			NodeID:               testNodeID,
+			WorkloadAPISocketDir: workloadAPISocketDir,
+			VolumeLayout:         "bogus",
+		})
+		require.EqualError(t, err, `unsupported volume layout "bogus"`)
+	})
+
+	t.Run("composite volume layout requires socket name", func(t *testing.T) {
+		_, err := New(Config{
+			NodeID:               testNodeID,
+			WorkloadAPISocketDir: workloadAPISocketDir,
+			VolumeLayout:         CompositeLayout,
+		})
+		require.EqualError(t, err, "workload API socket name is required by the composite volume layout")
+	})
+
+	t.Run("socket volume layout requires socket name", func(t *testing.T) {
+		_, err := New(Config{
+			NodeID:               testNodeID,
+			WorkloadAPISocketDir: workloadAPISocketDir,
+			VolumeLayout:         SocketLayout,
+		})
+		require.EqualError(t, err, "workload API socket name is required by the socket volume layout")
+	})
+
+	t.Run("socket mount name must be a plain file name", func(t *testing.T) {
+		_, err := New(Config{
+			NodeID:                testNodeID,
+			WorkloadAPISocketDir:  workloadAPISocketDir,
+			WorkloadAPISocketName: "spire-agent.sock",
+			SocketMountName:       "../socket",
+			VolumeLayout:          SocketLayout,
+		})
+		require.EqualError(t, err, `invalid socket name "../socket": must be a plain file name`)
+	})
+
+	t.Run("socket mount name must not be reserved", func(t *testing.T) {
+		_, err := New(Config{
+			NodeID:                testNodeID,
+			WorkloadAPISocketDir:  workloadAPISocketDir,
+			WorkloadAPISocketName: "spire-agent.sock",
+			SocketMountName:       "pod.json",
+			VolumeLayout:          CompositeLayout,
+		})
+		require.EqualError(t, err, `invalid socket mount name "pod.json": reserved by the composite volume layout`)
+	})
+
 	t.Run("success", func(t *testing.T) {
 		_, err := New(Config{
 			NodeID:               testNodeID,
@@ -84,6 +205,8 @@ func TestNew(t *testing.T) {
 }
 
 func TestBoilerplateRPCs(t *testing.T) {
+	t.Parallel()
+
 	client, _ := startDriver(t)
 
 	t.Run("GetPluginInfo", func(t *testing.T) {
@@ -141,6 +264,8 @@ func TestBoilerplateRPCs(t *testing.T) {
 }
 
 func TestNodePublishVolume(t *testing.T) {
+	t.Parallel()
+
 	for _, tt := range []struct {
 		desc            string
 		mutateReq       func(req *csi.NodePublishVolumeRequest)
@@ -193,7 +318,7 @@ func TestNodePublishVolume(t *testing.T) {
 			expectMsgPrefix: "request volume capability access type must be a simple mount",
 		},
 		{
-			desc: "invalid volume capability access type mount fs type",
+			desc: "invalid volume capability access type mount flags",
 			mutateReq: func(req *csi.NodePublishVolumeRequest) {
 				req.VolumeCapability.AccessType = &csi.VolumeCapability_Mount{
 					Mount: &csi.VolumeCapability_MountVolume{
@@ -202,7 +327,7 @@ func TestNodePublishVolume(t *testing.T) {
 				}
 			},
 			expectCode:      codes.InvalidArgument,
-			expectMsgPrefix: "request volume capability access type must be a simple mount",
+			expectMsgPrefix: `request volume capability mount flag "ANYTHING HERE IS BAD" is not allowed`,
 		},
 		{
 			desc: "invalid volume capability access type",
@@ -257,9 +382,9 @@ func TestNodePublishVolume(t *testing.T) {
 		{
 			desc: "mount failure",
 			mungeTargetPath: func(t *testing.T, targetPath string) {
-				// write out a file to the target path... this will prevent our
-				// fake mount implementation from being able to write the
-				// metadata file, thus simulating a mount failure.
+				// write out a file to the target path... a directory cannot
+				// be bind mounted onto a file, thus simulating a mount
+				// failure.
 				require.NoError(t, os.WriteFile(targetPath, nil, 0600))
 			},
 			expectCode:      codes.Internal,
@@ -279,6 +404,8 @@ func TestNodePublishVolume(t *testing.T) {
 		},
 	} {
 		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
 			targetPathBase := t.TempDir()
 			targetPath := filepath.Join(targetPathBase, "target-path")
 
@@ -302,35 +429,29 @@ func TestNodePublishVolume(t *testing.T) {
 				tt.mutateReq(req)
 			}
 
-			client, workloadAPISocketDir := startDriver(t)
+			m := fake.New()
+			client, workloadAPISocketDir := startDriverWithConfig(t, Config{Mounter: m})
 
 			resp, err := client.NodePublishVolume(context.Background(), req)
 			requireGRPCStatusPrefix(t, err, tt.expectCode, tt.expectMsgPrefix)
 			if err == nil {
 				assertProtoEqual(t, &csi.NodePublishVolumeResponse{}, resp)
-				assertMounted(t, targetPath, workloadAPISocketDir)
+				assertMounted(t, m, targetPath, workloadAPISocketDir)
 			} else {
 				assert.Nil(t, resp)
-				assertNotMounted(t, targetPath)
+				assertNotMounted(t, m, targetPath)
 			}
 		})
 	}
 }
 
 func TestNodePublishVolumeIdempotent(t *testing.T) {
+	t.Parallel()
+
 	// calling NodePublishVolume twice on the same target path
 	// must not create duplicate mounts.
-	originalBindMountRW := bindMountRW
-	mountCalls := 0
-	bindMountRW = func(src, dst string) error {
-		mountCalls++
-		return originalBindMountRW(src, dst)
-	}
-	t.Cleanup(func() {
-		bindMountRW = originalBindMountRW
-	})
-
-	client, workloadAPISocketDir := startDriver(t)
+	m := fake.New()
+	client, workloadAPISocketDir := startDriverWithConfig(t, Config{Mounter: m})
 
 	targetPathBase := t.TempDir()
 	targetPath := filepath.Join(targetPathBase, "target-path")
@@ -352,24 +473,118 @@ func TestNodePublishVolumeIdempotent(t *testing.T) {
 	resp, err := client.NodePublishVolume(context.Background(), req)
 	require.NoError(t, err)
 	assertProtoEqual(t, &csi.NodePublishVolumeResponse{}, resp)
-	assertMounted(t, targetPath, workloadAPISocketDir)
-	assert.Equal(t, 1, mountCalls, "first publish should mount once")
+	assertMounted(t, m, targetPath, workloadAPISocketDir)
+	assert.Len(t, m.Calls(fake.OpBindMountRW), 1, "first publish should mount once")
 
 	// Second call should succeed without re-mounting
 	resp, err = client.NodePublishVolume(context.Background(), req)
 	require.NoError(t, err)
 	assertProtoEqual(t, &csi.NodePublishVolumeResponse{}, resp)
-	assertMounted(t, targetPath, workloadAPISocketDir)
-	assert.Equal(t, 1, mountCalls, "second publish should not re-mount")
+	assertMounted(t, m, targetPath, workloadAPISocketDir)
+	assert.Len(t, m.Calls(fake.OpBindMountRW), 1, "second publish should not re-mount")
+	assert.Len(t, m.Mounts(targetPath), 1)
+}
+
+func TestNodePublishVolumeForeignMount(t *testing.T) {
+	t.Parallel()
+
+	m := fake.New()
+	client, _ := startDriverWithConfig(t, Config{Mounter: m})
+
+	targetPath := filepath.Join(t.TempDir(), "target-path")
+	require.NoError(t, os.Mkdir(targetPath, 0750))
+	m.AddMount(targetPath, fake.Mount{Source: "/somewhere/else"})
+
+	_, err := client.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:   "volumeID",
+		TargetPath: targetPath,
+		Readonly:   true,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{},
+			AccessMode: &csi.VolumeCapability_AccessMode{},
+		},
+		VolumeContext: map[string]string{
+			"csi.storage.k8s.io/ephemeral": "true",
+		},
+	})
+	requireGRPCStatusPrefix(t, err, codes.FailedPrecondition, "target path")
+	assert.Contains(t, err.Error(), "is already mounted by something other than this driver")
+
+	// The foreign mount is left alone.
+	assertMounted(t, m, targetPath, "/somewhere/else")
+}
+
+func TestForeignTmpfs(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		desc   string
+		layout VolumeLayout
+		data   string
+	}{
+		{
+			desc:   "directory layout",
+			layout: DirectoryLayout,
+			data:   "size=1m,mode=0755",
+		},
+		{
+			desc:   "socket layout",
+			layout: SocketLayout,
+			data:   "size=1m,mode=0755",
+		},
+		{
+			desc:   "composite layout with other options",
+			layout: CompositeLayout,
+			data:   "size=64m",
+		},
+		{
+			desc:   "composite layout without inner mount",
+			layout: CompositeLayout,
+			data:   "size=1m,mode=0755",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			m := fake.New()
+			client, _ := startDriverWithConfig(t, Config{
+				Mounter:               m,
+				WorkloadAPISocketName: "spire-agent.sock",
+				VolumeLayout:          tt.layout,
+			})
+
+			targetPath := filepath.Join(t.TempDir(), "target-path")
+			require.NoError(t, os.Mkdir(targetPath, 0750))
+			mountPath := targetPath
+			if tt.layout == SocketLayout {
+				mountPath = filepath.Join(targetPath, "spire-agent.sock")
+				require.NoError(t, os.WriteFile(mountPath, nil, 0600))
+			}
+			m.AddMount(mountPath, fake.Mount{Source: fake.TmpfsSource, Data: tt.data})
+
+			_, err := client.NodePublishVolume(context.Background(), newPublishRequest("volumeID", targetPath))
+			requireGRPCStatusPrefix(t, err, codes.FailedPrecondition, "target path")
+			assert.Contains(t, err.Error(), "is already mounted by something other than this driver")
+
+			_, err = client.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+				VolumeId:   "volumeID",
+				TargetPath: targetPath,
+			})
+			requireGRPCStatusPrefix(t, err, codes.FailedPrecondition, "mount was not made by this driver: refusing to unmount")
+
+			// The foreign tmpfs is left alone.
+			assertMounted(t, m, mountPath, fake.TmpfsSource)
+		})
+	}
 }
 
 func TestNodeUnpublishVolume(t *testing.T) {
-	client, workloadAPISocketDir := startDriver(t)
+	t.Parallel()
 
 	for _, tt := range []struct {
 		desc            string
 		mutateReq       func(req *csi.NodeUnpublishVolumeRequest)
-		mungeTargetPath func(t *testing.T, targetPath string)
+		mungeTargetPath func(t *testing.T, m *fake.Mounter, targetPath string)
 		expectCode      codes.Code
 		expectMsgPrefix string
 	}{
@@ -390,22 +605,32 @@ func TestNodeUnpublishVolume(t *testing.T) {
 			expectMsgPrefix: "request missing required target path",
 		},
 		{
-			desc:            isMountFailureTest,
+			desc: "isMount failure",
+			mungeTargetPath: func(t *testing.T, m *fake.Mounter, targetPath string) {
+				m.FailOn(fake.OpIsMountPoint, "", fake.ErrInjected)
+			},
 			expectCode:      codes.Internal,
 			expectMsgPrefix: "unable to verify mount point",
 		},
 		{
-			desc: unmountFailureTest,
-			mungeTargetPath: func(t *testing.T, targetPath string) {
-				// Removing the meta file to simulate that it wasn't mounted
-				require.NoError(t, os.Remove(metaPath(targetPath)))
+			desc: "unmount failure",
+			mungeTargetPath: func(t *testing.T, m *fake.Mounter, targetPath string) {
+				m.FailOn(fake.OpUnmountWithOptions, targetPath, fake.ErrInjected)
 			},
 			expectCode:      codes.Internal,
 			expectMsgPrefix: "unable to unmount",
 		},
+		{
+			desc: "foreign mount",
+			mungeTargetPath: func(t *testing.T, m *fake.Mounter, targetPath string) {
+				m.AddMount(targetPath, fake.Mount{Source: "/somewhere/else"})
+			},
+			expectCode:      codes.FailedPrecondition,
+			expectMsgPrefix: "mount was not made by this driver: refusing to unmount",
+		},
 		{
 			desc: "unable to remove target path after unmounting",
-			mungeTargetPath: func(t *testing.T, targetPath string) {
+			mungeTargetPath: func(t *testing.T, m *fake.Mounter, targetPath string) {
 				// Prevent the directory from being removed by writing
 				// a file into it.
 				require.NoError(t, os.WriteFile(filepath.Join(targetPath, "prevent-directory-removal"), nil, 0600))
@@ -419,15 +644,20 @@ func TestNodeUnpublishVolume(t *testing.T) {
 		},
 	} {
 		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			m := fake.New()
+			client, workloadAPISocketDir := startDriverWithConfig(t, Config{Mounter: m})
+
 			targetPathBase := t.TempDir()
 			targetPath := filepath.Join(targetPathBase, "target-path")
 
-			// Write out the meta file to simulate a successful mount
+			// Simulate a successful mount
 			require.NoError(t, os.Mkdir(targetPath, 0750))
-			require.NoError(t, writeMeta(targetPath, workloadAPISocketDir))
+			m.AddMount(targetPath, fake.Mount{Source: workloadAPISocketDir})
 
 			if tt.mungeTargetPath != nil {
-				tt.mungeTargetPath(t, targetPath)
+				tt.mungeTargetPath(t, m, targetPath)
 			}
 
 			req := &csi.NodeUnpublishVolumeRequest{
@@ -437,13 +667,12 @@ func TestNodeUnpublishVolume(t *testing.T) {
 			if tt.mutateReq != nil {
 				tt.mutateReq(req)
 			}
-			registerTestDescription(tt.desc)
 			dumpIt(t, "BEFORE", targetPathBase)
 			resp, err := client.NodeUnpublishVolume(context.Background(), req)
 			dumpIt(t, "AFTER", targetPathBase)
 			requireGRPCStatusPrefix(t, err, tt.expectCode, tt.expectMsgPrefix)
 			if err == nil {
-				assertNotMounted(t, targetPath)
+				assertNotMounted(t, m, targetPath)
 				assertProtoEqual(t, &csi.NodeUnpublishVolumeResponse{}, resp)
 			} else {
 				assert.Nil(t, resp)
@@ -452,106 +681,2012 @@ func TestNodeUnpublishVolume(t *testing.T) {
 	}
 }
 
-func registerTestDescription(desc string) {
-	testDescription = desc
-}
+func TestNodeUnpublishVolumeStackedMounts(t *testing.T) {
+	t.Parallel()
 
-func requireGRPCStatusPrefix(tb testing.TB, err error, code codes.Code, msgPrefix string, msgAndArgs ...interface{}) {
-	st := status.Convert(err)
-	if code != st.Code() || !strings.HasPrefix(st.Message(), msgPrefix) {
-		require.Fail(tb, fmt.Sprintf("Status code=%q msg=%q does not match code=%q with msg prefix %q", st.Code(), st.Message(), code, msgPrefix), msgAndArgs...)
+	for _, tt := range []struct {
+		desc            string
+		layers          int
+		expectCode      codes.Code
+		expectMsgPrefix string
+		expectUnmounts  int
+	}{
+		{
+			desc:           "all layers are unmounted",
+			layers:         3,
+			expectCode:     codes.OK,
+			expectUnmounts: 3,
+		},
+		{
+			desc:            "bounded",
+			layers:          maxStackedMounts + 1,
+			expectCode:      codes.Internal,
+			expectMsgPrefix: "unable to unmount",
+			expectUnmounts:  maxStackedMounts,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			m := fake.New()
+			client, workloadAPISocketDir := startDriverWithConfig(t, Config{Mounter: m})
+			targetPath := filepath.Join(t.TempDir(), "target-path")
+			require.NoError(t, os.Mkdir(targetPath, 0750))
+			for range tt.layers {
+				m.AddMount(targetPath, fake.Mount{Source: workloadAPISocketDir})
+			}
+
+			_, err := client.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+				VolumeId:   "volumeID",
+				TargetPath: targetPath,
+			})
+			requireGRPCStatusPrefix(t, err, tt.expectCode, tt.expectMsgPrefix)
+			assert.Len(t, m.Calls(fake.OpUnmountWithOptions), tt.expectUnmounts)
+		})
 	}
 }
 
-func requireProtoEqual(tb testing.TB, expected, actual interface{}, msgAndArgs ...interface{}) {
-	// The CSI spec codegen uses the old proto package, which doesn't have
-	// good comparison support, so just render the structs as json and
-	// compare.
-	expectedJSON, err := json.Marshal(expected)
-	require.NoError(tb, err, "expected cannot be marshaled to JSON")
-	actualJSON, err := json.Marshal(actual)
-	require.NoError(tb, err, "actual cannot be marshaled to JSON")
-	require.JSONEq(tb, string(expectedJSON), string(actualJSON), msgAndArgs...)
-}
+func TestNodeUnpublishVolumeUnmountOptions(t *testing.T) {
+	t.Parallel()
 
-type client struct {
-	csi.IdentityClient
-	csi.NodeClient
-}
+	for _, tt := range []struct {
+		desc        string
+		lazyUnmount bool
+	}{
+		{desc: "retries only"},
+		{desc: "lazy unmount", lazyUnmount: true},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
 
-func startDriver(t *testing.T) (client, string) {
-	workloadAPISocketDir := t.TempDir()
+			m := fake.New()
+			client, workloadAPISocketDir := startDriverWithConfig(t, Config{Mounter: m, LazyUnmount: tt.lazyUnmount})
+			targetPath := filepath.Join(t.TempDir(), "target-path")
+			require.NoError(t, os.Mkdir(targetPath, 0750))
+			m.AddMount(targetPath, fake.Mount{Source: workloadAPISocketDir})
 
-	d, err := New(Config{
-		Log:                  logr.Discard(),
-		NodeID:               testNodeID,
-		PluginName:           "csi.spiffe.io",
-		WorkloadAPISocketDir: workloadAPISocketDir,
-	})
-	require.NoError(t, err)
+			_, err := client.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+				VolumeId:   "volumeID",
+				TargetPath: targetPath,
+			})
+			require.NoError(t, err)
 
-	l, err := net.Listen("tcp", "localhost:0")
-	require.NoError(t, err)
-	t.Cleanup(func() { _ = l.Close() })
-	s := grpc.NewServer()
-	t.Cleanup(s.Stop)
+			var opts []mount.UnmountOptions
+			for _, call := range m.Calls(fake.OpUnmountWithOptions) {
+				opts = append(opts, call.Args[1].(mount.UnmountOptions))
+			}
+			expectOpts := mount.DefaultUnmountOptions()
+			expectOpts.Detach = tt.lazyUnmount
+			assert.Equal(t, []mount.UnmountOptions{expectOpts}, opts)
+		})
+	}
+}
 
-	csi.RegisterIdentityServer(s, d)
-	csi.RegisterNodeServer(s, d)
+func TestConcurrentOperationsAborted(t *testing.T) {
+	t.Parallel()
 
-	connCh := make(chan *grpc.ClientConn, 1)
-	errCh := make(chan error, 2)
+	targetPath := filepath.Join(t.TempDir(), "target-path")
+	otherTargetPath := filepath.Join(t.TempDir(), "target-path")
+	m := &blockingMounter{
+		Mounter:    fake.New(),
+		mountPoint: targetPath,
+		entered:    make(chan struct{}),
+		unblock:    make(chan struct{}),
+	}
+	client, workloadAPISocketDir := startDriverWithConfig(t, Config{Mounter: m})
 
+	published := make(chan error, 1)
 	go func() {
-		errCh <- s.Serve(l) // failures to serve will
-	}()
-	go func() {
-		conn, err := grpc.NewClient(l.Addr().String(),
-			grpc.WithTransportCredentials(insecure.NewCredentials()))
-		if err != nil {
-			errCh <- err
-		} else {
-			connCh <- conn
-		}
+		_, err := client.NodePublishVolume(context.Background(), newPublishRequest("volumeID", targetPath))
+		published <- err
 	}()
+	<-m.entered
 
-	var conn *grpc.ClientConn
-	select {
-	case conn = <-connCh:
-		t.Cleanup(func() { _ = conn.Close() })
-	case err := <-errCh:
-		require.NoError(t, err)
-	}
+	// Calls for the volume, whether by ID or by path, wait for the
+	// publish to finish.
+	_, err := client.NodePublishVolume(context.Background(), newPublishRequest("volumeID", targetPath))
+	requireGRPCStatusPrefix(t, err, codes.Aborted, `an operation on volume "volumeID"`)
+	_, err = client.NodePublishVolume(context.Background(), newPublishRequest("volumeID", otherTargetPath))
+	requireGRPCStatusPrefix(t, err, codes.Aborted, `an operation on volume "volumeID"`)
+	_, err = client.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   "otherVolumeID",
+		TargetPath: targetPath,
+	})
+	requireGRPCStatusPrefix(t, err, codes.Aborted, `an operation on volume "otherVolumeID"`)
+	_, err = client.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+		VolumeId:   "volumeID",
+		VolumePath: targetPath + "/",
+	})
+	requireGRPCStatusPrefix(t, err, codes.Aborted, `an operation on volume "volumeID"`)
 
-	return client{
-		IdentityClient: csi.NewIdentityClient(conn),
-		NodeClient:     csi.NewNodeClient(conn),
-	}, workloadAPISocketDir
-}
+	// Other volumes are not held up.
+	_, err = client.NodePublishVolume(context.Background(), newPublishRequest("otherVolumeID", otherTargetPath))
+	require.NoError(t, err)
+	assertMounted(t, m.Mounter, otherTargetPath, workloadAPISocketDir)
 
-func assertMounted(t *testing.T, targetPath, src string) {
-	meta, err := readMeta(targetPath)
-	if assert.NoError(t, err) {
-		assert.Equal(t, src, meta)
-	}
-}
+	close(m.unblock)
+	require.NoError(t, <-published)
+	assertMounted(t, m.Mounter, targetPath, workloadAPISocketDir)
 
-func assertNotMounted(t *testing.T, targetPath string) {
-	_, err := readMeta(targetPath)
-	assert.Error(t, err, "should not be mounted")
+	// Once done, the volume can be operated on again.
+	_, err = client.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   "volumeID",
+		TargetPath: targetPath,
+	})
+	require.NoError(t, err)
+	assertNotMounted(t, m.Mounter, targetPath)
+}
+
+func TestConcurrentPublishUnpublish(t *testing.T) {
+	t.Parallel()
+
+	for _, volumeLayout := range []VolumeLayout{DirectoryLayout, SocketLayout, CompositeLayout} {
+		t.Run(string(volumeLayout), func(t *testing.T) {
+			t.Parallel()
+
+			m := &stackCheckingMounter{Mounter: fake.New()}
+			client, workloadAPISocketDir := startDriverWithConfig(t, Config{
+				Mounter:               m,
+				VolumeLayout:          volumeLayout,
+				WorkloadAPISocketName: "socket",
+			})
+			require.NoError(t, os.WriteFile(filepath.Join(workloadAPISocketDir, "socket"), nil, 0600))
+			targetPath := filepath.Join(t.TempDir(), "target-path")
+
+			// Storm a single volume with publishes and unpublishes. Each
+			// call either goes through or is aborted because another one
+			// is in progress.
+			var wg sync.WaitGroup
+			for i := range 8 {
+				wg.Go(func() {
+					for j := range 50 {
+						var err error
+						if (i+j)%2 == 0 {
+							_, err = client.NodePublishVolume(context.Background(), newPublishRequest("volumeID", targetPath))
+						} else {
+							_, err = client.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+								VolumeId:   "volumeID",
+								TargetPath: targetPath,
+							})
+						}
+						if code := status.Code(err); code != codes.OK && code != codes.Aborted {
+							assert.NoError(t, err)
+						}
+					}
+				})
+			}
+			wg.Wait()
+			assert.Empty(t, m.stacked(), "mounts stacked on the same mount point")
+
+			// Nothing is left behind once the volume is unpublished.
+			_, err := client.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+				VolumeId:   "volumeID",
+				TargetPath: targetPath,
+			})
+			require.NoError(t, err)
+			assertNotMounted(t, m.Mounter, targetPath)
+			assert.NoFileExists(t, targetPath)
+			for _, mountPoint := range m.mountPoints() {
+				assert.Empty(t, m.Mounts(mountPoint), "mount leaked on %q", mountPoint)
+			}
+		})
+	}
+}
+
+func TestOperationTimeouts(t *testing.T) {
+	t.Parallel()
+
+	t.Run("publish", func(t *testing.T) {
+		t.Parallel()
+
+		m := &hangingMounter{Mounter: fake.New(), hangBind: true, unblock: make(chan struct{})}
+		client, workloadAPISocketDir := startDriverWithConfig(t, Config{
+			Mounter:      m,
+			MountTimeout: 50 * time.Millisecond,
+		})
+		targetPath := filepath.Join(t.TempDir(), "target-path")
+
+		_, err := client.NodePublishVolume(context.Background(), newPublishRequest("volumeID", targetPath))
+		requireGRPCStatusPrefix(t, err, codes.DeadlineExceeded, "publish did not complete and is left running")
+
+		// The volume stays claimed by the publish still in progress.
+		_, err = client.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+			VolumeId:   "volumeID",
+			TargetPath: targetPath,
+		})
+		requireGRPCStatusPrefix(t, err, codes.Aborted, `an operation on volume "volumeID"`)
+
+		// Once the mount returns, the volume is published.
+		close(m.unblock)
+		require.EventuallyWithT(t, func(c *assert.CollectT) {
+			_, err := client.NodePublishVolume(context.Background(), newPublishRequest("volumeID", targetPath))
+			assert.NoError(c, err)
+		}, 5*time.Second, 10*time.Millisecond)
+		assertMounted(t, m.Mounter, targetPath, workloadAPISocketDir)
+		assert.Len(t, m.Calls(fake.OpBindMountRW), 1)
+	})
+
+	t.Run("health check", func(t *testing.T) {
+		t.Parallel()
+
+		m := &hangingMounter{Mounter: fake.New(), unblock: make(chan struct{})}
+		client, _ := startDriverWithConfig(t, Config{
+			Mounter:            m,
+			HealthCheckTimeout: 50 * time.Millisecond,
+		})
+		targetPath := filepath.Join(t.TempDir(), "target-path")
+		_, err := client.NodePublishVolume(context.Background(), newPublishRequest("volumeID", targetPath))
+		require.NoError(t, err)
+
+		m.hangIsMountPoint.Store(true)
+		defer close(m.unblock)
+		_, err = client.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+			VolumeId:   "volumeID",
+			VolumePath: targetPath,
+		})
+		requireGRPCStatusPrefix(t, err, codes.DeadlineExceeded, "health check did not complete and is left running")
+	})
+
+	t.Run("bounded workers", func(t *testing.T) {
+		t.Parallel()
+
+		m := &hangingMounter{Mounter: fake.New(), hangBind: true, unblock: make(chan struct{})}
+		client, _ := startDriverWithConfig(t, Config{
+			Mounter:          m,
+			MountTimeout:     50 * time.Millisecond,
+			MaxBlockingCalls: 2,
+		})
+		targetPathBase := t.TempDir()
+
+		for i := range 5 {
+			volumeID := fmt.Sprintf("volume-%d", i)
+			_, err := client.NodePublishVolume(context.Background(), newPublishRequest(volumeID, filepath.Join(targetPathBase, volumeID)))
+			if i < 2 {
+				requireGRPCStatusPrefix(t, err, codes.DeadlineExceeded, "publish did not complete")
+			} else {
+				requireGRPCStatusPrefix(t, err, codes.DeadlineExceeded, "publish did not start: all 2 workers are busy")
+			}
+		}
+		// Only the calls that got a worker are stuck.
+		assert.EqualValues(t, 2, m.hung.Load())
+
+		// Workers are available again once the stuck calls return.
+		close(m.unblock)
+		require.EventuallyWithT(t, func(c *assert.CollectT) {
+			_, err := client.NodePublishVolume(context.Background(), newPublishRequest("volume-4", filepath.Join(targetPathBase, "volume-4")))
+			assert.NoError(c, err)
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("stuck health checks do not hold up publish", func(t *testing.T) {
+		t.Parallel()
+
+		checkedBase := t.TempDir()
+		m := &hangingMounter{Mounter: fake.New(), hangUnder: checkedBase, unblock: make(chan struct{})}
+		client, _ := startDriverWithConfig(t, Config{
+			Mounter:            m,
+			HealthCheckTimeout: 50 * time.Millisecond,
+			MaxBlockingCalls:   2,
+		})
+
+		for i := range 3 {
+			volumeID := fmt.Sprintf("volume-%d", i)
+			_, err := client.NodePublishVolume(context.Background(), newPublishRequest(volumeID, filepath.Join(checkedBase, volumeID)))
+			require.NoError(t, err)
+		}
+
+		m.hangIsMountPoint.Store(true)
+		defer close(m.unblock)
+		for i := range 3 {
+			volumeID := fmt.Sprintf("volume-%d", i)
+			_, err := client.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+				VolumeId:   volumeID,
+				VolumePath: filepath.Join(checkedBase, volumeID),
+			})
+			if i < 2 {
+				requireGRPCStatusPrefix(t, err, codes.DeadlineExceeded, "health check did not complete")
+			} else {
+				requireGRPCStatusPrefix(t, err, codes.DeadlineExceeded, "health check did not start: all 2 workers are busy")
+			}
+		}
+		assert.EqualValues(t, 2, m.hung.Load())
+
+		// Publish and unpublish have workers of their own.
+		targetPath := filepath.Join(t.TempDir(), "target-path")
+		_, err := client.NodePublishVolume(context.Background(), newPublishRequest("volume-3", targetPath))
+		require.NoError(t, err)
+		_, err = client.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+			VolumeId:   "volume-3",
+			TargetPath: targetPath,
+		})
+		require.NoError(t, err)
+	})
+}
+
+func TestCollapseStackedMounts(t *testing.T) {
+	t.Parallel()
+
+	m := fake.New()
+	workloadAPISocketDir := t.TempDir()
+	var logged []string
+	d, err := New(Config{
+		Log: funcr.New(func(_, args string) {
+			logged = append(logged, args)
+		}, funcr.Options{}),
+		NodeID:               testNodeID,
+		WorkloadAPISocketDir: workloadAPISocketDir,
+		Mounter:              m,
+	})
+	require.NoError(t, err)
+
+	stacked := filepath.Join(t.TempDir(), "stacked")
+	single := filepath.Join(t.TempDir(), "single")
+	foreign := filepath.Join(t.TempDir(), "foreign")
+	partial := filepath.Join(t.TempDir(), "partial")
+	for path, sources := range map[string][]string{
+		stacked: {workloadAPISocketDir, workloadAPISocketDir, workloadAPISocketDir},
+		single:  {workloadAPISocketDir},
+		foreign: {workloadAPISocketDir, workloadAPISocketDir, "/somewhere/else"},
+		partial: {workloadAPISocketDir, workloadAPISocketDir, "/somewhere/else", workloadAPISocketDir},
+	} {
+		require.NoError(t, os.Mkdir(path, 0750))
+		for _, source := range sources {
+			m.AddMount(path, fake.Mount{Source: source})
+		}
+	}
+
+	removed, err := d.CollapseStackedMounts()
+	require.NoError(t, err)
+	assert.Equal(t, 3, removed)
+	assert.Len(t, m.Mounts(stacked), 1)
+	assert.Len(t, m.Mounts(single), 1)
+	assert.Len(t, m.Mounts(foreign), 3)
+	assert.Len(t, m.Mounts(partial), 3)
+
+	// Only the layers actually removed are logged.
+	var collapsed []string
+	for _, args := range logged {
+		if strings.Contains(args, `"msg"="Collapsed stacked mounts"`) {
+			collapsed = append(collapsed, args)
+		}
+	}
+	assert.ElementsMatch(t, []string{
+		fmt.Sprintf(`"level"=0 "msg"="Collapsed stacked mounts" "volumePath"=%q "removed"=2`, stacked),
+		fmt.Sprintf(`"level"=0 "msg"="Collapsed stacked mounts" "volumePath"=%q "removed"=1`, partial),
+	}, collapsed)
+}
+
+func TestHardenedMounts(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		desc            string
+		config          Config
+		setup           func(m *fake.Mounter)
+		expectOptions   mount.BindOptions
+		expectCode      codes.Code
+		expectMsgPrefix string
+	}{
+		{
+			desc:          "hardened",
+			config:        Config{HardenMounts: true},
+			expectOptions: mount.BindOptions{NoSuid: true, NoDev: true, NoExec: true},
+			expectCode:    codes.OK,
+		},
+		{
+			desc:          "hardened read-only",
+			config:        Config{HardenMounts: true, ReadOnlyHostMounts: true},
+			expectOptions: mount.BindOptions{ReadOnly: true, NoSuid: true, NoDev: true, NoExec: true},
+			expectCode:    codes.OK,
+		},
+		{
+			desc:   "verify bind options failure",
+			config: Config{HardenMounts: true},
+			setup: func(m *fake.Mounter) {
+				m.FailOn(fake.OpVerifyBindOptions, "", fake.ErrInjected)
+			},
+			expectCode:      codes.Internal,
+			expectMsgPrefix: "unable to mount",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			m := fake.New()
+			if tt.setup != nil {
+				tt.setup(m)
+			}
+			tt.config.Mounter = m
+			client, workloadAPISocketDir := startDriverWithConfig(t, tt.config)
+			targetPath := filepath.Join(t.TempDir(), "target-path")
+
+			_, err := client.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:   "volumeID",
+				TargetPath: targetPath,
+				Readonly:   true,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{},
+					AccessMode: &csi.VolumeCapability_AccessMode{},
+				},
+				VolumeContext: map[string]string{
+					"csi.storage.k8s.io/ephemeral": "true",
+				},
+			})
+			requireGRPCStatusPrefix(t, err, tt.expectCode, tt.expectMsgPrefix)
+			if err != nil {
+				// The bind mount with missing attributes is undone.
+				assertNotMounted(t, m, targetPath)
+				return
+			}
+			assertMounted(t, m, targetPath, workloadAPISocketDir)
+			mnt, _ := m.TopMount(targetPath)
+			assert.Equal(t, tt.expectOptions, mnt.Options)
+		})
+	}
+}
+
+func TestMountFlags(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		desc            string
+		config          Config
+		mountFlags      []string
+		expectOptions   mount.BindOptions
+		expectTmpfs     string
+		expectCode      codes.Code
+		expectMsgPrefix string
+	}{
+		{
+			desc:          "allowlisted flags",
+			mountFlags:    []string{"nosuid", "nodev,noexec"},
+			expectOptions: mount.BindOptions{NoSuid: true, NoDev: true, NoExec: true},
+			expectCode:    codes.OK,
+		},
+		{
+			desc:          "merged with hardening",
+			config:        Config{ReadOnlyHostMounts: true},
+			mountFlags:    []string{"noexec"},
+			expectOptions: mount.BindOptions{ReadOnly: true, NoExec: true},
+			expectCode:    codes.OK,
+		},
+		{
+			desc:            "disallowed flag",
+			mountFlags:      []string{"nosuid,suid"},
+			expectCode:      codes.InvalidArgument,
+			expectMsgPrefix: `request volume capability mount flag "suid" is not allowed`,
+		},
+		{
+			desc:            "SELinux context on a bind mount",
+			mountFlags:      []string{"nosuid", `context="system_u:object_r:container_file_t:s0:c1,c2"`},
+			expectCode:      codes.InvalidArgument,
+			expectMsgPrefix: `request volume capability mount flag "context=\"system_u:object_r:container_file_t:s0:c1,c2\"" cannot be applied with the directory volume layout unless SELinux relabeling is enabled`,
+		},
+		{
+			desc:          "SELinux context on a relabeled bind mount",
+			config:        Config{SELinuxRelabel: true},
+			mountFlags:    []string{"nosuid", `context="system_u:object_r:container_file_t:s0:c1,c2"`},
+			expectOptions: mount.BindOptions{NoSuid: true},
+			expectCode:    codes.OK,
+		},
+		{
+			desc:            "invalid SELinux context",
+			mountFlags:      []string{"context=container_file_t"},
+			expectCode:      codes.InvalidArgument,
+			expectMsgPrefix: `request volume capability mount flag "context=container_file_t" is invalid: invalid SELinux label "container_file_t"`,
+		},
+		{
+			desc: "SELinux context on the composite tmpfs",
+			config: Config{
+				WorkloadAPISocketName: "spire-agent.sock",
+				VolumeLayout:          CompositeLayout,
+			},
+			mountFlags:    []string{"nodev", `context="system_u:object_r:container_file_t:s0:c1,c2"`},
+			expectOptions: mount.BindOptions{NoDev: true},
+			expectTmpfs:   `size=1m,mode=0755,context="system_u:object_r:container_file_t:s0:c1,c2"`,
+			expectCode:    codes.OK,
+		},
+		{
+			desc: "conflicting SELinux contexts",
+			config: Config{
+				WorkloadAPISocketName: "spire-agent.sock",
+				VolumeLayout:          CompositeLayout,
+			},
+			mountFlags:      []string{"context=system_u:object_r:container_file_t:s0", "context=system_u:object_r:tmp_t:s0"},
+			expectCode:      codes.InvalidArgument,
+			expectMsgPrefix: "request volume capability mount flags contain conflicting SELinux labels",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			m := fake.New()
+			tt.config.Mounter = m
+			client, workloadAPISocketDir := startDriverWithConfig(t, tt.config)
+			require.NoError(t, os.WriteFile(filepath.Join(workloadAPISocketDir, "spire-agent.sock"), nil, 0600))
+			targetPath := filepath.Join(t.TempDir(), "target-path")
+
+			_, err := client.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:   "volumeID",
+				TargetPath: targetPath,
+				Readonly:   true,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{
+							MountFlags: tt.mountFlags,
+						},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{},
+				},
+				VolumeContext: map[string]string{
+					"csi.storage.k8s.io/ephemeral": "true",
+				},
+			})
+			requireGRPCStatusPrefix(t, err, tt.expectCode, tt.expectMsgPrefix)
+			if err != nil {
+				assertNotMounted(t, m, targetPath)
+				return
+			}
+
+			bindPath := targetPath
+			if tt.expectTmpfs != "" {
+				tmpfs, ok := m.TopMount(targetPath)
+				require.True(t, ok)
+				assert.Equal(t, tt.expectTmpfs, tmpfs.Data)
+				bindPath = filepath.Join(targetPath, "workload-api")
+			}
+			mnt, ok := m.TopMount(bindPath)
+			require.True(t, ok)
+			assert.Equal(t, tt.expectOptions, mnt.Options)
+		})
+	}
+}
+
+func TestSELinuxRelabel(t *testing.T) {
+	t.Parallel()
+
+	const (
+		podContext  = `context="system_u:object_r:container_file_t:s0:c1,c2"`
+		sharedLabel = "system_u:object_r:container_file_t:s0"
+	)
+
+	for _, tt := range []struct {
+		desc            string
+		config          Config
+		setup           func(m *fake.Mounter)
+		mountFlags      []string
+		expectLabel     string
+		expectCode      codes.Code
+		expectMsgPrefix string
+	}{
+		{
+			desc:        "relabeled without categories",
+			config:      Config{SELinuxRelabel: true, WorkloadAPISocketName: "spire-agent.sock"},
+			mountFlags:  []string{podContext},
+			expectLabel: sharedLabel,
+			expectCode:  codes.OK,
+		},
+		{
+			desc: "relabeled with the socket layout",
+			config: Config{
+				SELinuxRelabel:        true,
+				WorkloadAPISocketName: "spire-agent.sock",
+				VolumeLayout:          SocketLayout,
+			},
+			mountFlags:  []string{podContext},
+			expectLabel: sharedLabel,
+			expectCode:  codes.OK,
+		},
+		{
+			desc:            "relabeling disabled",
+			config:          Config{WorkloadAPISocketName: "spire-agent.sock"},
+			mountFlags:      []string{podContext},
+			expectLabel:     fake.UnlabeledSELinuxLabel,
+			expectCode:      codes.InvalidArgument,
+			expectMsgPrefix: "request volume capability mount flag",
+		},
+		{
+			desc: "relabeling disabled with the composite layout",
+			config: Config{
+				WorkloadAPISocketName: "spire-agent.sock",
+				VolumeLayout:          CompositeLayout,
+			},
+			mountFlags:  []string{podContext},
+			expectLabel: fake.UnlabeledSELinuxLabel,
+			expectCode:  codes.OK,
+		},
+		{
+			desc:        "no context requested",
+			config:      Config{SELinuxRelabel: true, WorkloadAPISocketName: "spire-agent.sock"},
+			expectLabel: fake.UnlabeledSELinuxLabel,
+			expectCode:  codes.OK,
+		},
+		{
+			desc:   "relabel failure",
+			config: Config{SELinuxRelabel: true, WorkloadAPISocketName: "spire-agent.sock"},
+			setup: func(m *fake.Mounter) {
+				m.FailOn(fake.OpSetSELinuxLabel, "", fake.ErrInjected)
+			},
+			mountFlags:      []string{podContext},
+			expectLabel:     fake.UnlabeledSELinuxLabel,
+			expectCode:      codes.Internal,
+			expectMsgPrefix: "unable to mount",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			m := fake.New()
+			if tt.setup != nil {
+				tt.setup(m)
+			}
+			tt.config.Mounter = m
+			client, workloadAPISocketDir := startDriverWithConfig(t, tt.config)
+			socketPath := filepath.Join(workloadAPISocketDir, "spire-agent.sock")
+			require.NoError(t, os.WriteFile(socketPath, nil, 0600))
+			targetPath := filepath.Join(t.TempDir(), "target-path")
+
+			_, err := client.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:   "volumeID",
+				TargetPath: targetPath,
+				Readonly:   true,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{
+							MountFlags: tt.mountFlags,
+						},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{},
+				},
+				VolumeContext: map[string]string{
+					"csi.storage.k8s.io/ephemeral": "true",
+				},
+			})
+			requireGRPCStatusPrefix(t, err, tt.expectCode, tt.expectMsgPrefix)
+
+			for _, path := range []string{workloadAPISocketDir, socketPath} {
+				label, err := m.GetSELinuxLabel(path)
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectLabel, label, path)
+			}
+		})
+	}
+}
+
+func TestSELinuxRelabelConflict(t *testing.T) {
+	m := fake.New()
+	client, workloadAPISocketDir := startDriverWithConfig(t, Config{
+		Mounter:               m,
+		SELinuxRelabel:        true,
+		WorkloadAPISocketName: "spire-agent.sock",
+	})
+	require.NoError(t, os.WriteFile(filepath.Join(workloadAPISocketDir, "spire-agent.sock"), nil, 0600))
+
+	publish := func(targetPath, seLinuxContext string) error {
+		_, err := client.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+			VolumeId:   filepath.Base(targetPath),
+			TargetPath: targetPath,
+			Readonly:   true,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{
+						MountFlags: []string{seLinuxContext},
+					},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{},
+			},
+			VolumeContext: map[string]string{
+				"csi.storage.k8s.io/ephemeral": "true",
+			},
+		})
+		return err
+	}
+
+	dir := t.TempDir()
+	require.NoError(t, publish(filepath.Join(dir, "a"), `context="system_u:object_r:container_file_t:s0:c1,c2"`))
+	// Other categories of the same type share the label.
+	require.NoError(t, publish(filepath.Join(dir, "b"), `context="system_u:object_r:container_file_t:s0:c3,c4"`))
+
+	err := publish(filepath.Join(dir, "c"), "context=system_u:object_r:spc_t:s0")
+	requireGRPCStatusPrefix(t, err, codes.FailedPrecondition, `conflicting SELinux label: the workload API socket is labeled "system_u:object_r:container_file_t:s0" for other volumes; refusing to relabel it to "system_u:object_r:spc_t:s0"`)
+	assertNotMounted(t, m, filepath.Join(dir, "c"))
+	label, err := m.GetSELinuxLabel(workloadAPISocketDir)
+	require.NoError(t, err)
+	assert.Equal(t, "system_u:object_r:container_file_t:s0", label)
+}
+
+func TestIDMappedMounts(t *testing.T) {
+	t.Parallel()
+
+	const userns = `{"uidMappings":[{"hostId":65536,"containerId":0,"length":65536}],"gidMappings":[{"hostId":131072,"containerId":0,"length":65536}]}`
+	expectIDMap := mount.IDMap{
+		UIDs: []mount.IDMapping{{ContainerID: 0, HostID: 65536, Size: 65536}},
+		GIDs: []mount.IDMapping{{ContainerID: 0, HostID: 131072, Size: 65536}},
+	}
+
+	for _, tt := range []struct {
+		desc            string
+		userns          string
+		idmapAttribute  string
+		unsupported     bool
+		expectIDMapped  bool
+		expectCode      codes.Code
+		expectMsgPrefix string
+	}{
+		{
+			desc:           "detected from the pod user namespace",
+			userns:         userns,
+			expectIDMapped: true,
+			expectCode:     codes.OK,
+		},
+		{
+			desc:           "required",
+			userns:         userns,
+			idmapAttribute: "true",
+			expectIDMapped: true,
+			expectCode:     codes.OK,
+		},
+		{
+			desc:           "disabled by volume attribute",
+			userns:         userns,
+			idmapAttribute: "false",
+			expectCode:     codes.OK,
+		},
+		{
+			desc:       "pod without user namespace",
+			expectCode: codes.OK,
+		},
+		{
+			desc:        "falls back when unsupported",
+			userns:      userns,
+			unsupported: true,
+			expectCode:  codes.OK,
+		},
+		{
+			desc:            "required but unsupported",
+			userns:          userns,
+			idmapAttribute:  "true",
+			unsupported:     true,
+			expectCode:      codes.Internal,
+			expectMsgPrefix: "unable to mount",
+		},
+		{
+			desc:            "required without user namespace",
+			idmapAttribute:  "true",
+			expectCode:      codes.FailedPrecondition,
+			expectMsgPrefix: "ID-mapped mount requested but the pod does not run in a user namespace",
+		},
+		{
+			desc:            "invalid volume attribute",
+			idmapAttribute:  "bogus",
+			expectCode:      codes.InvalidArgument,
+			expectMsgPrefix: `invalid idmap volume attribute "bogus"`,
+		},
+		{
+			desc:            "invalid pod user namespace",
+			userns:          "{",
+			expectCode:      codes.Internal,
+			expectMsgPrefix: "unable to parse pod user namespace",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			m := fake.New()
+			client, workloadAPISocketDir := startDriverWithConfig(t, Config{Mounter: m})
+
+			podDir := filepath.Join(t.TempDir(), "pods", "c3a32fc0-f186-4974-8579-429dea58ec6d")
+			targetPath := filepath.Join(podDir, "volumes", "kubernetes.io~csi", "spiffe-workload-api", "mount")
+			require.NoError(t, os.MkdirAll(filepath.Dir(targetPath), 0750))
+			if tt.userns != "" {
+				require.NoError(t, os.WriteFile(filepath.Join(podDir, "userns"), []byte(tt.userns), 0600))
+			}
+
+			if tt.unsupported {
+				m.FailOn(fake.OpBindMountIDMapped, "", fmt.Errorf("%w: fake", mount.ErrIDMapUnsupported))
+			}
+
+			volumeContext := map[string]string{
+				"csi.storage.k8s.io/ephemeral": "true",
+			}
+			if tt.idmapAttribute != "" {
+				volumeContext["idmap"] = tt.idmapAttribute
+			}
+			_, err := client.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:   "volumeID",
+				TargetPath: targetPath,
+				Readonly:   true,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{},
+					AccessMode: &csi.VolumeCapability_AccessMode{},
+				},
+				VolumeContext: volumeContext,
+			})
+			requireGRPCStatusPrefix(t, err, tt.expectCode, tt.expectMsgPrefix)
+			if err != nil {
+				assertNotMounted(t, m, targetPath)
+				return
+			}
+			assertMounted(t, m, targetPath, workloadAPISocketDir)
+
+			mnt, _ := m.TopMount(targetPath)
+			if !tt.expectIDMapped {
+				assert.Nil(t, mnt.IDMap)
+				return
+			}
+			require.NotNil(t, mnt.IDMap)
+			assert.Equal(t, expectIDMap, *mnt.IDMap)
+		})
+	}
+}
+
+func TestMountPropagation(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		desc            string
+		config          Config
+		setup           func(m *fake.Mounter)
+		expectMounts    []string
+		expectCode      codes.Code
+		expectMsgPrefix string
+	}{
+		{
+			desc:         "directory layout",
+			config:       Config{MountPropagation: mount.PropagationPrivate},
+			expectMounts: []string{""},
+			expectCode:   codes.OK,
+		},
+		{
+			desc: "composite layout",
+			config: Config{
+				MountPropagation:      mount.PropagationSlave,
+				WorkloadAPISocketName: "spire-agent.sock",
+				VolumeLayout:          CompositeLayout,
+			},
+			expectMounts: []string{"", "workload-api"},
+			expectCode:   codes.OK,
+		},
+		{
+			desc:   "verify propagation failure",
+			config: Config{MountPropagation: mount.PropagationUnbindable},
+			setup: func(m *fake.Mounter) {
+				m.FailOn(fake.OpVerifyPropagation, "", fake.ErrInjected)
+			},
+			expectCode:      codes.Internal,
+			expectMsgPrefix: "unable to mount",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			m := fake.New()
+			if tt.setup != nil {
+				tt.setup(m)
+			}
+			tt.config.Mounter = m
+			client, _ := startDriverWithConfig(t, tt.config)
+			targetPath := filepath.Join(t.TempDir(), "target-path")
+
+			_, err := client.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:   "volumeID",
+				TargetPath: targetPath,
+				Readonly:   true,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{},
+					AccessMode: &csi.VolumeCapability_AccessMode{},
+				},
+				VolumeContext: map[string]string{
+					"csi.storage.k8s.io/ephemeral": "true",
+				},
+			})
+			requireGRPCStatusPrefix(t, err, tt.expectCode, tt.expectMsgPrefix)
+			if err != nil {
+				assertNotMounted(t, m, targetPath)
+				return
+			}
+			for _, name := range tt.expectMounts {
+				mnt, ok := m.TopMount(filepath.Join(targetPath, name))
+				require.True(t, ok, name)
+				assert.Equal(t, tt.config.MountPropagation, mnt.Propagation, name)
+			}
+		})
+	}
+}
+
+func TestHostMountCheck(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		desc            string
+		config          Config
+		setup           func(m *fake.Mounter)
+		expectCode      codes.Code
+		expectMsgPrefix string
+	}{
+		{
+			desc:       "disabled",
+			config:     Config{},
+			expectCode: codes.OK,
+		},
+		{
+			desc:       "propagated",
+			config:     Config{HostPID: 1},
+			expectCode: codes.OK,
+		},
+		{
+			desc: "propagated socket",
+			config: Config{
+				HostPID:               1,
+				WorkloadAPISocketName: "spire-agent.sock",
+				VolumeLayout:          SocketLayout,
+			},
+			expectCode: codes.OK,
+		},
+		{
+			desc:   "mount missing from host",
+			config: Config{HostPID: 1},
+			setup: func(m *fake.Mounter) {
+				m.SetMountsPropagate(false)
+			},
+			expectCode:      codes.FailedPrecondition,
+			expectMsgPrefix: "mount did not propagate to the host mount namespace",
+		},
+		{
+			desc:   "host mount info failure",
+			config: Config{HostPID: 1},
+			setup: func(m *fake.Mounter) {
+				m.FailOn(fake.OpIsMountedIn, "", fake.ErrInjected)
+			},
+			expectCode:      codes.Internal,
+			expectMsgPrefix: "unable to check mount point",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			m := fake.New()
+			if tt.setup != nil {
+				tt.setup(m)
+			}
+			tt.config.Mounter = m
+			client, workloadAPISocketDir := startDriverWithConfig(t, tt.config)
+			if tt.config.WorkloadAPISocketName != "" {
+				require.NoError(t, os.WriteFile(filepath.Join(workloadAPISocketDir, tt.config.WorkloadAPISocketName), nil, 0600))
+			}
+			targetPath := filepath.Join(t.TempDir(), "target-path")
+
+			_, err := client.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:   "volumeID",
+				TargetPath: targetPath,
+				Readonly:   true,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{},
+					AccessMode: &csi.VolumeCapability_AccessMode{},
+				},
+				VolumeContext: map[string]string{
+					"csi.storage.k8s.io/ephemeral": "true",
+				},
+			})
+			requireGRPCStatusPrefix(t, err, tt.expectCode, tt.expectMsgPrefix)
+			if err != nil {
+				// The unpropagated mount must not be taken as published by
+				// the next attempt.
+				assertNotMounted(t, m, targetPath)
+			}
+		})
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		desc      string
+		hostPID   int
+		setup     func(m *fake.Mounter)
+		expectErr string
+	}{
+		{
+			desc:    "propagated",
+			hostPID: 1,
+		},
+		{
+			desc:      "no host PID",
+			expectErr: "self-test requires a host PID",
+		},
+		{
+			desc:    "mount missing from host",
+			hostPID: 1,
+			setup: func(m *fake.Mounter) {
+				m.SetMountsPropagate(false)
+			},
+			expectErr: "mount did not propagate to the host mount namespace",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			m := fake.New()
+			if tt.setup != nil {
+				tt.setup(m)
+			}
+			d, err := New(Config{
+				Log:                  logr.Discard(),
+				NodeID:               testNodeID,
+				WorkloadAPISocketDir: t.TempDir(),
+				HostPID:              tt.hostPID,
+				Mounter:              m,
+			})
+			require.NoError(t, err)
+			dir := t.TempDir()
+
+			err = d.SelfTest(dir)
+			if tt.expectErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tt.expectErr)
+			}
+
+			// Nothing is left behind either way.
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			assert.Empty(t, entries)
+		})
+	}
+}
+
+func TestCompositeVolume(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		desc              string
+		socketOnly        bool
+		trustDomain       string
+		volumeContext     map[string]string
+		expectSocketMount string
+		expectEnv         string
+	}{
+		{
+			desc:              "socket directory",
+			trustDomain:       "example.org",
+			expectSocketMount: "workload-api",
+			expectEnv:         "SPIFFE_ENDPOINT_SOCKET=unix:///spiffe-workload-api/workload-api/spire-agent.sock\n",
+		},
+		{
+			desc:              "socket only",
+			socketOnly:        true,
+			expectSocketMount: "spire-agent.sock",
+			expectEnv:         "SPIFFE_ENDPOINT_SOCKET=unix:///spiffe-workload-api/spire-agent.sock\n",
+		},
+		{
+			desc: "custom container mount path",
+			volumeContext: map[string]string{
+				"containerMountPath": "/run/spiffe",
+			},
+			expectSocketMount: "workload-api",
+			expectEnv:         "SPIFFE_ENDPOINT_SOCKET=unix:///run/spiffe/workload-api/spire-agent.sock\n",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			m := fake.New()
+			client, workloadAPISocketDir := startDriverWithConfig(t, Config{
+				Mounter:               m,
+				WorkloadAPISocketName: "spire-agent.sock",
+				VolumeLayout:          CompositeLayout,
+				CompositeSocketOnly:   tt.socketOnly,
+				TrustDomain:           tt.trustDomain,
+			})
+			require.NoError(t, os.WriteFile(filepath.Join(workloadAPISocketDir, "spire-agent.sock"), nil, 0600))
+
+			targetPath := filepath.Join(t.TempDir(), "target-path")
+			volumeContext := map[string]string{
+				"csi.storage.k8s.io/ephemeral":           "true",
+				"csi.storage.k8s.io/pod.name":            "workload",
+				"csi.storage.k8s.io/pod.namespace":       "default",
+				"csi.storage.k8s.io/serviceAccount.name": "workload-sa",
+			}
+			for k, v := range tt.volumeContext {
+				volumeContext[k] = v
+			}
+
+			_, err := client.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:   "volumeID",
+				TargetPath: targetPath,
+				Readonly:   true,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{},
+					AccessMode: &csi.VolumeCapability_AccessMode{},
+				},
+				VolumeContext: volumeContext,
+			})
+			require.NoError(t, err)
+
+			// The target path holds the tmpfs and the inner bind mount
+			// points back at the Workload API socket (directory).
+			assertMounted(t, m, targetPath, fake.TmpfsSource)
+			expectSource := workloadAPISocketDir
+			if tt.socketOnly {
+				expectSource = filepath.Join(workloadAPISocketDir, "spire-agent.sock")
+			}
+			assertMounted(t, m, filepath.Join(targetPath, tt.expectSocketMount), expectSource)
+
+			env, err := os.ReadFile(filepath.Join(targetPath, "spiffe.env"))
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectEnv, string(env))
+
+			podInfo, err := os.ReadFile(filepath.Join(targetPath, "pod.json"))
+			require.NoError(t, err)
+			assert.JSONEq(t, `{
+				"podName": "workload",
+				"podNamespace": "default",
+				"serviceAccountName": "workload-sa",
+				"nodeID": "nodeID"
+			}`, string(podInfo))
+
+			trustDomain, err := os.ReadFile(filepath.Join(targetPath, "trust-domain"))
+			if tt.trustDomain != "" {
+				require.NoError(t, err)
+				assert.Equal(t, tt.trustDomain+"\n", string(trustDomain))
+			} else {
+				assert.ErrorIs(t, err, os.ErrNotExist)
+			}
+
+			resp, err := client.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+				VolumeId:   "volumeID",
+				VolumePath: targetPath,
+			})
+			require.NoError(t, err)
+			assert.False(t, resp.VolumeCondition.Abnormal, resp.VolumeCondition.Message)
+
+			_, err = client.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+				VolumeId:   "volumeID",
+				TargetPath: targetPath,
+			})
+			require.NoError(t, err)
+			_, err = os.Stat(targetPath)
+			assert.ErrorIs(t, err, os.ErrNotExist)
+		})
+	}
+}
+
+func TestCompositeVolumeSymlinks(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"workload-api", "spiffe.env", "pod.json", "trust-domain"} {
+		for _, withKubeletRoot := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s with kubelet root %t", name, withKubeletRoot), func(t *testing.T) {
+				t.Parallel()
+
+				base := t.TempDir()
+				root := filepath.Join(base, "root")
+				victim := filepath.Join(base, "victim")
+				targetPath := filepath.Join(root, "pods", "pod-uid", "volumes", "kubernetes.io~csi", "spiffe-workload-api", "mount")
+				require.NoError(t, os.MkdirAll(targetPath, 0755))
+				// Planted before the tmpfs is mounted, which the fake
+				// mounter leaves in view.
+				require.NoError(t, os.Symlink(victim, filepath.Join(targetPath, name)))
+
+				m := fake.New()
+				config := Config{
+					Mounter:               m,
+					WorkloadAPISocketName: "spire-agent.sock",
+					VolumeLayout:          CompositeLayout,
+					TrustDomain:           "example.org",
+				}
+				if withKubeletRoot {
+					config.KubeletRootDir = root
+				}
+				client, workloadAPISocketDir := startDriverWithConfig(t, config)
+				require.NoError(t, os.WriteFile(filepath.Join(workloadAPISocketDir, "spire-agent.sock"), nil, 0600))
+				_, err := client.NodePublishVolume(context.Background(), newPublishRequest("volumeID", targetPath))
+				requireGRPCStatusPrefix(t, err, codes.Internal, "unable to mount")
+				assert.NoFileExists(t, victim)
+				assert.NoDirExists(t, victim)
+				assertNotMounted(t, m, targetPath)
+			})
+		}
+	}
+}
+
+func TestSocketVolume(t *testing.T) {
+	t.Parallel()
+
+	m := fake.New()
+	client, workloadAPISocketDir := startDriverWithConfig(t, Config{
+		Mounter:               m,
+		WorkloadAPISocketName: "spire-agent.sock",
+		SocketMountName:       "socket",
+		VolumeLayout:          SocketLayout,
+	})
+	socketPath := filepath.Join(workloadAPISocketDir, "spire-agent.sock")
+	require.NoError(t, os.WriteFile(socketPath, nil, 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(workloadAPISocketDir, "admin.sock"), nil, 0600))
+
+	targetPath := filepath.Join(t.TempDir(), "target-path")
+	socketMountPath := filepath.Join(targetPath, "socket")
+
+	publish := func() {
+		_, err := client.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+			VolumeId:   "volumeID",
+			TargetPath: targetPath,
+			Readonly:   true,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{},
+				AccessMode: &csi.VolumeCapability_AccessMode{},
+			},
+			VolumeContext: map[string]string{
+				"csi.storage.k8s.io/ephemeral": "true",
+			},
+		})
+		require.NoError(t, err)
+	}
+	getVolumeCondition := func() *csi.VolumeCondition {
+		resp, err := client.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+			VolumeId:   "volumeID",
+			VolumePath: targetPath,
+		})
+		require.NoError(t, err)
+		return resp.VolumeCondition
+	}
+
+	publish()
+
+	// Only the socket is mounted into the target path directory, under its
+	// normalized name.
+	assertNotMounted(t, m, targetPath)
+	assertMounted(t, m, socketMountPath, socketPath)
+	entries, err := os.ReadDir(targetPath)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"socket"}, names)
+
+	// Publishing again is a no-op.
+	publish()
+	assertMounted(t, m, socketMountPath, socketPath)
+
+	assert.False(t, getVolumeCondition().Abnormal)
+
+	// Simulate the agent re-creating the socket, leaving the bind mount
+	// pointing at the old inode. The health check mounts the socket again.
+	m.RemoveMount(socketMountPath)
+	m.AddMount(socketMountPath, fake.Mount{Source: "stale"})
+	assert.False(t, getVolumeCondition().Abnormal)
+	assertMounted(t, m, socketMountPath, socketPath)
+
+	// A missing socket mount is reported.
+	m.RemoveMount(socketMountPath)
+	condition := getVolumeCondition()
+	assert.True(t, condition.Abnormal)
+	assert.Equal(t, "workload API socket is not mounted in the volume", condition.Message)
+	m.AddMount(socketMountPath, fake.Mount{Source: socketPath})
+
+	_, err = client.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   "volumeID",
+		TargetPath: targetPath,
+	})
+	require.NoError(t, err)
+	_, err = os.Stat(targetPath)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// The agent socket is left alone.
+	_, err = os.Stat(socketPath)
+	assert.NoError(t, err)
+}
+
+func TestDevMode(t *testing.T) {
+	t.Parallel()
+
+	for _, layout := range []VolumeLayout{DirectoryLayout, SocketLayout, CompositeLayout} {
+		t.Run(string(layout), func(t *testing.T) {
+			t.Parallel()
+
+			dev, err := mount.NewDev(t.TempDir())
+			require.NoError(t, err)
+			client, workloadAPISocketDir := startDriverWithConfig(t, Config{
+				Mounter:               dev,
+				WorkloadAPISocketName: "spire-agent.sock",
+				VolumeLayout:          layout,
+			})
+			require.NoError(t, os.WriteFile(filepath.Join(workloadAPISocketDir, "spire-agent.sock"), []byte("socket"), 0600))
+			targetPath := filepath.Join(t.TempDir(), "target-path")
+
+			_, err = client.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:   "volumeID",
+				TargetPath: targetPath,
+				Readonly:   true,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{},
+					AccessMode: &csi.VolumeCapability_AccessMode{},
+				},
+				VolumeContext: map[string]string{
+					"csi.storage.k8s.io/ephemeral": "true",
+				},
+			})
+			require.NoError(t, err)
+
+			// The socket is reachable through the volume.
+			socketPath := map[VolumeLayout]string{
+				DirectoryLayout: "spire-agent.sock",
+				SocketLayout:    "spire-agent.sock",
+				CompositeLayout: "workload-api/spire-agent.sock",
+			}[layout]
+			data, err := os.ReadFile(filepath.Join(targetPath, socketPath))
+			require.NoError(t, err)
+			assert.Equal(t, "socket", string(data))
+
+			resp, err := client.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+				VolumeId:   "volumeID",
+				VolumePath: targetPath,
+			})
+			require.NoError(t, err)
+			assert.False(t, resp.VolumeCondition.Abnormal, resp.VolumeCondition.Message)
+
+			_, err = client.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+				VolumeId:   "volumeID",
+				TargetPath: targetPath,
+			})
+			require.NoError(t, err)
+			_, err = os.Lstat(targetPath)
+			assert.ErrorIs(t, err, os.ErrNotExist)
+		})
+	}
+}
+
+func TestKubeletRootDir(t *testing.T) {
+	t.Parallel()
+
+	const podUID = "8c5e1a34-52a5-4b5b-9e1d-7f7d2c6f1f0e"
+	volumePath := func(root string) string {
+		return filepath.Join(root, "pods", podUID, "volumes", "kubernetes.io~csi", "spiffe-workload-api", "mount")
+	}
+
+	for _, tt := range []struct {
+		desc            string
+		targetPath      func(root, outside string) string
+		volumeContext   map[string]string
+		expectCode      codes.Code
+		expectMsgPrefix string
+	}{
+		{
+			desc:       "target path of the kubelet",
+			targetPath: func(root, _ string) string { return volumePath(root) },
+			expectCode: codes.OK,
+		},
+		{
+			desc:          "target path of the pod",
+			targetPath:    func(root, _ string) string { return volumePath(root) },
+			volumeContext: map[string]string{"csi.storage.k8s.io/pod.uid": podUID},
+			expectCode:    codes.OK,
+		},
+		{
+			desc:            "target path of another pod",
+			targetPath:      func(root, _ string) string { return volumePath(root) },
+			volumeContext:   map[string]string{"csi.storage.k8s.io/pod.uid": "other"},
+			expectCode:      codes.InvalidArgument,
+			expectMsgPrefix: "target path",
+		},
+		{
+			desc:            "target path outside the root",
+			targetPath:      func(_, outside string) string { return filepath.Join(outside, "mount") },
+			expectCode:      codes.InvalidArgument,
+			expectMsgPrefix: "target path",
+		},
+		{
+			desc:            "target path escaping the root",
+			targetPath:      func(root, _ string) string { return volumePath(root) + "/../../../../../../../outside/mount" },
+			expectCode:      codes.InvalidArgument,
+			expectMsgPrefix: "target path",
+		},
+		{
+			desc:            "target path not matching the layout",
+			targetPath:      func(root, _ string) string { return filepath.Join(root, "pods", podUID, "mount") },
+			expectCode:      codes.InvalidArgument,
+			expectMsgPrefix: "target path",
+		},
+		{
+			desc:            "target path below a volume",
+			targetPath:      func(root, _ string) string { return filepath.Join(volumePath(root), "mount") },
+			expectCode:      codes.InvalidArgument,
+			expectMsgPrefix: "target path",
+		},
+		{
+			desc:            "symlinked parent",
+			targetPath:      func(root, _ string) string { return strings.Replace(volumePath(root), podUID, "escape", 1) },
+			expectCode:      codes.Internal,
+			expectMsgPrefix: "unable to create target path",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			base := t.TempDir()
+			root := filepath.Join(base, "root")
+			outside := filepath.Join(base, "outside")
+			require.NoError(t, os.MkdirAll(filepath.Dir(volumePath(root)), 0755))
+			require.NoError(t, os.MkdirAll(filepath.Dir(volumePath(outside)), 0755))
+			// A pod volume directory whose plugin directory is a symlink
+			// to the volumes of another pod.
+			require.NoError(t, os.MkdirAll(filepath.Join(root, "pods", "escape", "volumes"), 0755))
+			require.NoError(t, os.Symlink(
+				filepath.Join(outside, "pods", podUID, "volumes", "kubernetes.io~csi"),
+				filepath.Join(root, "pods", "escape", "volumes", "kubernetes.io~csi")))
+
+			m := fake.New()
+			client, workloadAPISocketDir := startDriverWithConfig(t, Config{Mounter: m, KubeletRootDir: root})
+			targetPath := tt.targetPath(root, outside)
+			volumeContext := map[string]string{"csi.storage.k8s.io/ephemeral": "true"}
+			for k, v := range tt.volumeContext {
+				volumeContext[k] = v
+			}
+
+			_, err := client.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:   "volumeID",
+				TargetPath: targetPath,
+				Readonly:   true,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{},
+					AccessMode: &csi.VolumeCapability_AccessMode{},
+				},
+				VolumeContext: volumeContext,
+			})
+			requireGRPCStatusPrefix(t, err, tt.expectCode, tt.expectMsgPrefix)
+			assert.NoDirExists(t, filepath.Join(outside, "mount"))
+			assert.NoDirExists(t, volumePath(outside))
+			if err != nil {
+				assertNotMounted(t, m, targetPath)
+				return
+			}
+			assertMounted(t, m, targetPath, workloadAPISocketDir)
+
+			resp, err := client.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+				VolumeId:   "volumeID",
+				VolumePath: targetPath,
+			})
+			require.NoError(t, err)
+			assert.False(t, resp.VolumeCondition.Abnormal, resp.VolumeCondition.Message)
+
+			_, err = client.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+				VolumeId:   "volumeID",
+				TargetPath: targetPath,
+			})
+			require.NoError(t, err)
+			assertNotMounted(t, m, targetPath)
+			assert.NoDirExists(t, targetPath)
+		})
+	}
+
+	t.Run("unclean target path", func(t *testing.T) {
+		t.Parallel()
+
+		root := t.TempDir()
+		targetPath := volumePath(root)
+		require.NoError(t, os.MkdirAll(filepath.Dir(targetPath), 0755))
+		m := fake.New()
+		client, workloadAPISocketDir := startDriverWithConfig(t, Config{Mounter: m, KubeletRootDir: root})
+
+		// The volume is published on, checked at and unpublished from the
+		// cleaned target path, whatever form of it the requests use.
+		_, err := client.NodePublishVolume(context.Background(), newPublishRequest("volumeID", targetPath+"/./"))
+		require.NoError(t, err)
+		assertMounted(t, m, targetPath, workloadAPISocketDir)
+
+		resp, err := client.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+			VolumeId:   "volumeID",
+			VolumePath: targetPath + "/",
+		})
+		require.NoError(t, err)
+		assert.False(t, resp.VolumeCondition.Abnormal, resp.VolumeCondition.Message)
+
+		_, err = client.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+			VolumeId:   "volumeID",
+			TargetPath: filepath.Dir(targetPath) + "//mount",
+		})
+		require.NoError(t, err)
+		assertNotMounted(t, m, targetPath)
+		assert.NoDirExists(t, targetPath)
+	})
+
+	t.Run("missing pod UID", func(t *testing.T) {
+		t.Parallel()
+
+		root := t.TempDir()
+		targetPath := volumePath(root)
+		require.NoError(t, os.MkdirAll(filepath.Dir(targetPath), 0755))
+		var logged []string
+		var mtx sync.Mutex
+		d, err := New(Config{
+			Log: funcr.New(func(_, args string) {
+				mtx.Lock()
+				defer mtx.Unlock()
+				logged = append(logged, args)
+			}, funcr.Options{}),
+			NodeID:               testNodeID,
+			WorkloadAPISocketDir: t.TempDir(),
+			Mounter:              fake.New(),
+			KubeletRootDir:       root,
+		})
+		require.NoError(t, err)
+
+		// The volume is published without the pod UID to check the target
+		// path against, which is logged.
+		_, err = d.NodePublishVolume(context.Background(), newPublishRequest("volumeID", targetPath))
+		require.NoError(t, err)
+		mtx.Lock()
+		defer mtx.Unlock()
+		assert.True(t, slices.ContainsFunc(logged, func(args string) bool {
+			return strings.Contains(args, "missing the pod UID volume attribute")
+		}), "missing pod UID not logged: %q", logged)
+	})
+
+	t.Run("symlinked socket mount point", func(t *testing.T) {
+		t.Parallel()
+
+		base := t.TempDir()
+		root := filepath.Join(base, "root")
+		victim := filepath.Join(base, "victim")
+		targetPath := volumePath(root)
+		require.NoError(t, os.MkdirAll(targetPath, 0755))
+		// A socket layout target path directory is writable by the pod,
+		// which plants a symlink where the socket is to be mounted.
+		require.NoError(t, os.Symlink(victim, filepath.Join(targetPath, "socket")))
+
+		m := fake.New()
+		client, _ := startDriverWithConfig(t, Config{
+			Mounter:               m,
+			KubeletRootDir:        root,
+			WorkloadAPISocketName: "spire-agent.sock",
+			SocketMountName:       "socket",
+			VolumeLayout:          SocketLayout,
+		})
+		_, err := client.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+			VolumeId:   "volumeID",
+			TargetPath: targetPath,
+			Readonly:   true,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{},
+				AccessMode: &csi.VolumeCapability_AccessMode{},
+			},
+			VolumeContext: map[string]string{"csi.storage.k8s.io/ephemeral": "true"},
+		})
+		requireGRPCStatusPrefix(t, err, codes.Internal, "unable to mount")
+		assert.NoFileExists(t, victim)
+		assertNotMounted(t, m, filepath.Join(targetPath, "socket"))
+	})
+
+	t.Run("unpublish and stats outside the layout", func(t *testing.T) {
+		t.Parallel()
+
+		root := t.TempDir()
+		client, _ := startDriverWithConfig(t, Config{KubeletRootDir: root})
+		for _, path := range []string{t.TempDir(), filepath.Join(root, "pods", podUID, "mount")} {
+			_, err := client.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+				VolumeId:   "volumeID",
+				TargetPath: path,
+			})
+			requireGRPCStatusPrefix(t, err, codes.InvalidArgument, "target path")
+
+			_, err = client.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+				VolumeId:   "volumeID",
+				VolumePath: path,
+			})
+			requireGRPCStatusPrefix(t, err, codes.InvalidArgument, "target path")
+		}
+	})
+}
+
+func TestSourceCheck(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		desc      string
+		anySocket bool
+		setup     func(t *testing.T, dir string)
+		expectErr string
+	}{
+		{
+			desc: "valid source",
+		},
+		{
+			desc:      "valid source without a socket name",
+			anySocket: true,
+			setup: func(t *testing.T, dir string) {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, "notes"), nil, 0600))
+			},
+		},
+		{
+			desc:      "socket with unexpected mode without a socket name",
+			anySocket: true,
+			setup: func(t *testing.T, dir string) {
+				require.NoError(t, os.Chmod(filepath.Join(dir, "spire-agent.sock"), 0700))
+			},
+			expectErr: "has mode 0700; expected 0777",
+		},
+		{
+			desc:      "other socket with unexpected mode without a socket name",
+			anySocket: true,
+			setup: func(t *testing.T, dir string) {
+				l, err := net.Listen("unix", filepath.Join(dir, "admin.sock"))
+				require.NoError(t, err)
+				t.Cleanup(func() { _ = l.Close() })
+				require.NoError(t, os.Chmod(filepath.Join(dir, "admin.sock"), 0700))
+			},
+			expectErr: `admin.sock" has mode 0700; expected 0777`,
+		},
+		{
+			desc: "socket not yet created",
+			setup: func(t *testing.T, dir string) {
+				require.NoError(t, os.Remove(filepath.Join(dir, "spire-agent.sock")))
+			},
+		},
+		{
+			desc: "symlinked directory",
+			setup: func(t *testing.T, dir string) {
+				other := t.TempDir()
+				require.NoError(t, os.Chmod(other, 0755))
+				require.NoError(t, os.RemoveAll(dir))
+				require.NoError(t, os.Symlink(other, dir))
+			},
+			expectErr: "is a symlink",
+		},
+		{
+			desc: "group writable directory",
+			setup: func(t *testing.T, dir string) {
+				require.NoError(t, os.Chmod(dir, 0775))
+			},
+			expectErr: "is writable by group or others (mode 0775)",
+		},
+		{
+			desc: "directory owned by another user",
+			setup: func(t *testing.T, dir string) {
+				if os.Geteuid() != 0 {
+					t.Skip("changing the owner requires root")
+				}
+				require.NoError(t, os.Chown(dir, 4242, 4242))
+			},
+			expectErr: "is owned by UID 4242; expected root or the agent UID",
+		},
+		{
+			desc: "socket with unexpected mode",
+			setup: func(t *testing.T, dir string) {
+				require.NoError(t, os.Chmod(filepath.Join(dir, "spire-agent.sock"), 0700))
+			},
+			expectErr: "has mode 0700; expected 0777",
+		},
+		{
+			desc: "socket is a regular file",
+			setup: func(t *testing.T, dir string) {
+				socketPath := filepath.Join(dir, "spire-agent.sock")
+				require.NoError(t, os.Remove(socketPath))
+				require.NoError(t, os.WriteFile(socketPath, nil, 0777))
+				require.NoError(t, os.Chmod(socketPath, 0777))
+			},
+			expectErr: "is not a socket",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			// A unix socket path has to be short.
+			base, err := os.MkdirTemp("", "src")
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = os.RemoveAll(base) })
+			dir := filepath.Join(base, "agent")
+			require.NoError(t, os.Mkdir(dir, 0755))
+			l, err := net.Listen("unix", filepath.Join(dir, "spire-agent.sock"))
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = l.Close() })
+			require.NoError(t, os.Chmod(filepath.Join(dir, "spire-agent.sock"), 0777))
+
+			config := Config{
+				Log:                   logr.Discard(),
+				NodeID:                testNodeID,
+				WorkloadAPISocketDir:  dir,
+				WorkloadAPISocketName: "spire-agent.sock",
+				SourceCheck:           SourceCheckEnforce,
+				AgentUID:              os.Getuid(),
+				Mounter:               fake.New(),
+			}
+			if tt.anySocket {
+				config.WorkloadAPISocketName = ""
+			}
+			d, err := New(config)
+			require.NoError(t, err)
+
+			if tt.setup != nil {
+				tt.setup(t, dir)
+			}
+
+			_, err = New(config)
+			if tt.expectErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tt.expectErr)
+			}
+
+			_, err = d.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:   "volumeID",
+				TargetPath: filepath.Join(t.TempDir(), "target-path"),
+				Readonly:   true,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{},
+				},
+				VolumeContext: map[string]string{
+					"csi.storage.k8s.io/ephemeral": "true",
+				},
+			})
+			if tt.expectErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Equal(t, codes.FailedPrecondition, status.Code(err))
+			require.ErrorContains(t, err, tt.expectErr)
+
+			// Failures are only logged when not enforced.
+			config.SourceCheck = SourceCheckWarn
+			_, err = New(config)
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestSourceCheckLogging(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "agent")
+	require.NoError(t, os.Mkdir(dir, 0755))
+	var logged []string
+	d, err := New(Config{
+		Log: funcr.New(func(_, args string) {
+			logged = append(logged, args)
+		}, funcr.Options{}),
+		NodeID:               testNodeID,
+		WorkloadAPISocketDir: dir,
+		AgentUID:             os.Getuid(),
+		Mounter:              fake.New(),
+	})
+	require.NoError(t, err)
+	require.Empty(t, logged)
+
+	// A failure is logged once, however often it recurs, and again when it
+	// changes or goes away.
+	require.NoError(t, os.Chmod(dir, 0775))
+	for range 3 {
+		require.NoError(t, d.validateSource())
+	}
+	require.Len(t, logged, 1)
+	assert.Contains(t, logged[0], "failed validation")
+	assert.Contains(t, logged[0], "(mode 0775)")
+
+	require.NoError(t, os.Chmod(dir, 0777))
+	require.NoError(t, d.validateSource())
+	require.Len(t, logged, 2)
+	assert.Contains(t, logged[1], "(mode 0777)")
+
+	require.NoError(t, os.Chmod(dir, 0755))
+	for range 3 {
+		require.NoError(t, d.validateSource())
+	}
+	require.Len(t, logged, 3)
+	assert.Contains(t, logged[2], "passed validation")
+}
+
+func requireGRPCStatusPrefix(tb testing.TB, err error, code codes.Code, msgPrefix string, msgAndArgs ...interface{}) {
+	st := status.Convert(err)
+	if code != st.Code() || !strings.HasPrefix(st.Message(), msgPrefix) {
+		require.Fail(tb, fmt.Sprintf("Status code=%q msg=%q does not match code=%q with msg prefix %q", st.Code(), st.Message(), code, msgPrefix), msgAndArgs...)
+	}
+}
+
+func requireProtoEqual(tb testing.TB, expected, actual interface{}, msgAndArgs ...interface{}) {
+	// The CSI spec codegen uses the old proto package, which doesn't have
+	// good comparison support, so just render the structs as json and
+	// compare.
+	expectedJSON, err := json.Marshal(expected)
+	require.NoError(tb, err, "expected cannot be marshaled to JSON")
+	actualJSON, err := json.Marshal(actual)
+	require.NoError(tb, err, "actual cannot be marshaled to JSON")
+	require.JSONEq(tb, string(expectedJSON), string(actualJSON), msgAndArgs...)
+}
+
+type client struct {
+	csi.IdentityClient
+	csi.NodeClient
+}
+
+func startDriver(t *testing.T) (client, string) {
+	return startDriverWithConfig(t, Config{})
+}
+
+func startDriverWithConfig(t *testing.T, config Config) (client, string) {
+	workloadAPISocketDir := t.TempDir()
+
+	config.Log = logr.Discard()
+	config.NodeID = testNodeID
+	config.PluginName = "csi.spiffe.io"
+	config.WorkloadAPISocketDir = workloadAPISocketDir
+	if config.Mounter == nil {
+		config.Mounter = fake.New()
+	}
+
+	d, err := New(config)
+	require.NoError(t, err)
+
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	s := grpc.NewServer()
+	t.Cleanup(s.Stop)
+
+	csi.RegisterIdentityServer(s, d)
+	csi.RegisterNodeServer(s, d)
+
+	connCh := make(chan *grpc.ClientConn, 1)
+	errCh := make(chan error, 2)
+
+	go func() {
+		errCh <- s.Serve(l) // failures to serve will
+	}()
+	go func() {
+		conn, err := grpc.NewClient(l.Addr().String(),
+			grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			errCh <- err
+		} else {
+			connCh <- conn
+		}
+	}()
+
+	var conn *grpc.ClientConn
+	select {
+	case conn = <-connCh:
+		t.Cleanup(func() { _ = conn.Close() })
+	case err := <-errCh:
+		require.NoError(t, err)
+	}
+
+	return client{
+		IdentityClient: csi.NewIdentityClient(conn),
+		NodeClient:     csi.NewNodeClient(conn),
+	}, workloadAPISocketDir
+}
+
+func assertMounted(t *testing.T, m *fake.Mounter, targetPath, src string) {
+	mnt, ok := m.TopMount(targetPath)
+	if assert.True(t, ok, "should be mounted") {
+		assert.Equal(t, src, mnt.Source)
+	}
+}
+
+func assertNotMounted(t *testing.T, m *fake.Mounter, targetPath string) {
+	_, ok := m.TopMount(targetPath)
+	assert.False(t, ok, "should not be mounted")
+}
+
+func newPublishRequest(volumeID, targetPath string) *csi.NodePublishVolumeRequest {
+	return &csi.NodePublishVolumeRequest{
+		VolumeId:   volumeID,
+		TargetPath: targetPath,
+		Readonly:   true,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{},
+		},
+		VolumeContext: map[string]string{
+			"csi.storage.k8s.io/ephemeral": "true",
+		},
+	}
+}
+
+// blockingMounter blocks bind mounts onto mountPoint until unblock is
+// closed, signaling entered when the first one starts.
+type blockingMounter struct {
+	*fake.Mounter
+	mountPoint string
+	entered    chan struct{}
+	unblock    chan struct{}
+}
+
+func (m *blockingMounter) BindMountRW(root, mountPoint string) error {
+	if mountPoint == m.mountPoint {
+		close(m.entered)
+		<-m.unblock
+	}
+	return m.Mounter.BindMountRW(root, mountPoint)
+}
+
+// stackCheckingMounter records the mount points that ever had more than one
+// mount stacked on them. Checking for a mount point takes a little while, to
+// widen the window between checking and mounting.
+type stackCheckingMounter struct {
+	*fake.Mounter
+	mtx       sync.Mutex
+	seen      map[string]bool
+	stackedOn []string
+}
+
+func (m *stackCheckingMounter) IsMountPoint(mountPoint string) (bool, error) {
+	time.Sleep(100 * time.Microsecond)
+	return m.Mounter.IsMountPoint(mountPoint)
+}
+
+func (m *stackCheckingMounter) BindMountRW(root, mountPoint string) error {
+	return m.check(mountPoint, m.Mounter.BindMountRW(root, mountPoint))
+}
+
+func (m *stackCheckingMounter) BindMount(root, mountPoint string, opts mount.BindOptions) error {
+	return m.check(mountPoint, m.Mounter.BindMount(root, mountPoint, opts))
+}
+
+func (m *stackCheckingMounter) MountTmpfs(mountPoint, data string) error {
+	return m.check(mountPoint, m.Mounter.MountTmpfs(mountPoint, data))
+}
+
+func (m *stackCheckingMounter) check(mountPoint string, err error) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.seen == nil {
+		m.seen = make(map[string]bool)
+	}
+	m.seen[mountPoint] = true
+	if len(m.Mounts(mountPoint)) > 1 {
+		m.stackedOn = append(m.stackedOn, mountPoint)
+	}
+	return err
+}
+
+func (m *stackCheckingMounter) stacked() []string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.stackedOn
+}
+
+func (m *stackCheckingMounter) mountPoints() []string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	var mountPoints []string
+	for mountPoint := range m.seen {
+		mountPoints = append(mountPoints, mountPoint)
+	}
+	return mountPoints
 }
 
-func readMeta(targetPath string) (string, error) {
-	data, err := os.ReadFile(metaPath(targetPath))
-	return string(data), err
+// hangingMounter blocks bind mounts, if hangBind is set, and checks for
+// mount points, while hangIsMountPoint is set, until unblock is closed, like
+// a hung filesystem would.
+type hangingMounter struct {
+	*fake.Mounter
+	hangBind         bool
+	hangIsMountPoint atomic.Bool
+	// hangUnder, if set, limits hanging IsMountPoint calls to paths beneath it.
+	hangUnder string
+	hung      atomic.Int32
+	unblock   chan struct{}
 }
 
-func writeMeta(targetPath string, meta string) error {
-	return os.WriteFile(metaPath(targetPath), []byte(meta), 0600)
+func (m *hangingMounter) BindMountRW(root, mountPoint string) error {
+	if m.hangBind {
+		m.hang()
+	}
+	return m.Mounter.BindMountRW(root, mountPoint)
+}
+
+func (m *hangingMounter) IsMountPoint(mountPoint string) (bool, error) {
+	if m.hangIsMountPoint.Load() && (m.hangUnder == "" || strings.HasPrefix(mountPoint, m.hangUnder+string(filepath.Separator))) {
+		m.hang()
+	}
+	return m.Mounter.IsMountPoint(mountPoint)
 }
 
-func metaPath(targetPath string) string {
-	return filepath.Join(targetPath, "meta")
+func (m *hangingMounter) hang() {
+	m.hung.Add(1)
+	<-m.unblock
 }
 
 func dumpIt(t *testing.T, when, dir string) {
@@ -0,0 +1,77 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spiffe/spiffe-csi/pkg/mount"
+)
+
+// errMountNotPropagated is returned when a volume mount made by the driver
+// does not show up in the host mount namespace.
+var errMountNotPropagated = errors.New("mount did not propagate to the host mount namespace")
+
+// checkHostMount confirms that mountPoint is mounted in the mount namespace
+// of the configured host PID. The bind mount succeeding inside the driver
+// container says nothing about the kubelet and containers seeing it; without
+// bidirectional mount propagation on the kubelet pods directory they get an
+// empty directory instead.
+func (d *Driver) checkHostMount(mountPoint string) error {
+	if d.hostPID == 0 {
+		return nil
+	}
+	mounted, err := d.mounter.IsMountedIn(d.hostPID, mountPoint)
+	if err != nil {
+		return fmt.Errorf("unable to check mount point %q in the mount namespace of PID %d: %w", mountPoint, d.hostPID, err)
+	}
+	if !mounted {
+		return fmt.Errorf("%w: %q is not mounted in the mount namespace of PID %d; mount the kubelet pods directory into the driver container with mountPropagation: Bidirectional", errMountNotPropagated, mountPoint, d.hostPID)
+	}
+	return nil
+}
+
+// checkHostPID makes sure that the host PID is outside of the mount
+// namespace of the driver. Mounts are always found in the driver's own mount
+// namespace, so the check of checkHostMount would always pass.
+func checkHostPID(mounter mount.Mounter, hostPID int) error {
+	if shares, err := mounter.SharesMountNamespace(hostPID); err != nil {
+		return err
+	} else if shares {
+		return fmt.Errorf("PID %d is in the mount namespace of the driver; run the driver with hostPID: true", hostPID)
+	}
+	return nil
+}
+
+// SelfTest checks that mounts made by the driver propagate to the host
+// mount namespace, by bind mounting the Workload API socket directory onto a
+// temporary directory created in dir and looking for that mount from the
+// configured host PID. dir must be on the mount the kubelet pods directory
+// is exposed through, e.g. the pods directory itself. It is meant to run
+// once, before the driver starts serving.
+func (d *Driver) SelfTest(dir string) (err error) {
+	if d.hostPID == 0 {
+		return errors.New("self-test requires a host PID")
+	}
+
+	mountPoint, err := os.MkdirTemp(dir, mount.SelfTestPrefix)
+	if err != nil {
+		return fmt.Errorf("unable to create self-test mount point: %w", err)
+	}
+	defer func() {
+		if removeErr := os.Remove(mountPoint); removeErr != nil && err == nil {
+			err = fmt.Errorf("unable to remove self-test mount point %q: %w", mountPoint, removeErr)
+		}
+	}()
+
+	if err := d.mounter.BindMountRW(d.workloadAPISocketDir, mountPoint); err != nil {
+		return fmt.Errorf("unable to mount %q: %w", mountPoint, err)
+	}
+	defer func() {
+		if unmountErr := d.mounter.Unmount(mountPoint); unmountErr != nil && err == nil {
+			err = fmt.Errorf("unable to unmount %q: %w", mountPoint, unmountErr)
+		}
+	}()
+
+	return d.checkHostMount(mountPoint)
+}
@@ -0,0 +1,54 @@
+package driver
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+// inFlight tracks the volumes with an operation in progress. The kubelet may
+// send overlapping calls for the same volume, e.g. an unpublish while a slow
+// publish is still mounting; rather than interleaving their check-then-mount
+// sequences, the later call is refused and the kubelet retries it once the
+// earlier one is done, as the CSI spec intends with the ABORTED code.
+type inFlight struct {
+	mtx  sync.Mutex
+	keys map[inFlightKey]struct{}
+}
+
+// inFlightKey identifies a volume by either its ID or its path, since calls
+// for the same volume may only agree on one of them.
+type inFlightKey struct {
+	volumeID string
+	path     string
+}
+
+// tryAcquire marks the volume with the given ID and target or volume path
+// as busy. It returns false if an operation on the volume is already in
+// progress, and a function to call once the operation is done otherwise.
+func (f *inFlight) tryAcquire(volumeID, path string) (release func(), ok bool) {
+	keys := []inFlightKey{{path: filepath.Clean(path)}}
+	if volumeID != "" {
+		keys = append(keys, inFlightKey{volumeID: volumeID})
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	for _, key := range keys {
+		if _, busy := f.keys[key]; busy {
+			return nil, false
+		}
+	}
+	if f.keys == nil {
+		f.keys = make(map[inFlightKey]struct{})
+	}
+	for _, key := range keys {
+		f.keys[key] = struct{}{}
+	}
+	return func() {
+		f.mtx.Lock()
+		defer f.mtx.Unlock()
+		for _, key := range keys {
+			delete(f.keys, key)
+		}
+	}, true
+}
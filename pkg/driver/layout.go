@@ -0,0 +1,573 @@
+package driver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+
+	"github.com/spiffe/spiffe-csi/pkg/logkeys"
+	"github.com/spiffe/spiffe-csi/pkg/mount"
+)
+
+// VolumeLayout describes how the Workload API socket is presented inside a
+// published volume.
+type VolumeLayout string
+
+const (
+	// DirectoryLayout bind mounts the Workload API socket directory directly
+	// onto the target path. This is the default.
+	DirectoryLayout VolumeLayout = "directory"
+
+	// SocketLayout bind mounts only the Workload API socket onto a file
+	// inside the target path directory. Nothing else the agent keeps in the
+	// socket directory (e.g. admin sockets) is exposed.
+	SocketLayout VolumeLayout = "socket"
+
+	// CompositeLayout mounts a small per-volume tmpfs onto the target path.
+	// The tmpfs holds a bind mount of the Workload API socket directory (or
+	// just the socket) alongside generated files describing the volume.
+	CompositeLayout VolumeLayout = "composite"
+)
+
+const (
+	// CompositeTmpfsOptions are the options used to mount the per-volume
+	// tmpfs for the composite layout. The generated files are tiny.
+	CompositeTmpfsOptions = "size=1m,mode=0755"
+
+	// compositeSocketDirName is the name of the directory inside the
+	// composite volume that the Workload API socket directory is bind mounted
+	// onto.
+	compositeSocketDirName = "workload-api"
+
+	// compositeEnvFileName is the name of the generated file containing
+	// environment variables for the workload.
+	compositeEnvFileName = "spiffe.env"
+
+	// compositePodInfoFileName is the name of the generated file containing
+	// information about the pod the volume was published for.
+	compositePodInfoFileName = "pod.json"
+
+	// compositeTrustDomainFileName is the name of the generated file
+	// containing the trust domain name.
+	compositeTrustDomainFileName = "trust-domain"
+
+	// defaultContainerMountPath is the path the volume is assumed to be
+	// mounted at in the workload containers when the containerMountPath
+	// volume attribute is not set.
+	defaultContainerMountPath = "/spiffe-workload-api"
+)
+
+// Volume context keys populated by the kubelet when podInfoOnMount is
+// enabled on the CSIDriver object, along with the attributes that can be set
+// by the pod author via pod.spec.volumes[].csi.volumeAttributes.
+const (
+	volumeContextEphemeral          = "csi.storage.k8s.io/ephemeral"
+	volumeContextPodName            = "csi.storage.k8s.io/pod.name"
+	volumeContextPodNamespace       = "csi.storage.k8s.io/pod.namespace"
+	volumeContextPodUID             = "csi.storage.k8s.io/pod.uid"
+	volumeContextServiceAccountName = "csi.storage.k8s.io/serviceAccount.name"
+	volumeContextContainerMountPath = "containerMountPath"
+)
+
+// compositePodInfo is the content of the generated pod information file.
+type compositePodInfo struct {
+	PodName            string `json:"podName"`
+	PodNamespace       string `json:"podNamespace"`
+	ServiceAccountName string `json:"serviceAccountName"`
+	NodeID             string `json:"nodeID"`
+}
+
+// socketMountName returns the name the Workload API socket is exposed under
+// when only the socket is bind mounted.
+func (d *Driver) socketMountName() string {
+	if d.socketMountNameOverride != "" {
+		return d.socketMountNameOverride
+	}
+	return d.workloadAPISocketName
+}
+
+// socketSource returns the path of the Workload API socket.
+func (d *Driver) socketSource() string {
+	return filepath.Join(d.workloadAPISocketDir, d.workloadAPISocketName)
+}
+
+// publishedMountPath returns the path that is a mount point once a volume
+// has been published on the target path. For the socket layout, the target
+// path itself is a plain directory and only the socket file is mounted.
+func (d *Driver) publishedMountPath(targetPath string) string {
+	if d.volumeLayout == SocketLayout {
+		return filepath.Join(targetPath, d.socketMountName())
+	}
+	return targetPath
+}
+
+// innerMountPath returns the path inside the composite volume that is bind
+// mounted from the Workload API socket directory, or from the socket itself
+// when only the socket is exposed.
+func (d *Driver) innerMountPath(targetPath string) string {
+	if d.compositeSocketOnly {
+		return filepath.Join(targetPath, d.socketMountName())
+	}
+	return filepath.Join(targetPath, compositeSocketDirName)
+}
+
+// compositeSocketRelPath returns the path of the Workload API socket relative
+// to the root of the composite volume.
+func (d *Driver) compositeSocketRelPath() string {
+	if d.compositeSocketOnly {
+		return d.socketMountName()
+	}
+	return path.Join(compositeSocketDirName, d.workloadAPISocketName)
+}
+
+// publish mounts the volume onto the target path according to the
+// configured layout. The target path must already exist and not be mounted.
+func (d *Driver) publish(targetPath string, volumeContext map[string]string, opts volumeMountOptions) error {
+	if d.seLinuxRelabel && opts.seLinuxLabel != "" {
+		if err := d.relabelWorkloadAPI(opts.seLinuxLabel); err != nil {
+			return err
+		}
+	}
+	switch d.volumeLayout {
+	case SocketLayout:
+		return d.publishSocket(targetPath, opts)
+	case CompositeLayout:
+		return d.publishComposite(targetPath, volumeContext, opts)
+	default:
+		return d.bindWorkloadAPI(d.workloadAPISocketDir, targetPath, opts)
+	}
+}
+
+// publishSocket bind mounts the Workload API socket onto a file inside the
+// target path directory.
+func (d *Driver) publishSocket(targetPath string, opts volumeMountOptions) error {
+	socketMountPath := filepath.Join(targetPath, d.socketMountName())
+	if err := d.bindSocket(d.socketSource(), socketMountPath, opts); err != nil {
+		if removeErr := d.removeSocketMountPoint(socketMountPath); removeErr != nil {
+			d.log.Error(removeErr, "Failed to clean up socket mount point")
+		}
+		return err
+	}
+	return nil
+}
+
+// publishComposite populates a composite volume on the target path.
+func (d *Driver) publishComposite(targetPath string, volumeContext map[string]string, opts volumeMountOptions) (err error) {
+	tmpfsOptions := CompositeTmpfsOptions
+	if opts.seLinuxContext != "" {
+		tmpfsOptions += "," + opts.seLinuxContext
+	}
+	if err := d.mounter.MountTmpfs(targetPath, tmpfsOptions); err != nil {
+		return fmt.Errorf("unable to mount tmpfs: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			// The tmpfs was just mounted here, so it is ours even before
+			// the inner mount it is otherwise recognized by is made.
+			if cleanupErr := d.unmountLayers(targetPath, true); cleanupErr != nil {
+				d.log.Error(cleanupErr, "Failed to clean up partially published composite volume")
+			}
+		}
+	}()
+
+	if opts.seLinuxContext != "" {
+		if err := d.mounter.VerifySuperOptions(targetPath, []string{opts.seLinuxContext}); err != nil {
+			return fmt.Errorf("unable to verify tmpfs SELinux context: %w", err)
+		}
+	}
+	innerMountPath := d.innerMountPath(targetPath)
+	if d.compositeSocketOnly {
+		if err := d.bindSocket(d.socketSource(), innerMountPath, opts); err != nil {
+			return err
+		}
+	} else {
+		if err := d.mkdirInVolume(innerMountPath, 0755); err != nil {
+			return fmt.Errorf("unable to create socket directory mount point: %w", err)
+		}
+		if err := d.bindWorkloadAPI(d.workloadAPISocketDir, innerMountPath, opts); err != nil {
+			return fmt.Errorf("unable to bind mount workload API socket directory: %w", err)
+		}
+	}
+	// The propagation of the tmpfs is only changed once the inner bind mount
+	// has propagated to the peers of the tmpfs, i.e. to the kubelet. Made
+	// private or a slave beforehand, the tmpfs would leave the peer group
+	// and the inner bind mount would never show up on the kubelet side.
+	if err := d.applyPropagation(targetPath); err != nil {
+		return err
+	}
+
+	containerMountPath := volumeContext[volumeContextContainerMountPath]
+	if containerMountPath == "" {
+		containerMountPath = defaultContainerMountPath
+	}
+	endpointSocket := "unix://" + path.Join(containerMountPath, d.compositeSocketRelPath())
+	env := fmt.Sprintf("SPIFFE_ENDPOINT_SOCKET=%s\n", endpointSocket)
+	if err := d.writeVolumeFile(filepath.Join(targetPath, compositeEnvFileName), []byte(env), 0644); err != nil {
+		return fmt.Errorf("unable to write env file: %w", err)
+	}
+
+	podInfo, err := json.MarshalIndent(compositePodInfo{
+		PodName:            volumeContext[volumeContextPodName],
+		PodNamespace:       volumeContext[volumeContextPodNamespace],
+		ServiceAccountName: volumeContext[volumeContextServiceAccountName],
+		NodeID:             d.nodeID,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to marshal pod info: %w", err)
+	}
+	if err := d.writeVolumeFile(filepath.Join(targetPath, compositePodInfoFileName), append(podInfo, '\n'), 0644); err != nil {
+		return fmt.Errorf("unable to write pod info file: %w", err)
+	}
+
+	if d.trustDomain != "" {
+		if err := d.writeVolumeFile(filepath.Join(targetPath, compositeTrustDomainFileName), []byte(d.trustDomain+"\n"), 0644); err != nil {
+			return fmt.Errorf("unable to write trust domain file: %w", err)
+		}
+	}
+	return nil
+}
+
+// bindWorkloadAPI bind mounts the Workload API socket directory, or the
+// socket itself, onto dst. If any attributes are requested, they are applied
+// to the bind mount and verified against the mount information afterwards.
+// The bind mount is undone if the attributes did not stick. If the pod runs in
+// a user namespace, the bind mount is ID-mapped with the mappings of the pod,
+// falling back to a plain bind mount where ID-mapped mounts are unsupported
+// unless they were explicitly requested.
+func (d *Driver) bindWorkloadAPI(src, dst string, opts volumeMountOptions) error {
+	idmapped := false
+	switch {
+	case opts.idmap != nil:
+		err := d.mounter.BindMountIDMapped(src, dst, opts.bind, *opts.idmap)
+		switch {
+		case err == nil:
+			idmapped = true
+		case errors.Is(err, mount.ErrIDMapUnsupported) && !opts.idmapRequired:
+			d.log.Error(err, "Unable to ID-map the workload API mount; falling back to a plain bind mount", logkeys.VolumePath, dst)
+			return d.bindWorkloadAPI(src, dst, volumeMountOptions{bind: opts.bind})
+		default:
+			return err
+		}
+	case opts.bind == (mount.BindOptions{}):
+		if err := d.mounter.BindMountRW(src, dst); err != nil {
+			return err
+		}
+	default:
+		if err := d.mounter.BindMount(src, dst, opts.bind); err != nil {
+			return err
+		}
+	}
+
+	if opts.bind != (mount.BindOptions{}) {
+		if err := d.mounter.VerifyBindOptions(dst, opts.bind); err != nil {
+			d.undoBind(dst)
+			return fmt.Errorf("unable to verify bind mount attributes: %w", err)
+		}
+	}
+	if idmapped {
+		if ok, err := d.mounter.IsIDMapped(dst); err != nil {
+			d.undoBind(dst)
+			return fmt.Errorf("unable to verify bind mount is ID-mapped: %w", err)
+		} else if !ok {
+			d.undoBind(dst)
+			return errors.New("bind mount is not ID-mapped")
+		}
+	}
+	if err := d.applyPropagation(dst); err != nil {
+		d.undoBind(dst)
+		return err
+	}
+	return nil
+}
+
+// applyPropagation sets the configured propagation type on the new mount at
+// path, so that mounts appearing below it, or below its peers, do not
+// propagate between the host and pods, and verifies it.
+func (d *Driver) applyPropagation(path string) error {
+	if d.propagation == mount.PropagationUnchanged {
+		return nil
+	}
+	if err := d.mounter.SetPropagation(path, d.propagation); err != nil {
+		return fmt.Errorf("unable to set mount propagation: %w", err)
+	}
+	if err := d.mounter.VerifyPropagation(path, d.propagation); err != nil {
+		return fmt.Errorf("unable to verify mount propagation: %w", err)
+	}
+	return nil
+}
+
+// undoBind unmounts a bind mount that did not come out as requested.
+func (d *Driver) undoBind(dst string) {
+	if err := d.mounter.Unmount(dst); err != nil {
+		d.log.Error(err, "Failed to unmount bind mount with missing attributes")
+	}
+}
+
+// bindSocket creates an empty file at socketMountPath, if not already
+// present, and bind mounts the socket onto it.
+func (d *Driver) bindSocket(socketPath, socketMountPath string, opts volumeMountOptions) error {
+	if err := d.createSocketMountPoint(socketMountPath); err != nil {
+		return fmt.Errorf("unable to create socket mount point: %w", err)
+	}
+	if err := d.bindWorkloadAPI(socketPath, socketMountPath, opts); err != nil {
+		return fmt.Errorf("unable to bind mount workload API socket: %w", err)
+	}
+	return nil
+}
+
+// removeSocketMountPoint removes the file created to bind mount the socket
+// onto. Only regular files are removed. This guards against removing the
+// agent socket itself should the mount point be inspected while something
+// unexpected is mounted on the target path. The file is removed like the
+// target path in removeTarget.
+func (d *Driver) removeSocketMountPoint(socketMountPath string) error {
+	info, err := os.Lstat(socketMountPath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil
+	case err != nil:
+		return err
+	case !info.Mode().IsRegular():
+		return nil
+	}
+	return d.removeTarget(socketMountPath)
+}
+
+// innerMountCandidates returns the paths inside the target path that may
+// hold a bind mount made by one of the layouts. All of them are considered
+// regardless of the configured layout so that volumes published before a
+// configuration change are still cleaned up.
+func (d *Driver) innerMountCandidates(targetPath string) []string {
+	var candidates []string
+	for _, name := range InnerMountNames(d.workloadAPISocketName, d.socketMountNameOverride) {
+		candidates = append(candidates, filepath.Join(targetPath, name))
+	}
+	return candidates
+}
+
+// InnerMountNames returns the names of the paths inside a target path that
+// the layouts may bind mount onto, for the given Workload API socket name
+// and socket mount name (see Config). The composite layout socket directory
+// comes first.
+func InnerMountNames(workloadAPISocketName, socketMountName string) []string {
+	var names []string
+	for _, name := range []string{compositeSocketDirName, workloadAPISocketName, socketMountName} {
+		if name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// unmountVolume unmounts everything the layouts may have mounted on and
+// inside the target path. With the composite layout, a tmpfs on the target
+// path is only unmounted if it is the tmpfs of a composite volume, which is
+// told apart from other tmpfs mounts by the bind mount inside it, so that is
+// checked before the inner mounts are unmounted.
+func (d *Driver) unmountVolume(targetPath string) error {
+	tmpfsAllowed := false
+	if d.volumeLayout == CompositeLayout {
+		var err error
+		if tmpfsAllowed, err = d.isOwnComposite(targetPath); err != nil {
+			return fmt.Errorf("unable to verify mount point %q: %w", targetPath, err)
+		}
+	}
+	return d.unmountLayers(targetPath, tmpfsAllowed)
+}
+
+// unmountLayers does the work of unmountVolume. Mounts inside the target
+// path have to be unmounted first; a composite volume tmpfs cannot be
+// unmounted while the inner bind mount is still in place. A tmpfs on the
+// target path is only unmounted if tmpfsAllowed.
+func (d *Driver) unmountLayers(targetPath string, tmpfsAllowed bool) error {
+	candidates := d.innerMountCandidates(targetPath)
+	for _, candidate := range candidates {
+		if err := d.unmountOwn(candidate, false); err != nil {
+			return err
+		}
+	}
+	if err := d.unmountOwn(targetPath, tmpfsAllowed); err != nil {
+		return err
+	}
+
+	// The socket layout leaves the file the socket was mounted onto behind
+	// in the target path directory.
+	for _, candidate := range candidates[1:] {
+		if err := d.removeSocketMountPoint(candidate); err != nil {
+			return fmt.Errorf("unable to remove socket mount point %q: %w", candidate, err)
+		}
+	}
+	return nil
+}
+
+// maxStackedMounts bounds how many mounts are unmounted from a single path.
+// Kubelet restarts used to stack identical bind mounts on a target path, all
+// of which have to go before the target path can be removed.
+const maxStackedMounts = 32
+
+// unmountOwn unmounts everything mounted on path. Mounts not made by this
+// driver are left in place and reported with errForeignMount.
+func (d *Driver) unmountOwn(path string, tmpfsAllowed bool) error {
+	for range maxStackedMounts {
+		if ok, err := d.mounter.IsMountPoint(path); err != nil {
+			return fmt.Errorf("unable to verify mount point %q: %w", path, err)
+		} else if !ok {
+			return nil
+		}
+		if own, err := d.isOwnMount(path, tmpfsAllowed); err != nil {
+			return fmt.Errorf("unable to verify mount point %q: %w", path, err)
+		} else if !own {
+			return fmt.Errorf("%w: refusing to unmount %q", errForeignMount, path)
+		}
+		result, err := d.mounter.UnmountWithOptions(path, d.unmountOptions)
+		if err != nil {
+			return fmt.Errorf("unable to unmount %q: %w", path, err)
+		}
+		if result.Attempts > 1 || result.Detached || result.Corrupted {
+			d.log.Info("Unmounted with difficulty",
+				logkeys.VolumePath, path,
+				logkeys.Attempts, result.Attempts,
+				logkeys.Detached, result.Detached,
+				logkeys.Corrupted, result.Corrupted,
+			)
+		}
+	}
+	return fmt.Errorf("unable to unmount %q: still mounted after %d unmounts", path, maxStackedMounts)
+}
+
+// errForeignMount is returned when a mount the driver would act on was not
+// made by it.
+var errForeignMount = errors.New("mount was not made by this driver")
+
+// isOwnMount returns whether the topmost mount on path is one the volume
+// layouts make: a bind mount of the Workload API socket directory or socket
+// or, if tmpfsAllowed, a tmpfs mounted with the options of a composite
+// volume.
+func (d *Driver) isOwnMount(path string, tmpfsAllowed bool) (bool, error) {
+	sources := []string{d.workloadAPISocketDir}
+	if d.workloadAPISocketName != "" {
+		sources = append(sources, d.socketSource())
+	}
+	for _, source := range sources {
+		if ok, err := d.mounter.IsBindMountOf(path, source); err != nil {
+			return false, err
+		} else if ok {
+			return true, nil
+		}
+	}
+	if !tmpfsAllowed {
+		return false, nil
+	}
+	info, ok, err := d.mounter.GetMount(path)
+	if err != nil {
+		return false, err
+	}
+	return ok && isCompositeTmpfs(info), nil
+}
+
+// isPublished returns whether the topmost mount on the published mount path
+// of a volume is the one the configured layout makes.
+func (d *Driver) isPublished(publishedMountPath string) (bool, error) {
+	if d.volumeLayout == CompositeLayout {
+		return d.isOwnComposite(publishedMountPath)
+	}
+	return d.isOwnMount(publishedMountPath, false)
+}
+
+// isOwnComposite returns whether the topmost mount on path is the tmpfs of
+// a composite volume: a tmpfs mounted with the options of a composite volume
+// that has a bind mount of the Workload API socket directory or socket
+// inside it.
+func (d *Driver) isOwnComposite(path string) (bool, error) {
+	info, ok, err := d.mounter.GetMount(path)
+	if err != nil || !ok || !isCompositeTmpfs(info) {
+		return false, err
+	}
+	for _, candidate := range d.innerMountCandidates(path) {
+		if ok, err := d.mounter.IsMountPoint(candidate); err != nil {
+			return false, err
+		} else if !ok {
+			continue
+		}
+		if own, err := d.isOwnMount(candidate, false); err != nil || own {
+			return own, err
+		}
+	}
+	return false, nil
+}
+
+// isCompositeTmpfs returns whether info is of a tmpfs mounted with
+// CompositeTmpfsOptions.
+func isCompositeTmpfs(info mount.MountInfo) bool {
+	return mount.IsTmpfsWithOptions(info, CompositeTmpfsOptions)
+}
+
+// checkSocketMount verifies that the socket bind mounted at socketMountPath,
+// inside the volume at volumePath, is still the Workload API socket. The bind
+// mount pins the socket inode, so if the agent re-creates its socket (e.g. on
+// restart), the mount goes stale. In that case, the socket is bind mounted
+// again.
+func (d *Driver) checkSocketMount(volumePath, socketMountPath string) error {
+	if ok, err := d.mounter.IsMountPoint(socketMountPath); err != nil {
+		return fmt.Errorf("failed to determine root for workload API socket mount: %w", err)
+	} else if !ok {
+		return errors.New("workload API socket is not mounted in the volume")
+	}
+
+	same, err := d.mounter.SameFile(d.socketSource(), socketMountPath)
+	switch {
+	case err != nil:
+		return fmt.Errorf("unable to compare workload API socket mount with socket: %w", err)
+	case same:
+		return nil
+	}
+
+	// Mount the socket again with the same attributes the stale mount was
+	// published with.
+	d.log.Info("Workload API socket was re-created; mounting it again", logkeys.SocketMountPath, socketMountPath)
+	bindOpts, err := d.mounter.ReadBindOptions(socketMountPath)
+	if err != nil {
+		return fmt.Errorf("unable to read attributes of stale workload API socket mount: %w", err)
+	}
+	opts := volumeMountOptions{bind: mergeBindOptions(bindOpts, d.bindOptions)}
+	if idmapped, err := d.mounter.IsIDMapped(socketMountPath); err != nil {
+		return fmt.Errorf("unable to read attributes of stale workload API socket mount: %w", err)
+	} else if idmapped {
+		// The mappings are not part of the mount information; look them
+		// up again.
+		opts.idmap, err = podIDMap(volumePath)
+		if err != nil {
+			return err
+		}
+		opts.idmapRequired = true
+	}
+	if err := d.mounter.Unmount(socketMountPath); err != nil {
+		return fmt.Errorf("unable to unmount stale workload API socket: %w", err)
+	}
+	if err := d.bindWorkloadAPI(d.socketSource(), socketMountPath, opts); err != nil {
+		return fmt.Errorf("unable to mount re-created workload API socket: %w", err)
+	}
+	return nil
+}
+
+// checkLayout verifies the mounts specific to the configured layout.
+func (d *Driver) checkLayout(volumePath string) error {
+	switch {
+	case d.volumeLayout == SocketLayout:
+		return d.checkSocketMount(volumePath, d.publishedMountPath(volumePath))
+	case d.volumeLayout == CompositeLayout && d.compositeSocketOnly:
+		return d.checkSocketMount(volumePath, d.innerMountPath(volumePath))
+	case d.volumeLayout == CompositeLayout:
+		innerMountPath := d.innerMountPath(volumePath)
+		if ok, err := d.mounter.IsMountPoint(innerMountPath); err != nil {
+			return fmt.Errorf("failed to determine root for workload API socket directory mount: %w", err)
+		} else if !ok {
+			return errors.New("workload API socket directory is not mounted in the volume")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package driver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spiffe/spiffe-csi/pkg/mount"
+)
+
+// volumeMountOptions are the per-volume mount options requested through the
+// mount flags of the volume capability.
+type volumeMountOptions struct {
+	// bind are the attributes applied to the bind mounts of the Workload
+	// API socket (directory).
+	bind mount.BindOptions
+
+	// seLinuxContext is the SELinux "context=..." mount option, if any.
+	// The context is a superblock option. Bind mounts share the superblock
+	// of their source so it is only applied to the per-volume tmpfs of the
+	// composite layout. The bind mounts present the label of the source,
+	// which is relabeled instead if the driver is configured to.
+	seLinuxContext string
+
+	// seLinuxLabel is the label carried by seLinuxContext.
+	seLinuxLabel string
+
+	// idmap, if set, holds the user namespace mappings of the pod, which
+	// the bind mounts are ID-mapped with.
+	idmap *mount.IDMap
+
+	// idmapRequired is set if the ID-mapped mount was explicitly requested
+	// and falling back to a plain bind mount is not acceptable.
+	idmapRequired bool
+}
+
+// parseMountFlags validates the mount flags of a volume capability against
+// the allowlist and returns the resulting mount options. Each flag may itself
+// be a comma-separated list of options.
+func (d *Driver) parseMountFlags(flags []string) (volumeMountOptions, error) {
+	var opts volumeMountOptions
+	for _, flag := range flags {
+		for _, option := range mount.SplitOptions(flag) {
+			switch {
+			case option == "":
+			case option == "nosuid":
+				opts.bind.NoSuid = true
+			case option == "nodev":
+				opts.bind.NoDev = true
+			case option == "noexec":
+				opts.bind.NoExec = true
+			case strings.HasPrefix(option, "context="):
+				label, err := mount.ParseSELinuxContextOption(option)
+				if err != nil {
+					return volumeMountOptions{}, fmt.Errorf("request volume capability mount flag %q is invalid: %w", option, err)
+				}
+				if opts.seLinuxLabel != "" && opts.seLinuxLabel != label {
+					return volumeMountOptions{}, fmt.Errorf("request volume capability mount flags contain conflicting SELinux labels %q and %q", opts.seLinuxLabel, label)
+				}
+				opts.seLinuxContext = option
+				opts.seLinuxLabel = label
+			default:
+				return volumeMountOptions{}, fmt.Errorf("request volume capability mount flag %q is not allowed", option)
+			}
+		}
+	}
+
+	// A bind mount presents the label of its source, which is left as is
+	// unless relabeling is enabled. Accepting the context would have the
+	// kubelet believe the volume carries the label of the pod.
+	if opts.seLinuxContext != "" && d.volumeLayout != CompositeLayout && !d.seLinuxRelabel {
+		return volumeMountOptions{}, fmt.Errorf("request volume capability mount flag %q cannot be applied with the %s volume layout unless SELinux relabeling is enabled", opts.seLinuxContext, d.volumeLayout)
+	}
+
+	// The options configured on the driver always apply.
+	opts.bind = mergeBindOptions(opts.bind, d.bindOptions)
+	return opts, nil
+}
+
+func mergeBindOptions(a, b mount.BindOptions) mount.BindOptions {
+	return mount.BindOptions{
+		ReadOnly: a.ReadOnly || b.ReadOnly,
+		NoSuid:   a.NoSuid || b.NoSuid,
+		NoDev:    a.NoDev || b.NoDev,
+		NoExec:   a.NoExec || b.NoExec,
+	}
+}
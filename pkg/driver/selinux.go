@@ -0,0 +1,71 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spiffe/spiffe-csi/pkg/logkeys"
+	"github.com/spiffe/spiffe-csi/pkg/mount"
+)
+
+// errSELinuxLabelConflict is returned when a volume asks for the Workload
+// API socket to carry a label other than the one it was relabeled to for
+// earlier volumes.
+var errSELinuxLabelConflict = errors.New("conflicting SELinux label")
+
+// relabelWorkloadAPI relabels the Workload API socket directory and the
+// socket(s) in it so that they are accessible to a pod running with the given
+// label. The MCS categories of the label are dropped since the socket is
+// shared by every pod on the node. Files that already carry the label are
+// left alone, and the label is read back afterwards to make sure it stuck.
+//
+// The socket can only carry one label, so once relabeled, volumes asking for
+// a different label (e.g. of another SELinux type) are refused rather than
+// taking the socket away from the pods already using it.
+func (d *Driver) relabelWorkloadAPI(podLabel string) error {
+	label := mount.SharedSELinuxLabel(podLabel)
+
+	d.seLinuxMtx.Lock()
+	defer d.seLinuxMtx.Unlock()
+	if d.seLinuxLabel != "" && d.seLinuxLabel != label {
+		return fmt.Errorf("%w: the workload API socket is labeled %q for other volumes; refusing to relabel it to %q", errSELinuxLabelConflict, d.seLinuxLabel, label)
+	}
+
+	paths := []string{d.workloadAPISocketDir}
+	if d.workloadAPISocketName != "" {
+		paths = append(paths, d.socketSource())
+	} else {
+		entries, err := os.ReadDir(d.workloadAPISocketDir)
+		if err != nil {
+			return fmt.Errorf("unable to list workload API socket directory: %w", err)
+		}
+		for _, entry := range entries {
+			if entry.Type()&os.ModeSocket != 0 {
+				paths = append(paths, filepath.Join(d.workloadAPISocketDir, entry.Name()))
+			}
+		}
+	}
+
+	for _, path := range paths {
+		current, err := d.mounter.GetSELinuxLabel(path)
+		if err != nil {
+			return fmt.Errorf("unable to read SELinux label of %q: %w", path, err)
+		}
+		if current == label {
+			continue
+		}
+		d.log.Info("Relabeling workload API socket", logkeys.VolumePath, path, logkeys.SELinuxLabel, label)
+		if err := d.mounter.SetSELinuxLabel(path, label); err != nil {
+			return fmt.Errorf("unable to relabel %q: %w", path, err)
+		}
+		if current, err := d.mounter.GetSELinuxLabel(path); err != nil {
+			return fmt.Errorf("unable to read SELinux label of %q: %w", path, err)
+		} else if current != label {
+			return fmt.Errorf("SELinux label of %q is %q after relabeling to %q", path, current, label)
+		}
+	}
+	d.seLinuxLabel = label
+	return nil
+}
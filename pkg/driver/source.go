@@ -0,0 +1,123 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// SourceCheckMode controls what happens when the Workload API socket
+// directory or socket fails validation (see checkSource).
+type SourceCheckMode string
+
+const (
+	// SourceCheckWarn logs validation failures and carries on.
+	SourceCheckWarn SourceCheckMode = "warn"
+
+	// SourceCheckEnforce fails driver creation and publishing on
+	// validation failures.
+	SourceCheckEnforce SourceCheckMode = "enforce"
+)
+
+// DefaultWorkloadAPISocketMode is the permission bits the Workload API
+// socket is expected to have. Every pod has to be able to connect to it.
+const DefaultWorkloadAPISocketMode fs.FileMode = 0777
+
+// checkSource validates the Workload API socket directory before it is
+// exposed to pods. The directory has to be a real directory, not a symlink,
+// owned by root or the agent UID and not writable by group or others, so
+// that nobody else can plant a rogue Workload API socket in it. The socket,
+// if it exists yet, has to be a socket with the same owners and the
+// expected mode. A missing socket is accepted since the agent may not have
+// created it yet; the checks on the directory keep anyone else from doing
+// so. Without a socket name, the whole directory is exposed, so every
+// socket in it is checked instead.
+func (d *Driver) checkSource() error {
+	info, err := os.Lstat(d.workloadAPISocketDir)
+	if err != nil {
+		return fmt.Errorf("unable to stat workload API socket directory: %w", err)
+	}
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		return fmt.Errorf("workload API socket directory %q is a symlink", d.workloadAPISocketDir)
+	case !info.IsDir():
+		return fmt.Errorf("workload API socket directory %q is not a directory", d.workloadAPISocketDir)
+	case info.Mode().Perm()&0022 != 0:
+		return fmt.Errorf("workload API socket directory %q is writable by group or others (mode %#o)", d.workloadAPISocketDir, info.Mode().Perm())
+	}
+	if err := d.checkSourceOwner(d.workloadAPISocketDir, info); err != nil {
+		return err
+	}
+
+	if d.workloadAPISocketName != "" {
+		return d.checkSourceSocket(d.socketSource())
+	}
+	entries, err := os.ReadDir(d.workloadAPISocketDir)
+	if err != nil {
+		return fmt.Errorf("unable to read workload API socket directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.Type()&fs.ModeSocket == 0 {
+			continue
+		}
+		if err := d.checkSourceSocket(filepath.Join(d.workloadAPISocketDir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSourceSocket checks the socket at socketPath, if it exists, as
+// described in checkSource.
+func (d *Driver) checkSourceSocket(socketPath string) error {
+	info, err := os.Lstat(socketPath)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil
+	case err != nil:
+		return fmt.Errorf("unable to stat workload API socket: %w", err)
+	case info.Mode().Type() != fs.ModeSocket:
+		return fmt.Errorf("workload API socket %q is not a socket", socketPath)
+	case info.Mode().Perm() != d.workloadAPISocketMode:
+		return fmt.Errorf("workload API socket %q has mode %#o; expected %#o", socketPath, info.Mode().Perm(), d.workloadAPISocketMode)
+	}
+	return d.checkSourceOwner(socketPath, info)
+}
+
+// checkSourceOwner checks that path, described by info, is owned by root or
+// the agent UID.
+func (d *Driver) checkSourceOwner(path string, info fs.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("unable to determine the owner of %q", path)
+	}
+	if stat.Uid != 0 && int64(stat.Uid) != int64(d.agentUID) {
+		return fmt.Errorf("%q is owned by UID %d; expected root or the agent UID %d", path, stat.Uid, d.agentUID)
+	}
+	return nil
+}
+
+// validateSource runs checkSource and, depending on the source check mode,
+// returns or logs the failure. Since it runs on every publish, a failure is
+// only logged when it differs from the last one, and so is the recovery.
+func (d *Driver) validateSource() error {
+	err := d.checkSource()
+	if d.sourceCheckMode == SourceCheckEnforce {
+		return err
+	}
+
+	d.sourceMtx.Lock()
+	defer d.sourceMtx.Unlock()
+	switch {
+	case err == nil && d.sourceErr != "":
+		d.log.Info("Workload API socket source passed validation")
+		d.sourceErr = ""
+	case err != nil && err.Error() != d.sourceErr:
+		d.log.Error(err, "Workload API socket source failed validation")
+		d.sourceErr = err.Error()
+	}
+	return nil
+}
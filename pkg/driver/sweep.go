@@ -0,0 +1,58 @@
+package driver
+
+import (
+	"fmt"
+
+	"github.com/spiffe/spiffe-csi/pkg/logkeys"
+)
+
+// CollapseStackedMounts unmounts duplicate mounts of the Workload API socket
+// directory, or socket, stacked on the same mount point, leaving a single
+// layer on each. Kubelet restarts used to stack such duplicates on volume
+// target paths. It is meant to run once, before the driver starts serving,
+// and returns the number of mounts removed. Collapsing stops at the first
+// layer not made by this driver.
+func (d *Driver) CollapseStackedMounts() (int, error) {
+	sources := []string{d.workloadAPISocketDir}
+	if d.workloadAPISocketName != "" {
+		sources = append(sources, d.socketSource())
+	}
+
+	removed := 0
+	for _, source := range sources {
+		infos, err := d.mounter.BindMountsOf(source)
+		if err != nil {
+			return removed, fmt.Errorf("unable to list mounts of %q: %w", source, err)
+		}
+
+		var mountPoints []string
+		layers := make(map[string]int)
+		for _, info := range infos {
+			if layers[info.MountPoint] == 0 {
+				mountPoints = append(mountPoints, info.MountPoint)
+			}
+			layers[info.MountPoint]++
+		}
+
+		for _, mountPoint := range mountPoints {
+			unmounted := 0
+			for range layers[mountPoint] - 1 {
+				if own, err := d.isOwnMount(mountPoint, false); err != nil {
+					return removed, fmt.Errorf("unable to verify mount point %q: %w", mountPoint, err)
+				} else if !own {
+					d.log.Info("Not collapsing stacked mounts under a foreign mount", logkeys.VolumePath, mountPoint)
+					break
+				}
+				if err := d.mounter.Unmount(mountPoint); err != nil {
+					return removed, fmt.Errorf("unable to unmount %q: %w", mountPoint, err)
+				}
+				unmounted++
+				removed++
+			}
+			if unmounted > 0 {
+				d.log.Info("Collapsed stacked mounts", logkeys.VolumePath, mountPoint, logkeys.Removed, unmounted)
+			}
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,99 @@
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/spiffe/spiffe-csi/pkg/mount"
+)
+
+// checkTargetPath checks that targetPath, once cleaned, is where the kubelet
+// publishes CSI volumes: <kubelet root>/pods/<pod UID>/volumes/kubernetes.io~csi/<volume>/mount.
+// It returns the pod UID, or nothing if no kubelet root directory is
+// configured, in which case any path is accepted.
+func (d *Driver) checkTargetPath(targetPath string) (string, error) {
+	if d.kubeletRootDir == "" {
+		return "", nil
+	}
+	rel, ok := strings.CutPrefix(filepath.Clean(targetPath), strings.TrimSuffix(d.kubeletRootDir, "/")+"/")
+	if !ok {
+		return "", fmt.Errorf("target path %q is not within the kubelet root directory %q", targetPath, d.kubeletRootDir)
+	}
+	parts := strings.Split(rel, "/")
+	if len(parts) != 6 || parts[0] != "pods" || parts[2] != "volumes" || parts[3] != "kubernetes.io~csi" || parts[5] != "mount" {
+		return "", fmt.Errorf("target path %q does not match %s/pods/<pod UID>/volumes/kubernetes.io~csi/<volume>/mount", targetPath, d.kubeletRootDir)
+	}
+	return parts[1], nil
+}
+
+// mkdirTarget creates the target path. With a kubelet root directory, the
+// path is resolved beneath it without following symlinks, since the parent
+// directories of target paths are within reach of pods.
+func (d *Driver) mkdirTarget(targetPath string) error {
+	if d.kubeletRootDir == "" {
+		return os.Mkdir(targetPath, 0750)
+	}
+	return mount.MkdirBeneath(d.kubeletRootDir, targetPath, 0750)
+}
+
+// removeTarget removes the target path, resolved like in mkdirTarget.
+func (d *Driver) removeTarget(targetPath string) error {
+	if d.kubeletRootDir == "" {
+		return os.Remove(targetPath)
+	}
+	return mount.RemoveBeneath(d.kubeletRootDir, targetPath)
+}
+
+// createSocketMountPoint creates the empty file the socket is bind mounted
+// onto, if not already present. With a kubelet root directory, it is created
+// like the target path in mkdirTarget, and a symlink planted in its place by
+// the pod is refused rather than followed.
+func (d *Driver) createSocketMountPoint(socketMountPath string) error {
+	if d.kubeletRootDir != "" {
+		return mount.CreateBeneath(d.kubeletRootDir, socketMountPath, 0644)
+	}
+	f, err := os.OpenFile(socketMountPath, os.O_CREATE|os.O_RDONLY, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// mkdirInVolume creates the directory path within the volume on the target
+// path, resolved like the target path in mkdirTarget.
+func (d *Driver) mkdirInVolume(path string, perm os.FileMode) error {
+	if d.kubeletRootDir == "" {
+		return os.Mkdir(path, perm)
+	}
+	return mount.MkdirBeneath(d.kubeletRootDir, path, perm)
+}
+
+// writeVolumeFile creates the file path within the volume on the target
+// path, resolved like the target path in mkdirTarget, and writes data to it.
+// Anything already in its place, such as a symlink planted by the pod, is
+// refused rather than followed or overwritten.
+func (d *Driver) writeVolumeFile(path string, data []byte, perm os.FileMode) error {
+	if d.kubeletRootDir != "" {
+		return mount.WriteFileBeneath(d.kubeletRootDir, path, data, perm)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|syscall.O_NOFOLLOW, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// cleanOptionalPath cleans path, leaving it empty if unset.
+func cleanOptionalPath(path string) string {
+	if path == "" {
+		return ""
+	}
+	return filepath.Clean(path)
+}
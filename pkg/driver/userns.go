@@ -0,0 +1,125 @@
+package driver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/spiffe/spiffe-csi/pkg/mount"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// volumeContextIDMap is the volume attribute controlling whether the
+	// Workload API is bind mounted as an ID-mapped mount. One of "auto" (the
+	// default), "true" or "false".
+	volumeContextIDMap = "idmap"
+
+	idmapAuto  = "auto"
+	idmapTrue  = "true"
+	idmapFalse = "false"
+
+	// kubeletUserNamespaceFile is the file in the kubelet pod directory in
+	// which the kubelet records the user namespace mappings of pods with
+	// hostUsers: false.
+	kubeletUserNamespaceFile = "userns"
+)
+
+// kubeletUserNamespace is the content of the kubelet user namespace file.
+type kubeletUserNamespace struct {
+	UIDMappings []kubeletIDMapping `json:"uidMappings"`
+	GIDMappings []kubeletIDMapping `json:"gidMappings"`
+}
+
+type kubeletIDMapping struct {
+	HostID      uint32 `json:"hostId"`
+	ContainerID uint32 `json:"containerId"`
+	Length      uint32 `json:"length"`
+}
+
+// volumeIDMap determines the ID mappings to bind mount the Workload API with,
+// according to the idmap volume attribute and the user namespace of the pod.
+// A nil map means a plain bind mount. The returned bool reports whether the
+// ID-mapped mount was explicitly requested, in which case failing to create
+// it is an error rather than a reason to fall back to a plain bind mount.
+// Errors are gRPC status errors.
+func (d *Driver) volumeIDMap(targetPath string, volumeContext map[string]string) (*mount.IDMap, bool, error) {
+	mode := volumeContext[volumeContextIDMap]
+	switch mode {
+	case "", idmapAuto, idmapTrue:
+	case idmapFalse:
+		return nil, false, nil
+	default:
+		return nil, false, status.Errorf(codes.InvalidArgument, "invalid %s volume attribute %q: must be one of auto, true or false", volumeContextIDMap, mode)
+	}
+	required := mode == idmapTrue
+
+	idmap, err := podIDMap(targetPath)
+	switch {
+	case err != nil:
+		return nil, false, status.Error(codes.Internal, err.Error())
+	case idmap == nil && required:
+		return nil, false, status.Error(codes.FailedPrecondition, "ID-mapped mount requested but the pod does not run in a user namespace")
+	}
+	return idmap, required, nil
+}
+
+// podIDMap returns the user namespace mappings the kubelet recorded for the
+// pod owning the volume at targetPath, or nil if the pod shares the user
+// namespace of the host.
+func podIDMap(targetPath string) (*mount.IDMap, error) {
+	podDir, ok := podDirFromTargetPath(targetPath)
+	if !ok {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(podDir, kubeletUserNamespaceFile))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("unable to read pod user namespace: %w", err)
+	}
+
+	var userns kubeletUserNamespace
+	if err := json.Unmarshal(data, &userns); err != nil {
+		return nil, fmt.Errorf("unable to parse pod user namespace: %w", err)
+	}
+	if len(userns.UIDMappings) == 0 || len(userns.GIDMappings) == 0 {
+		return nil, errors.New("pod user namespace is missing UID or GID mappings")
+	}
+	return &mount.IDMap{
+		UIDs: convertKubeletIDMappings(userns.UIDMappings),
+		GIDs: convertKubeletIDMappings(userns.GIDMappings),
+	}, nil
+}
+
+// podDirFromTargetPath returns the kubelet pod directory of a volume target
+// path, which has the form <pod dir>/volumes/kubernetes.io~csi/<volume>/mount.
+func podDirFromTargetPath(targetPath string) (string, bool) {
+	volumeDir := filepath.Dir(filepath.Clean(targetPath))
+	pluginDir := filepath.Dir(volumeDir)
+	volumesDir := filepath.Dir(pluginDir)
+	if filepath.Base(targetPath) != "mount" ||
+		filepath.Base(pluginDir) != "kubernetes.io~csi" ||
+		filepath.Base(volumesDir) != "volumes" {
+		return "", false
+	}
+	return filepath.Dir(volumesDir), true
+}
+
+func convertKubeletIDMappings(in []kubeletIDMapping) []mount.IDMapping {
+	out := make([]mount.IDMapping, 0, len(in))
+	for _, m := range in {
+		out = append(out, mount.IDMapping{
+			ContainerID: m.ContainerID,
+			HostID:      m.HostID,
+			Size:        m.Length,
+		})
+	}
+	return out
+}
@@ -0,0 +1,70 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// workers runs the parts of RPCs that touch the filesystem or mount table,
+// which can block indefinitely on a hung filesystem (e.g. an NFS server or
+// FUSE daemon that is gone), so that the RPCs can give up on them. A call
+// that is given up on keeps running, and keeps its worker, until it returns;
+// with a fixed number of workers, stuck calls cannot pile up goroutines
+// without bound, and once all workers are stuck, further calls time out
+// waiting for one.
+type workers struct {
+	slots chan struct{}
+}
+
+func newWorkers(n int) *workers {
+	return &workers{slots: make(chan struct{}, n)}
+}
+
+// run calls fn on a worker and waits for it to return, for the worker to
+// become available, for at most timeout (if not zero) or until ctx is done.
+// It returns the error of fn, or a DeadlineExceeded or Canceled status if it
+// stopped waiting. done is called once fn has returned, or right away if fn
+// never ran, so that whatever fn works on stays claimed while it runs.
+func (w *workers) run(ctx context.Context, timeout time.Duration, op string, fn func() error, done func()) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	select {
+	case w.slots <- struct{}{}:
+	case <-ctx.Done():
+		done()
+		return contextStatus(ctx.Err(), "%s did not start: all %d workers are busy", op, cap(w.slots))
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		defer func() {
+			<-w.slots
+			done()
+		}()
+		result <- fn()
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return contextStatus(ctx.Err(), "%s did not complete and is left running", op)
+	}
+}
+
+// contextStatus maps the error of a done context to a status.
+func contextStatus(err error, format string, args ...any) error {
+	code := codes.DeadlineExceeded
+	if errors.Is(err, context.Canceled) {
+		code = codes.Canceled
+	}
+	return status.Errorf(code, format+": %v", append(args, err)...)
+}
@@ -3,9 +3,31 @@ package logkeys
 
 // Log field keys for structured logging.
 const (
+	Attempts             = "attempts"
+	BoundingSetDropped   = "boundingSetDropped"
+	Capabilities         = "capabilities"
+	Corrupted            = "corrupted"
 	CSISocketPath        = "csiSocketPath"
+	Detached             = "detached"
+	DevModeDir           = "devModeDir"
+	Error                = "error"
 	FullMethod           = "fullMethod"
+	LandlockABI          = "landlockABI"
+	LandlockEnforced     = "landlockEnforced"
+	LandlockPaths        = "landlockPaths"
+	LandlockReason       = "landlockReason"
+	MountHelper          = "mountHelper"
 	NodeID               = "nodeID"
+	NoNewPrivs           = "noNewPrivs"
+	PeerExecutable       = "peerExecutable"
+	PeerGID              = "peerGID"
+	PeerPID              = "peerPID"
+	PeerUID              = "peerUID"
+	PluginName           = "pluginName"
+	Removed              = "removed"
+	SELinuxLabel         = "seLinuxLabel"
+	Skipped              = "skipped"
+	SocketMountPath      = "socketMountPath"
 	TargetPath           = "targetPath"
 	Version              = "version"
 	VolumeID             = "volumeID"
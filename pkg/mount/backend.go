@@ -0,0 +1,92 @@
+package mount
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	// LocalBackend is the name of the backend providing Local.
+	LocalBackend = "local"
+
+	// HostNamespaceBackend is the name of the backend providing a
+	// Namespace for the host PID, or PID 1 if unset.
+	HostNamespaceBackend = "host-namespace"
+
+	// DevBackend is the name of the backend providing a Dev under the
+	// scratch directory, for development without privileges.
+	DevBackend = "dev"
+)
+
+// BackendOptions are passed to backend factories.
+type BackendOptions struct {
+	// HostPID is the PID of a process in the host mount namespace, or 0 if
+	// not configured.
+	HostPID int
+
+	// ScratchDir is the directory the dev backend keeps its state in.
+	ScratchDir string
+
+	// KubeletRootDir, if set, is the directory the local and
+	// host-namespace backends resolve mount points beneath (see
+	// Local.Beneath).
+	KubeletRootDir string
+}
+
+// BackendFactory creates the Mounter of a backend.
+type BackendFactory func(opts BackendOptions) (Mounter, error)
+
+var (
+	backendsMtx sync.Mutex
+	backends    = map[string]BackendFactory{
+		LocalBackend: func(opts BackendOptions) (Mounter, error) {
+			return Local{Beneath: opts.KubeletRootDir}, nil
+		},
+		HostNamespaceBackend: func(opts BackendOptions) (Mounter, error) {
+			pid := opts.HostPID
+			if pid == 0 {
+				pid = 1
+			}
+			return Namespace{Local: Local{Beneath: opts.KubeletRootDir}, PID: pid}, nil
+		},
+		DevBackend: func(opts BackendOptions) (Mounter, error) {
+			return NewDev(opts.ScratchDir)
+		},
+	}
+)
+
+// RegisterBackend makes a Mounter backend available by name. It is meant to
+// be called from init functions and panics if the name is already taken.
+func RegisterBackend(name string, factory BackendFactory) {
+	backendsMtx.Lock()
+	defer backendsMtx.Unlock()
+	if _, ok := backends[name]; ok {
+		panic(fmt.Sprintf("mount backend %q is already registered", name))
+	}
+	backends[name] = factory
+}
+
+// NewBackend creates the Mounter of the named backend.
+func NewBackend(name string, opts BackendOptions) (Mounter, error) {
+	backendsMtx.Lock()
+	factory, ok := backends[name]
+	backendsMtx.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported mount backend %q: must be one of %s", name, strings.Join(Backends(), ", "))
+	}
+	return factory(opts)
+}
+
+// Backends returns the sorted names of the registered backends.
+func Backends() []string {
+	backendsMtx.Lock()
+	defer backendsMtx.Unlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,86 @@
+package mount
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBackend(t *testing.T) {
+	for _, tt := range []struct {
+		desc          string
+		name          string
+		opts          BackendOptions
+		expectMounter Mounter
+	}{
+		{
+			desc:          "local",
+			name:          LocalBackend,
+			expectMounter: Local{},
+		},
+		{
+			desc:          "host namespace of PID 1",
+			name:          HostNamespaceBackend,
+			expectMounter: Namespace{PID: 1},
+		},
+		{
+			desc:          "host namespace of the host PID",
+			name:          HostNamespaceBackend,
+			opts:          BackendOptions{HostPID: 1234},
+			expectMounter: Namespace{PID: 1234},
+		},
+		{
+			desc:          "local beneath the kubelet root",
+			name:          LocalBackend,
+			opts:          BackendOptions{KubeletRootDir: "/var/lib/kubelet"},
+			expectMounter: Local{Beneath: "/var/lib/kubelet"},
+		},
+		{
+			desc:          "host namespace beneath the kubelet root",
+			name:          HostNamespaceBackend,
+			opts:          BackendOptions{KubeletRootDir: "/var/lib/kubelet"},
+			expectMounter: Namespace{Local: Local{Beneath: "/var/lib/kubelet"}, PID: 1},
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			m, err := NewBackend(tt.name, tt.opts)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectMounter, m)
+		})
+	}
+
+	scratchDir := t.TempDir()
+	m, err := NewBackend(DevBackend, BackendOptions{ScratchDir: scratchDir})
+	require.NoError(t, err)
+	assert.Equal(t, &Dev{root: scratchDir}, m)
+	_, err = NewBackend(DevBackend, BackendOptions{})
+	require.EqualError(t, err, "dev mounter requires a scratch root")
+
+	_, err = NewBackend("bogus", BackendOptions{})
+	require.EqualError(t, err, `unsupported mount backend "bogus": must be one of dev, host-namespace, local`)
+}
+
+func TestRegisterBackend(t *testing.T) {
+	t.Cleanup(func() {
+		backendsMtx.Lock()
+		defer backendsMtx.Unlock()
+		delete(backends, "test")
+	})
+
+	var gotOpts BackendOptions
+	RegisterBackend("test", func(opts BackendOptions) (Mounter, error) {
+		gotOpts = opts
+		return Local{}, nil
+	})
+	assert.Contains(t, Backends(), "test")
+
+	m, err := NewBackend("test", BackendOptions{HostPID: 42})
+	require.NoError(t, err)
+	assert.Equal(t, Local{}, m)
+	assert.Equal(t, BackendOptions{HostPID: 42}, gotOpts)
+
+	assert.PanicsWithValue(t, `mount backend "local" is already registered`, func() {
+		RegisterBackend(LocalBackend, nil)
+	})
+}
@@ -0,0 +1,129 @@
+package mount
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrNotBeneath is returned when a path that has to be resolved beneath a
+// directory is not within it.
+var ErrNotBeneath = errors.New("path is not beneath the directory")
+
+// errNotRegular is returned by CreateBeneath when something other than a
+// regular file is in the way.
+var errNotRegular = errors.New("file exists and is not a regular file")
+
+// OpenBeneath opens path, which must be dir or within it, with O_PATH. The
+// path is resolved relative to dir with openat2 and may neither leave dir
+// nor contain symlinks in any component, so a symlink planted along the way
+// (e.g. in a pod volume directory) cannot redirect it elsewhere.
+func OpenBeneath(dir, path string) (*os.File, error) {
+	return openBeneath(dir, path)
+}
+
+// MkdirBeneath creates the directory path within dir like os.Mkdir, with
+// the parent directory of path resolved like in OpenBeneath.
+func MkdirBeneath(dir, path string, perm os.FileMode) error {
+	return mkdirBeneath(dir, path, perm)
+}
+
+// CreateBeneath creates an empty regular file path within dir, if not
+// already present, with the parent directory of path resolved like in
+// OpenBeneath. Neither a symlink nor anything else but a regular file is
+// accepted in place of path.
+func CreateBeneath(dir, path string, perm os.FileMode) error {
+	return createBeneath(dir, path, perm)
+}
+
+// WriteFileBeneath creates the regular file path within dir and writes data
+// to it, with the parent directory of path resolved like in OpenBeneath.
+// Unlike os.WriteFile, it fails if anything, including a symlink, is
+// already present in place of path.
+func WriteFileBeneath(dir, path string, data []byte, perm os.FileMode) error {
+	return writeFileBeneath(dir, path, data, perm)
+}
+
+// CheckBeneath returns an error if paths cannot be resolved beneath a
+// directory on this system. Resolving them requires openat2, which was
+// added in Linux 5.6.
+func CheckBeneath() error {
+	return checkBeneath()
+}
+
+// RemoveBeneath removes the file or empty directory path within dir like
+// os.Remove, with the parent directory of path resolved like in OpenBeneath.
+func RemoveBeneath(dir, path string) error {
+	return removeBeneath(dir, path)
+}
+
+// relBeneath returns path relative to dir. Both have to be absolute.
+func relBeneath(dir, path string) (string, error) {
+	if !filepath.IsAbs(dir) || !filepath.IsAbs(path) {
+		return "", fmt.Errorf("%w: %q and %q have to be absolute", ErrNotBeneath, path, dir)
+	}
+	dir, path = filepath.Clean(dir), filepath.Clean(path)
+	if !isPathWithin(path, dir) {
+		return "", fmt.Errorf("%w: %q is not within %q", ErrNotBeneath, path, dir)
+	}
+	return filepath.Rel(dir, path)
+}
+
+// openParentBeneath opens the parent directory of path, which has to be
+// within dir, and returns it along with the last element of path.
+func openParentBeneath(dir, path string) (*os.File, string, error) {
+	if rel, err := relBeneath(dir, path); err != nil {
+		return nil, "", err
+	} else if rel == "." {
+		return nil, "", fmt.Errorf("%w: %q is the directory itself", ErrNotBeneath, path)
+	}
+	path = filepath.Clean(path)
+	parent, err := openBeneath(dir, filepath.Dir(path))
+	if err != nil {
+		return nil, "", err
+	}
+	return parent, filepath.Base(path), nil
+}
+
+// fdPath returns the path of the proc filesystem magic link to the open file
+// f. Unlike a regular path, it keeps referring to the file f was opened on,
+// not to whatever may have taken its place since.
+func fdPath(f *os.File) string {
+	return fmt.Sprintf("/proc/self/fd/%d", f.Fd())
+}
+
+// atMountPoint calls fn with a path to mountPoint to mount onto. If beneath
+// is set, mountPoint is resolved beneath it like in OpenBeneath and fn gets
+// the magic link to the file descriptor of the result, so that the mount
+// lands exactly on what was resolved. Otherwise fn gets mountPoint as is.
+// The magic link is the last element of the path, so fn has to follow it,
+// as mount(2) does.
+func atMountPoint(beneath, mountPoint string, fn func(mountPoint string) error) error {
+	if beneath == "" {
+		return fn(mountPoint)
+	}
+	f, err := openBeneath(beneath, mountPoint)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	return fn(fdPath(f))
+}
+
+// atParent is like atMountPoint, except that only the parent directory of
+// mountPoint is resolved and fn gets a path to mountPoint below its magic
+// link. The last element is then looked up by the system call itself,
+// which lets unmounting deal with mounts that fail to be opened, such as
+// stale NFS mounts.
+func atParent(beneath, mountPoint string, fn func(mountPoint string) error) error {
+	if beneath == "" {
+		return fn(mountPoint)
+	}
+	parent, name, err := openParentBeneath(beneath, mountPoint)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = parent.Close() }()
+	return fn(filepath.Join(fdPath(parent), name))
+}
@@ -0,0 +1,157 @@
+package mount
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// resolveBeneath are the openat2 resolve flags for paths resolved beneath a
+// directory.
+const resolveBeneath = unix.RESOLVE_BENEATH | unix.RESOLVE_NO_SYMLINKS | unix.RESOLVE_NO_MAGICLINKS
+
+func openBeneath(dir, path string) (*os.File, error) {
+	rel, err := relBeneath(dir, path)
+	if err != nil {
+		return nil, err
+	}
+	dirFD, err := unix.Open(dir, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: dir, Err: err}
+	}
+	defer func() { _ = unix.Close(dirFD) }()
+
+	fd, err := unix.Openat2(dirFD, rel, &unix.OpenHow{
+		Flags:   unix.O_PATH | unix.O_CLOEXEC,
+		Resolve: resolveBeneath,
+	})
+	if err != nil {
+		return nil, &os.PathError{Op: "openat2", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+func mkdirBeneath(dir, path string, perm os.FileMode) error {
+	parent, name, err := openParentBeneath(dir, path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = parent.Close() }()
+	if err := unix.Mkdirat(int(parent.Fd()), name, uint32(perm.Perm())); err != nil {
+		return &os.PathError{Op: "mkdir", Path: path, Err: err}
+	}
+	return nil
+}
+
+func createBeneath(dir, path string, perm os.FileMode) error {
+	parent, name, err := openParentBeneath(dir, path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = parent.Close() }()
+	fd, err := unix.Openat(int(parent.Fd()), name, unix.O_CREAT|unix.O_EXCL|unix.O_NOFOLLOW|unix.O_RDONLY|unix.O_CLOEXEC, uint32(perm.Perm()))
+	if err == nil {
+		return unix.Close(fd)
+	}
+	if !errors.Is(err, unix.EEXIST) {
+		return &os.PathError{Op: "create", Path: path, Err: err}
+	}
+	var stat unix.Stat_t
+	if err := unix.Fstatat(int(parent.Fd()), name, &stat, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	if stat.Mode&unix.S_IFMT != unix.S_IFREG {
+		return &os.PathError{Op: "create", Path: path, Err: errNotRegular}
+	}
+	return nil
+}
+
+func writeFileBeneath(dir, path string, data []byte, perm os.FileMode) error {
+	parent, name, err := openParentBeneath(dir, path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = parent.Close() }()
+	fd, err := unix.Openat(int(parent.Fd()), name, unix.O_CREAT|unix.O_EXCL|unix.O_NOFOLLOW|unix.O_WRONLY|unix.O_CLOEXEC, uint32(perm.Perm()))
+	if err != nil {
+		return &os.PathError{Op: "create", Path: path, Err: err}
+	}
+	f := os.NewFile(uintptr(fd), path)
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func checkBeneath() error {
+	f, err := openBeneath("/", "/")
+	if errors.Is(err, unix.ENOSYS) {
+		return fmt.Errorf("openat2 is not supported; Linux 5.6 or later is required: %w", err)
+	}
+	if err == nil {
+		_ = f.Close()
+	}
+	return nil
+}
+
+func removeBeneath(dir, path string) error {
+	parent, name, err := openParentBeneath(dir, path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = parent.Close() }()
+	err = unix.Unlinkat(int(parent.Fd()), name, 0)
+	if errors.Is(err, unix.EISDIR) {
+		err = unix.Unlinkat(int(parent.Fd()), name, unix.AT_REMOVEDIR)
+	}
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: path, Err: err}
+	}
+	return nil
+}
+
+// unmountAt unmounts the topmost mount on mountPoint, resolved beneath
+// beneath, if set. A symlink in place of mountPoint is not followed.
+func unmountAt(beneath, mountPoint string) error {
+	if beneath == "" {
+		return unmount(mountPoint)
+	}
+	return atParent(beneath, mountPoint, func(mountPoint string) error {
+		return unmountSyscall(mountPoint, unix.UMOUNT_NOFOLLOW)
+	})
+}
+
+// unmountWithOptionsAt is unmountWithOptions with mountPoint resolved like
+// in unmountAt.
+func unmountWithOptionsAt(beneath, mountPoint string, opts UnmountOptions) (result UnmountResult, err error) {
+	if beneath == "" {
+		return unmountWithOptions(mountPoint, opts)
+	}
+	err = atParent(beneath, mountPoint, func(mountPoint string) error {
+		result, err = unmountWithFlags(mountPoint, opts, unix.UMOUNT_NOFOLLOW)
+		return err
+	})
+	return result, err
+}
+
+// attachTreeAt is attachTree with mountPoint resolved beneath beneath, if
+// set. The detached mount is attached to the resolved file descriptor
+// directly, since move_mount does not follow the magic link that
+// atMountPoint would pass.
+func attachTreeAt(fd int, beneath, mountPoint string) error {
+	if beneath == "" {
+		return attachTree(fd, mountPoint)
+	}
+	f, err := openBeneath(beneath, mountPoint)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	if err := unix.MoveMount(fd, "", int(f.Fd()), "", unix.MOVE_MOUNT_F_EMPTY_PATH|unix.MOVE_MOUNT_T_EMPTY_PATH); err != nil {
+		return fmt.Errorf("move_mount: %w", err)
+	}
+	return nil
+}
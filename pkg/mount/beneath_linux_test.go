@@ -0,0 +1,207 @@
+package mount
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// beneathTree creates a root directory with a "dir" directory, an "outside"
+// directory next to the root, and symlinks from the root to both.
+func beneathTree(t *testing.T) (root, outside string) {
+	t.Helper()
+	base := t.TempDir()
+	root = filepath.Join(base, "root")
+	outside = filepath.Join(base, "outside")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "dir", "sub"), 0755))
+	require.NoError(t, os.Mkdir(outside, 0755))
+	require.NoError(t, os.Symlink("dir", filepath.Join(root, "link")))
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "escape")))
+	return root, outside
+}
+
+func skipIfNoOpenat2(t *testing.T) {
+	t.Helper()
+	_, err := OpenBeneath("/", "/")
+	if errors.Is(err, unix.ENOSYS) {
+		t.Skip("openat2 is not supported")
+	}
+}
+
+func TestOpenBeneath(t *testing.T) {
+	skipIfNoOpenat2(t)
+	root, outside := beneathTree(t)
+
+	for _, tt := range []struct {
+		name    string
+		path    string
+		wantErr error
+	}{
+		{name: "root", path: root},
+		{name: "directory", path: filepath.Join(root, "dir", "sub")},
+		{name: "unclean", path: root + "/dir/../dir/sub/"},
+		{name: "symlinked component", path: filepath.Join(root, "link", "sub"), wantErr: unix.ELOOP},
+		{name: "symlink", path: filepath.Join(root, "link"), wantErr: unix.ELOOP},
+		{name: "symlink outside", path: filepath.Join(root, "escape"), wantErr: unix.ELOOP},
+		{name: "outside", path: outside, wantErr: ErrNotBeneath},
+		{name: "dot dot", path: root + "/../outside", wantErr: ErrNotBeneath},
+		{name: "relative", path: "dir", wantErr: ErrNotBeneath},
+		{name: "missing", path: filepath.Join(root, "missing"), wantErr: os.ErrNotExist},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := OpenBeneath(root, tt.path)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			defer f.Close()
+			same, err := SameFile(fdPath(f), tt.path)
+			require.NoError(t, err)
+			assert.True(t, same)
+		})
+	}
+}
+
+func TestMkdirBeneath(t *testing.T) {
+	skipIfNoOpenat2(t)
+	root, outside := beneathTree(t)
+
+	path := filepath.Join(root, "dir", "new")
+	require.NoError(t, MkdirBeneath(root, path, 0750))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	assert.ErrorIs(t, MkdirBeneath(root, path, 0750), os.ErrExist)
+
+	assert.ErrorIs(t, MkdirBeneath(root, filepath.Join(root, "link", "new"), 0750), unix.ELOOP)
+	assert.ErrorIs(t, MkdirBeneath(root, filepath.Join(root, "escape", "new"), 0750), unix.ELOOP)
+	assert.ErrorIs(t, MkdirBeneath(root, filepath.Join(outside, "new"), 0750), ErrNotBeneath)
+	assert.ErrorIs(t, MkdirBeneath(root, root, 0750), ErrNotBeneath)
+	assert.NoDirExists(t, filepath.Join(root, "dir", "new", "new"))
+	assert.NoDirExists(t, filepath.Join(outside, "new"))
+}
+
+func TestCreateBeneath(t *testing.T) {
+	skipIfNoOpenat2(t)
+	root, outside := beneathTree(t)
+
+	path := filepath.Join(root, "dir", "file")
+	require.NoError(t, CreateBeneath(root, path, 0644))
+	info, err := os.Lstat(path)
+	require.NoError(t, err)
+	assert.True(t, info.Mode().IsRegular())
+	assert.Equal(t, int64(0), info.Size())
+
+	// An existing regular file is left as is.
+	require.NoError(t, os.WriteFile(path, []byte("data"), 0644))
+	require.NoError(t, CreateBeneath(root, path, 0644))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+
+	// Neither a symlink in place of the file nor one along the way is
+	// followed.
+	require.NoError(t, os.Symlink(filepath.Join(outside, "victim"), filepath.Join(root, "dir", "planted")))
+	assert.ErrorIs(t, CreateBeneath(root, filepath.Join(root, "dir", "planted"), 0644), errNotRegular)
+	assert.ErrorIs(t, CreateBeneath(root, filepath.Join(root, "link", "file"), 0644), unix.ELOOP)
+	assert.ErrorIs(t, CreateBeneath(root, filepath.Join(root, "escape", "victim"), 0644), unix.ELOOP)
+	assert.ErrorIs(t, CreateBeneath(root, filepath.Join(outside, "victim"), 0644), ErrNotBeneath)
+	assert.NoFileExists(t, filepath.Join(outside, "victim"))
+
+	assert.ErrorIs(t, CreateBeneath(root, filepath.Join(root, "dir", "sub"), 0644), errNotRegular)
+}
+
+func TestWriteFileBeneath(t *testing.T) {
+	skipIfNoOpenat2(t)
+	root, outside := beneathTree(t)
+
+	path := filepath.Join(root, "dir", "file")
+	require.NoError(t, WriteFileBeneath(root, path, []byte("data"), 0644))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+
+	// An existing file is not overwritten.
+	assert.ErrorIs(t, WriteFileBeneath(root, path, []byte("other"), 0644), unix.EEXIST)
+
+	// Neither a symlink in place of the file nor one along the way is
+	// followed.
+	require.NoError(t, os.Symlink(filepath.Join(outside, "victim"), filepath.Join(root, "dir", "planted")))
+	assert.ErrorIs(t, WriteFileBeneath(root, filepath.Join(root, "dir", "planted"), nil, 0644), unix.EEXIST)
+	assert.ErrorIs(t, WriteFileBeneath(root, filepath.Join(root, "link", "file"), nil, 0644), unix.ELOOP)
+	assert.ErrorIs(t, WriteFileBeneath(root, filepath.Join(root, "escape", "victim"), nil, 0644), unix.ELOOP)
+	assert.ErrorIs(t, WriteFileBeneath(root, filepath.Join(outside, "victim"), nil, 0644), ErrNotBeneath)
+	assert.NoFileExists(t, filepath.Join(outside, "victim"))
+}
+
+func TestCheckBeneath(t *testing.T) {
+	skipIfNoOpenat2(t)
+	assert.NoError(t, CheckBeneath())
+}
+
+func TestRemoveBeneath(t *testing.T) {
+	skipIfNoOpenat2(t)
+	root, outside := beneathTree(t)
+	require.NoError(t, os.Mkdir(filepath.Join(outside, "victim"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "dir", "file"), nil, 0644))
+
+	require.NoError(t, RemoveBeneath(root, filepath.Join(root, "dir", "sub")))
+	assert.NoDirExists(t, filepath.Join(root, "dir", "sub"))
+	require.NoError(t, RemoveBeneath(root, filepath.Join(root, "dir", "file")))
+	assert.NoFileExists(t, filepath.Join(root, "dir", "file"))
+	assert.ErrorIs(t, RemoveBeneath(root, filepath.Join(root, "dir", "sub")), os.ErrNotExist)
+
+	// The symlink itself is removed, not what it points to.
+	require.NoError(t, RemoveBeneath(root, filepath.Join(root, "escape")))
+	assert.DirExists(t, outside)
+
+	assert.ErrorIs(t, RemoveBeneath(root, filepath.Join(root, "link", "x")), unix.ELOOP)
+	assert.ErrorIs(t, RemoveBeneath(root, filepath.Join(outside, "victim")), ErrNotBeneath)
+	assert.DirExists(t, filepath.Join(outside, "victim"))
+}
+
+func TestLocalBeneath(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("bind mounting requires root")
+	}
+	skipIfNoOpenat2(t)
+	useProcMountInfo(t)
+	root, outside := beneathTree(t)
+	source := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(source, "socket"), nil, 0644))
+	l := Local{Beneath: root}
+
+	// Mount points reached through a symlink are refused.
+	assert.ErrorIs(t, l.BindMount(source, filepath.Join(root, "link", "sub"), BindOptions{ReadOnly: true}), unix.ELOOP)
+	assert.ErrorIs(t, l.MountTmpfs(filepath.Join(root, "escape"), "size=1m"), unix.ELOOP)
+	assert.ErrorIs(t, l.BindMountRW(source, outside), ErrNotBeneath)
+	mounted, err := IsMountPoint(outside)
+	require.NoError(t, err)
+	assert.False(t, mounted)
+
+	mountPoint := filepath.Join(root, "dir", "sub")
+	require.NoError(t, l.BindMount(source, mountPoint, BindOptions{ReadOnly: true}))
+	t.Cleanup(func() { _ = Unmount(mountPoint) })
+	ok, err := IsBindMountOf(mountPoint, source)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	require.NoError(t, VerifyBindOptions(mountPoint, BindOptions{ReadOnly: true}))
+	require.NoError(t, l.SetPropagation(mountPoint, PropagationPrivate))
+	require.NoError(t, VerifyPropagation(mountPoint, PropagationPrivate))
+
+	// Unmounting does not follow a symlink in place of the mount point.
+	assert.Error(t, l.Unmount(filepath.Join(root, "link")))
+	_, err = l.UnmountWithOptions(filepath.Join(root, "link", "sub"), UnmountOptions{})
+	assert.ErrorIs(t, err, unix.ELOOP)
+
+	require.NoError(t, l.Unmount(mountPoint))
+	mounted, err = IsMountPoint(mountPoint)
+	require.NoError(t, err)
+	assert.False(t, mounted)
+}
@@ -0,0 +1,566 @@
+package mount
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Dev is a Mounter for developing against the driver without privileges.
+// Nothing is actually mounted. Instead, the mount point is replaced by a
+// symlink: to the source of a bind mount, or to a fresh directory under the
+// scratch root for a tmpfs. The original mount point is put back on unmount.
+//
+// The mounts are recorded in a state file under the scratch root, so the
+// mount information stays consistent across restarts of the driver. As
+// nothing is mounted, bind options, propagation types and superblock options
+// are recorded rather than applied, and ID-mapped mounts are unsupported.
+type Dev struct {
+	root string
+	mtx  sync.Mutex
+}
+
+var _ Mounter = (*Dev)(nil)
+
+// devStateFile is the name of the state file under the scratch root.
+const devStateFile = "state.json"
+
+type devState struct {
+	NextID int        `json:"nextID"`
+	Mounts []devMount `json:"mounts"`
+}
+
+type devMount struct {
+	ID          int         `json:"id"`
+	MountPoint  string      `json:"mountPoint"`
+	Source      string      `json:"source"`
+	FSType      string      `json:"fsType"`
+	Target      string      `json:"target"`
+	Data        string      `json:"data,omitempty"`
+	Options     BindOptions `json:"options"`
+	Propagation Propagation `json:"propagation,omitempty"`
+
+	// Covered is set on the bottommost mount on a mount point and describes
+	// how to restore the original mount point.
+	Covered *devCovered `json:"covered,omitempty"`
+}
+
+type devCovered struct {
+	Mode os.FileMode `json:"mode"`
+
+	// Path is where a non-empty mount point was moved to, if it could not
+	// simply be removed and re-created.
+	Path string `json:"path,omitempty"`
+}
+
+// NewDev returns a Dev keeping its state and tmpfs directories under the
+// scratch root, which is created if needed.
+func NewDev(root string) (*Dev, error) {
+	if root == "" {
+		return nil, errors.New("dev mounter requires a scratch root")
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, fmt.Errorf("unable to create scratch root: %w", err)
+	}
+	d := &Dev{root: root}
+	if _, err := d.load(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// BindMountRW replaces mountPoint with a symlink to root.
+func (d *Dev) BindMountRW(root, mountPoint string) error {
+	return d.BindMount(root, mountPoint, BindOptions{})
+}
+
+// BindMount replaces mountPoint with a symlink to root and records opts.
+func (d *Dev) BindMount(root, mountPoint string, opts BindOptions) error {
+	rootInfo, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	mountPointInfo, err := os.Stat(mountPoint)
+	switch {
+	case err != nil:
+		return err
+	case rootInfo.IsDir() && !mountPointInfo.IsDir():
+		return fmt.Errorf("dev bind mount of %q onto %q: %w", root, mountPoint, syscall.ENOTDIR)
+	case !rootInfo.IsDir() && mountPointInfo.IsDir():
+		return fmt.Errorf("dev bind mount of %q onto %q: %w", root, mountPoint, syscall.EISDIR)
+	}
+	// The mount helper passes the magic link to the file it resolved, which
+	// stands for the path that file was opened on, as it does in the mount
+	// information of a real bind mount.
+	if strings.HasPrefix(root, "/proc/self/fd/") {
+		root, err = os.Readlink(root)
+	} else {
+		root, err = filepath.Abs(root)
+	}
+	if err != nil {
+		return err
+	}
+	return d.mount(mountPoint, devMount{
+		Source:  root,
+		FSType:  "none",
+		Target:  root,
+		Options: opts,
+	})
+}
+
+// BindMountIDMapped fails with ErrIDMapUnsupported.
+func (d *Dev) BindMountIDMapped(_, _ string, _ BindOptions, _ IDMap) error {
+	return fmt.Errorf("%w: not supported by the dev mounter", ErrIDMapUnsupported)
+}
+
+// MountTmpfs replaces mountPoint with a symlink to a new directory under the
+// scratch root. The directory gets the mode in data, if any.
+func (d *Dev) MountTmpfs(mountPoint, data string) error {
+	info, err := os.Stat(mountPoint)
+	switch {
+	case err != nil:
+		return err
+	case !info.IsDir():
+		return fmt.Errorf("dev tmpfs mount onto %q: %w", mountPoint, syscall.ENOTDIR)
+	}
+	mode := os.FileMode(0755)
+	for _, option := range SplitOptions(data) {
+		if value, ok := strings.CutPrefix(option, "mode="); ok {
+			m, err := strconv.ParseUint(value, 8, 32)
+			if err != nil {
+				return fmt.Errorf("dev tmpfs mount onto %q: invalid mode %q: %w", mountPoint, value, syscall.EINVAL)
+			}
+			mode = os.FileMode(m).Perm()
+		}
+	}
+	tmpfsDir := filepath.Join(d.root, "tmpfs")
+	if err := os.MkdirAll(tmpfsDir, 0700); err != nil {
+		return err
+	}
+	target, err := os.MkdirTemp(tmpfsDir, "")
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(target, mode); err != nil {
+		return errors.Join(err, os.Remove(target))
+	}
+	if err := d.mount(mountPoint, devMount{
+		Source: "tmpfs",
+		FSType: "tmpfs",
+		Target: target,
+		Data:   data,
+		Options: BindOptions{
+			NoSuid: true,
+			NoDev:  true,
+			NoExec: true,
+		},
+	}); err != nil {
+		return errors.Join(err, os.Remove(target))
+	}
+	return nil
+}
+
+// Unmount removes the topmost mount on mountPoint. Like the real thing, it
+// fails with EBUSY while something is mounted below mountPoint.
+func (d *Dev) Unmount(mountPoint string) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	state, err := d.load()
+	if err != nil {
+		return err
+	}
+	mountPoint, err = filepath.Abs(mountPoint)
+	if err != nil {
+		return err
+	}
+	i := state.top(mountPoint)
+	if i < 0 {
+		return devNotMounted(mountPoint)
+	}
+	for _, mnt := range state.Mounts {
+		if mnt.MountPoint != mountPoint && isPathWithin(mnt.MountPoint, mountPoint) {
+			return fmt.Errorf("dev unmount of %q: %q is mounted below it: %w", mountPoint, mnt.MountPoint, syscall.EBUSY)
+		}
+	}
+	mnt := state.Mounts[i]
+	state.Mounts = slices.Delete(state.Mounts, i, i+1)
+
+	if lower := state.top(mountPoint); lower >= 0 {
+		err = replaceSymlink(state.Mounts[lower].Target, mountPoint)
+	} else {
+		err = uncover(mountPoint, mnt.Covered)
+	}
+	if err != nil {
+		return fmt.Errorf("dev unmount of %q: %w", mountPoint, err)
+	}
+	if mnt.FSType == "tmpfs" {
+		// The contents of a tmpfs disappear with it.
+		if err := os.RemoveAll(mnt.Target); err != nil {
+			return fmt.Errorf("dev unmount of %q: %w", mountPoint, err)
+		}
+	}
+	return d.save(state)
+}
+
+// UnmountWithOptions calls Unmount. The unmount takes a single attempt.
+func (d *Dev) UnmountWithOptions(mountPoint string, _ UnmountOptions) (UnmountResult, error) {
+	return UnmountResult{Attempts: 1}, d.Unmount(mountPoint)
+}
+
+// SetPropagation records the propagation type of the topmost mount on
+// mountPoint.
+func (d *Dev) SetPropagation(mountPoint string, p Propagation) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	state, err := d.load()
+	if err != nil {
+		return err
+	}
+	mountPoint, err = filepath.Abs(mountPoint)
+	if err != nil {
+		return err
+	}
+	i := state.top(mountPoint)
+	if i < 0 {
+		return devNotMounted(mountPoint)
+	}
+	state.Mounts[i].Propagation = p
+	return d.save(state)
+}
+
+// IsMountPoint returns whether a mount on mountPoint is recorded.
+func (d *Dev) IsMountPoint(mountPoint string) (bool, error) {
+	_, ok, err := d.topMount(mountPoint)
+	return ok, err
+}
+
+// GetMount returns the record of the topmost mount on mountPoint.
+func (d *Dev) GetMount(mountPoint string) (MountInfo, bool, error) {
+	mnt, ok, err := d.topMount(mountPoint)
+	if err != nil || !ok {
+		return MountInfo{}, false, err
+	}
+	return mnt.info(), true, nil
+}
+
+// IsBindMountOf returns whether the topmost mount on mountPoint is a bind
+// mount of source.
+func (d *Dev) IsBindMountOf(mountPoint, source string) (bool, error) {
+	mnt, ok, err := d.topMount(mountPoint)
+	if err != nil || !ok {
+		return false, err
+	}
+	source, err = filepath.Abs(source)
+	if err != nil {
+		return false, err
+	}
+	return mnt.FSType != "tmpfs" && mnt.Source == source, nil
+}
+
+// BindMountsOf returns the records of the bind mounts of source.
+func (d *Dev) BindMountsOf(source string) ([]MountInfo, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	state, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+	source, err = filepath.Abs(source)
+	if err != nil {
+		return nil, err
+	}
+	var infos []MountInfo
+	for _, mnt := range state.Mounts {
+		if mnt.FSType != "tmpfs" && mnt.Source == source {
+			infos = append(infos, mnt.info())
+		}
+	}
+	return infos, nil
+}
+
+// SameFile calls SameFile. The symlinks standing in for bind mounts are
+// followed, so a bind mount is the same file as its source.
+func (d *Dev) SameFile(a, b string) (bool, error) { return SameFile(a, b) }
+
+// VerifyBindOptions checks that the topmost mount on mountPoint was made
+// with every attribute requested by opts.
+func (d *Dev) VerifyBindOptions(mountPoint string, opts BindOptions) error {
+	mnt, ok, err := d.topMount(mountPoint)
+	switch {
+	case err != nil:
+		return err
+	case !ok:
+		return devNotMounted(mountPoint)
+	case opts.ReadOnly && !mnt.Options.ReadOnly,
+		opts.NoSuid && !mnt.Options.NoSuid,
+		opts.NoDev && !mnt.Options.NoDev,
+		opts.NoExec && !mnt.Options.NoExec:
+		return fmt.Errorf("dev mount options %+v not applied (has %+v)", opts, mnt.Options)
+	}
+	return nil
+}
+
+// ReadBindOptions returns the attributes the topmost mount on mountPoint was
+// made with.
+func (d *Dev) ReadBindOptions(mountPoint string) (BindOptions, error) {
+	mnt, ok, err := d.topMount(mountPoint)
+	switch {
+	case err != nil:
+		return BindOptions{}, err
+	case !ok:
+		return BindOptions{}, devNotMounted(mountPoint)
+	}
+	return mnt.Options, nil
+}
+
+// VerifySuperOptions checks that the topmost mount on mountPoint was made
+// with every option in want.
+func (d *Dev) VerifySuperOptions(mountPoint string, want []string) error {
+	mnt, ok, err := d.topMount(mountPoint)
+	switch {
+	case err != nil:
+		return err
+	case !ok:
+		return devNotMounted(mountPoint)
+	}
+	applied := SplitOptions(mnt.Data)
+	for _, option := range want {
+		if !slices.Contains(applied, option) {
+			return fmt.Errorf("dev super option %q not applied", option)
+		}
+	}
+	return nil
+}
+
+// IsIDMapped returns false for any mount point.
+func (d *Dev) IsIDMapped(mountPoint string) (bool, error) {
+	_, ok, err := d.topMount(mountPoint)
+	switch {
+	case err != nil:
+		return false, err
+	case !ok:
+		return false, devNotMounted(mountPoint)
+	}
+	return false, nil
+}
+
+// VerifyPropagation checks that the propagation type recorded for the
+// topmost mount on mountPoint is p.
+func (d *Dev) VerifyPropagation(mountPoint string, p Propagation) error {
+	mnt, ok, err := d.topMount(mountPoint)
+	switch {
+	case err != nil:
+		return err
+	case !ok:
+		return devNotMounted(mountPoint)
+	case mnt.Propagation != p:
+		return fmt.Errorf("dev mount propagation is %q, not %q", mnt.Propagation, p)
+	}
+	return nil
+}
+
+// IsMountedIn calls IsMountPoint. The mounts exist only in the state file,
+// which every process sees.
+func (d *Dev) IsMountedIn(_ int, mountPoint string) (bool, error) {
+	return d.IsMountPoint(mountPoint)
+}
+
+// SharesMountNamespace returns true for any process.
+func (d *Dev) SharesMountNamespace(int) (bool, error) { return true, nil }
+
+// SELinuxEnabled returns false; labels are left alone in development.
+func (d *Dev) SELinuxEnabled() bool { return false }
+
+// GetSELinuxLabel calls GetSELinuxLabel.
+func (d *Dev) GetSELinuxLabel(path string) (string, error) { return GetSELinuxLabel(path) }
+
+// SetSELinuxLabel fails; labels are left alone in development.
+func (d *Dev) SetSELinuxLabel(string, string) error {
+	return errors.New("SELinux labels are not supported by the dev mounter")
+}
+
+// mount records mnt on mountPoint and puts the symlink to its target in
+// place.
+func (d *Dev) mount(mountPoint string, mnt devMount) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	state, err := d.load()
+	if err != nil {
+		return err
+	}
+	mountPoint, err = filepath.Abs(mountPoint)
+	if err != nil {
+		return err
+	}
+	state.NextID++
+	mnt.ID = state.NextID
+	mnt.MountPoint = mountPoint
+
+	if state.top(mountPoint) >= 0 {
+		err = replaceSymlink(mnt.Target, mountPoint)
+	} else {
+		mnt.Covered, err = d.cover(mountPoint, mnt.ID)
+		if err == nil {
+			if err = os.Symlink(mnt.Target, mountPoint); err != nil {
+				_ = uncover(mountPoint, mnt.Covered)
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("dev mount onto %q: %w", mountPoint, err)
+	}
+
+	state.Mounts = append(state.Mounts, mnt)
+	return d.save(state)
+}
+
+// cover gets the original mount point out of the way of the symlink. Empty
+// mount points, the usual case, are removed and re-created on unmount;
+// anything else is moved under the scratch root.
+func (d *Dev) cover(mountPoint string, id int) (*devCovered, error) {
+	info, err := os.Lstat(mountPoint)
+	if err != nil {
+		return nil, err
+	}
+	covered := &devCovered{Mode: info.Mode()}
+	if info.IsDir() || (info.Mode().IsRegular() && info.Size() == 0) {
+		if err := os.Remove(mountPoint); err == nil {
+			return covered, nil
+		}
+	}
+	covered.Path = filepath.Join(d.root, "covered", strconv.Itoa(id))
+	if err := os.MkdirAll(filepath.Dir(covered.Path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(mountPoint, covered.Path); err != nil {
+		return nil, err
+	}
+	return covered, nil
+}
+
+// uncover puts the original mount point back in place of the symlink.
+func uncover(mountPoint string, covered *devCovered) error {
+	if err := os.Remove(mountPoint); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	switch {
+	case covered == nil:
+		return nil
+	case covered.Path != "":
+		return os.Rename(covered.Path, mountPoint)
+	case covered.Mode.IsDir():
+		if err := os.Mkdir(mountPoint, covered.Mode.Perm()); err != nil {
+			return err
+		}
+	default:
+		f, err := os.OpenFile(mountPoint, os.O_CREATE|os.O_EXCL|os.O_WRONLY, covered.Mode.Perm())
+		if err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return os.Chmod(mountPoint, covered.Mode.Perm())
+}
+
+// replaceSymlink atomically points the symlink at path to target.
+func replaceSymlink(target, path string) error {
+	tmp := path + ".dev-mount"
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (d *Dev) topMount(mountPoint string) (devMount, bool, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	state, err := d.load()
+	if err != nil {
+		return devMount{}, false, err
+	}
+	mountPoint, err = filepath.Abs(mountPoint)
+	if err != nil {
+		return devMount{}, false, err
+	}
+	i := state.top(mountPoint)
+	if i < 0 {
+		return devMount{}, false, nil
+	}
+	return state.Mounts[i], true, nil
+}
+
+func (d *Dev) load() (*devState, error) {
+	state := new(devState)
+	data, err := os.ReadFile(filepath.Join(d.root, devStateFile))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return state, nil
+	case err != nil:
+		return nil, fmt.Errorf("unable to read dev mounter state: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("unable to parse dev mounter state: %w", err)
+	}
+	return state, nil
+}
+
+func (d *Dev) save(state *devState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(d.root, devStateFile)
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return fmt.Errorf("unable to write dev mounter state: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("unable to write dev mounter state: %w", err)
+	}
+	return nil
+}
+
+// top returns the index of the topmost mount on mountPoint, or -1.
+func (s *devState) top(mountPoint string) int {
+	for i := len(s.Mounts) - 1; i >= 0; i-- {
+		if s.Mounts[i].MountPoint == mountPoint {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m devMount) info() MountInfo {
+	info := MountInfo{
+		ID:         m.ID,
+		Root:       m.Source,
+		MountPoint: m.MountPoint,
+		FSType:     m.FSType,
+		Source:     m.Source,
+	}
+	if m.FSType == "tmpfs" {
+		info.Root = "/"
+		info.SuperOptions = SplitOptions(m.Data)
+	}
+	return info
+}
+
+func devNotMounted(mountPoint string) error {
+	return fmt.Errorf("dev: %q is not a mount point: %w", mountPoint, syscall.EINVAL)
+}
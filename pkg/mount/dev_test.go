@@ -0,0 +1,126 @@
+package mount
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDev(t *testing.T) {
+	src := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(src, "file"), []byte("src"), 0600))
+	mountPoint := filepath.Join(t.TempDir(), "mount-point")
+	require.NoError(t, os.Mkdir(mountPoint, 0750))
+	scratchDir := t.TempDir()
+
+	d, err := NewDev(scratchDir)
+	require.NoError(t, err)
+
+	opts := BindOptions{ReadOnly: true, NoExec: true}
+	require.NoError(t, d.BindMount(src, mountPoint, opts))
+	data, err := os.ReadFile(filepath.Join(mountPoint, "file"))
+	require.NoError(t, err)
+	assert.Equal(t, "src", string(data))
+
+	require.NoError(t, d.VerifyBindOptions(mountPoint, opts))
+	assert.Error(t, d.VerifyBindOptions(mountPoint, BindOptions{NoDev: true}))
+	require.NoError(t, d.SetPropagation(mountPoint, PropagationPrivate))
+	require.NoError(t, d.VerifyPropagation(mountPoint, PropagationPrivate))
+	same, err := d.SameFile(src, mountPoint)
+	require.NoError(t, err)
+	assert.True(t, same)
+
+	// The mounts survive a restart.
+	d, err = NewDev(scratchDir)
+	require.NoError(t, err)
+	ok, err := d.IsMountPoint(mountPoint)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = d.IsBindMountOf(mountPoint, src)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	infos, err := d.BindMountsOf(src)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, mountPoint, infos[0].MountPoint)
+	readOpts, err := d.ReadBindOptions(mountPoint)
+	require.NoError(t, err)
+	assert.Equal(t, opts, readOpts)
+
+	// Mounts stack.
+	other := t.TempDir()
+	require.NoError(t, d.BindMountRW(other, mountPoint))
+	ok, err = d.IsBindMountOf(mountPoint, other)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	require.NoError(t, d.Unmount(mountPoint))
+	ok, err = d.IsBindMountOf(mountPoint, src)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// The original mount point is back after the last unmount.
+	require.NoError(t, d.Unmount(mountPoint))
+	info, err := os.Lstat(mountPoint)
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	assert.Equal(t, os.FileMode(0750), info.Mode().Perm())
+	ok, err = d.IsMountPoint(mountPoint)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.ErrorIs(t, d.Unmount(mountPoint), syscall.EINVAL)
+
+	// The source is left alone.
+	_, err = os.Stat(filepath.Join(src, "file"))
+	require.NoError(t, err)
+}
+
+func TestDevTmpfs(t *testing.T) {
+	src := t.TempDir()
+	mountPoint := filepath.Join(t.TempDir(), "mount-point")
+	require.NoError(t, os.Mkdir(mountPoint, 0750))
+	d, err := NewDev(t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, d.MountTmpfs(mountPoint, "size=1m,mode=0755"))
+	require.NoError(t, d.VerifySuperOptions(mountPoint, []string{"mode=0755"}))
+	assert.Error(t, d.VerifySuperOptions(mountPoint, []string{"mode=0700"}))
+	info, ok, err := d.GetMount(mountPoint)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "tmpfs", info.FSType)
+	stat, err := os.Stat(mountPoint)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), stat.Mode().Perm())
+
+	inner := filepath.Join(mountPoint, "inner")
+	require.NoError(t, os.WriteFile(filepath.Join(mountPoint, "file"), nil, 0600))
+	require.NoError(t, os.Mkdir(inner, 0755))
+	require.NoError(t, d.BindMountRW(src, inner))
+
+	// The tmpfs is busy until the mount inside it is gone.
+	assert.ErrorIs(t, d.Unmount(mountPoint), syscall.EBUSY)
+	require.NoError(t, d.Unmount(inner))
+	require.NoError(t, d.Unmount(mountPoint))
+
+	// The contents of the tmpfs go with it.
+	entries, err := os.ReadDir(mountPoint)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestDevMismatchedBind(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(file, nil, 0600))
+	d, err := NewDev(t.TempDir())
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, d.BindMountRW(dir, file), syscall.ENOTDIR)
+	assert.ErrorIs(t, d.BindMountRW(file, dir), syscall.EISDIR)
+	assert.ErrorIs(t, d.MountTmpfs(file, ""), syscall.ENOTDIR)
+	assert.ErrorIs(t, d.BindMountIDMapped(dir, dir, BindOptions{}, IDMap{}), ErrIDMapUnsupported)
+}
@@ -0,0 +1,598 @@
+// Package fake provides an in-memory mount.Mounter for tests.
+package fake
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+
+	"github.com/spiffe/spiffe-csi/pkg/mount"
+)
+
+// Op names a Mounter method, for recording calls and programming failures.
+type Op string
+
+// The operations of the Mounter, one per method of mount.Mounter.
+const (
+	OpBindMountRW          Op = "BindMountRW"
+	OpBindMount            Op = "BindMount"
+	OpBindMountIDMapped    Op = "BindMountIDMapped"
+	OpMountTmpfs           Op = "MountTmpfs"
+	OpUnmount              Op = "Unmount"
+	OpUnmountWithOptions   Op = "UnmountWithOptions"
+	OpSetPropagation       Op = "SetPropagation"
+	OpIsMountPoint         Op = "IsMountPoint"
+	OpGetMount             Op = "GetMount"
+	OpIsBindMountOf        Op = "IsBindMountOf"
+	OpBindMountsOf         Op = "BindMountsOf"
+	OpSameFile             Op = "SameFile"
+	OpVerifyBindOptions    Op = "VerifyBindOptions"
+	OpReadBindOptions      Op = "ReadBindOptions"
+	OpVerifySuperOptions   Op = "VerifySuperOptions"
+	OpIsIDMapped           Op = "IsIDMapped"
+	OpVerifyPropagation    Op = "VerifyPropagation"
+	OpIsMountedIn          Op = "IsMountedIn"
+	OpSharesMountNamespace Op = "SharesMountNamespace"
+	OpSELinuxEnabled       Op = "SELinuxEnabled"
+	OpGetSELinuxLabel      Op = "GetSELinuxLabel"
+	OpSetSELinuxLabel      Op = "SetSELinuxLabel"
+)
+
+// TmpfsSource is the Source of the Mounts made by MountTmpfs.
+const TmpfsSource = "tmpfs"
+
+// UnlabeledSELinuxLabel is the SELinux label of existing files that were
+// never labeled through the Mounter.
+const UnlabeledSELinuxLabel = "system_u:object_r:unlabeled_t:s0"
+
+// ErrInjected is an error for FailOn to inject.
+var ErrInjected = errors.New("injected failure")
+
+// Mount is a fake mount.
+type Mount struct {
+	// ID is assigned in the order mounts are made.
+	ID int
+
+	// Source is the bind mount source, or TmpfsSource.
+	Source string
+
+	// Data is the data the tmpfs was mounted with.
+	Data string
+
+	// Options are the bind mount attributes.
+	Options mount.BindOptions
+
+	// IDMap is set on ID-mapped mounts.
+	IDMap *mount.IDMap
+
+	// Propagation is the propagation type last set on the mount.
+	Propagation mount.Propagation
+}
+
+// Call is a recorded call to the Mounter.
+type Call struct {
+	Op Op
+
+	// Args are the arguments of the call, in order.
+	Args []any
+}
+
+// Mounter is an in-memory mount.Mounter. Mounts are kept per mount point
+// and stack like real ones. As with real mounts, mount points have to exist
+// and bind mounts only go from a directory onto a directory and from a file
+// onto a file, but the filesystem itself is left untouched, except that the
+// contents of a tmpfs disappear with it. Every call is recorded, and any
+// operation can be made to fail.
+//
+// Files are the same file if a bind mount of one sits on the other. SELinux
+// labels are kept in memory too. SELinux is reported as enabled, and the
+// mounts as present in every other mount namespace, until changed.
+type Mounter struct {
+	mtx             sync.Mutex
+	nextID          int
+	mounts          map[string][]Mount
+	calls           []Call
+	failures        []failure
+	labels          map[string]string
+	seLinuxDisabled bool
+	sharesNamespace bool
+	notPropagated   bool
+}
+
+type failure struct {
+	op   Op
+	path string
+	err  error
+}
+
+var _ mount.Mounter = (*Mounter)(nil)
+
+// New returns a Mounter without any mounts.
+func New() *Mounter {
+	return &Mounter{
+		mounts: make(map[string][]Mount),
+		labels: make(map[string]string),
+	}
+}
+
+// FailOn makes op fail with err for path, the first path argument of the
+// operation, or for any path if path is empty.
+func (m *Mounter) FailOn(op Op, path string, err error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.failures = append(m.failures, failure{op: op, path: path, err: err})
+}
+
+// SetSELinuxEnabled sets whether SELinux is reported as enabled.
+func (m *Mounter) SetSELinuxEnabled(enabled bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.seLinuxDisabled = !enabled
+}
+
+// SetSharesMountNamespace sets whether other processes are reported to be
+// in the mount namespace of the current one.
+func (m *Mounter) SetSharesMountNamespace(shares bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.sharesNamespace = shares
+}
+
+// SetMountsPropagate sets whether the mounts are reported as present in the
+// mount namespaces of other processes.
+func (m *Mounter) SetMountsPropagate(propagate bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.notPropagated = !propagate
+}
+
+// AddMount stacks mnt on mountPoint without any checks, e.g. to set up a
+// mount made by someone else. The ID is assigned.
+func (m *Mounter) AddMount(mountPoint string, mnt Mount) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.push(mountPoint, mnt)
+}
+
+// RemoveMount removes the topmost mount on mountPoint without unmounting it,
+// e.g. to simulate it going away behind the back of the driver.
+func (m *Mounter) RemoveMount(mountPoint string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.pop(mountPoint)
+}
+
+// Mounts returns the mounts on mountPoint, from the bottom to the top.
+func (m *Mounter) Mounts(mountPoint string) []Mount {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return slices.Clone(m.mounts[filepath.Clean(mountPoint)])
+}
+
+// TopMount returns the topmost mount on mountPoint.
+func (m *Mounter) TopMount(mountPoint string) (Mount, bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.top(mountPoint)
+}
+
+// Calls returns the recorded calls of the given operations, or of every
+// operation if none are given.
+func (m *Mounter) Calls(ops ...Op) []Call {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	var calls []Call
+	for _, call := range m.calls {
+		if len(ops) == 0 || slices.Contains(ops, call.Op) {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// BindMountRW implements mount.Mounter.
+func (m *Mounter) BindMountRW(root, mountPoint string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.call(OpBindMountRW, mountPoint, root, mountPoint); err != nil {
+		return err
+	}
+	return m.bind(root, mountPoint, Mount{})
+}
+
+// BindMount implements mount.Mounter.
+func (m *Mounter) BindMount(root, mountPoint string, opts mount.BindOptions) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.call(OpBindMount, mountPoint, root, mountPoint, opts); err != nil {
+		return err
+	}
+	return m.bind(root, mountPoint, Mount{Options: opts})
+}
+
+// BindMountIDMapped implements mount.Mounter.
+func (m *Mounter) BindMountIDMapped(root, mountPoint string, opts mount.BindOptions, idmap mount.IDMap) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.call(OpBindMountIDMapped, mountPoint, root, mountPoint, opts, idmap); err != nil {
+		return err
+	}
+	return m.bind(root, mountPoint, Mount{Options: opts, IDMap: &idmap})
+}
+
+// MountTmpfs implements mount.Mounter.
+func (m *Mounter) MountTmpfs(mountPoint, data string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.call(OpMountTmpfs, mountPoint, mountPoint, data); err != nil {
+		return err
+	}
+	info, err := os.Stat(mountPoint)
+	switch {
+	case err != nil:
+		return err
+	case !info.IsDir():
+		return fmt.Errorf("fake tmpfs mount onto %q: %w", mountPoint, syscall.ENOTDIR)
+	}
+	m.push(mountPoint, Mount{Source: TmpfsSource, Data: data})
+	return nil
+}
+
+// Unmount implements mount.Mounter.
+func (m *Mounter) Unmount(mountPoint string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.call(OpUnmount, mountPoint, mountPoint); err != nil {
+		return err
+	}
+	return m.unmount(mountPoint)
+}
+
+// UnmountWithOptions implements mount.Mounter. The unmount takes a single
+// attempt.
+func (m *Mounter) UnmountWithOptions(mountPoint string, opts mount.UnmountOptions) (mount.UnmountResult, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.call(OpUnmountWithOptions, mountPoint, mountPoint, opts); err != nil {
+		return mount.UnmountResult{Attempts: 1}, err
+	}
+	return mount.UnmountResult{Attempts: 1}, m.unmount(mountPoint)
+}
+
+// SetPropagation implements mount.Mounter.
+func (m *Mounter) SetPropagation(mountPoint string, p mount.Propagation) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.call(OpSetPropagation, mountPoint, mountPoint, p); err != nil {
+		return err
+	}
+	stack := m.mounts[filepath.Clean(mountPoint)]
+	if len(stack) == 0 {
+		return notMounted(mountPoint)
+	}
+	stack[len(stack)-1].Propagation = p
+	return nil
+}
+
+// IsMountPoint implements mount.Mounter.
+func (m *Mounter) IsMountPoint(mountPoint string) (bool, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.call(OpIsMountPoint, mountPoint, mountPoint); err != nil {
+		return false, err
+	}
+	_, ok := m.top(mountPoint)
+	return ok, nil
+}
+
+// GetMount implements mount.Mounter.
+func (m *Mounter) GetMount(mountPoint string) (mount.MountInfo, bool, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.call(OpGetMount, mountPoint, mountPoint); err != nil {
+		return mount.MountInfo{}, false, err
+	}
+	mnt, ok := m.top(mountPoint)
+	if !ok {
+		return mount.MountInfo{}, false, nil
+	}
+	return mountInfo(filepath.Clean(mountPoint), mnt), true, nil
+}
+
+// IsBindMountOf implements mount.Mounter.
+func (m *Mounter) IsBindMountOf(mountPoint, source string) (bool, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.call(OpIsBindMountOf, mountPoint, mountPoint, source); err != nil {
+		return false, err
+	}
+	mnt, ok := m.top(mountPoint)
+	return ok && mnt.Source == filepath.Clean(source), nil
+}
+
+// BindMountsOf implements mount.Mounter.
+func (m *Mounter) BindMountsOf(source string) ([]mount.MountInfo, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.call(OpBindMountsOf, source, source); err != nil {
+		return nil, err
+	}
+	source = filepath.Clean(source)
+	var infos []mount.MountInfo
+	for mountPoint, stack := range m.mounts {
+		for _, mnt := range stack {
+			if mnt.Source == source && mountPoint != source {
+				infos = append(infos, mountInfo(mountPoint, mnt))
+			}
+		}
+	}
+	slices.SortFunc(infos, func(a, b mount.MountInfo) int { return a.ID - b.ID })
+	return infos, nil
+}
+
+// SameFile implements mount.Mounter. A bind mount on either path makes it
+// the same file as the source of the bind mount.
+func (m *Mounter) SameFile(a, b string) (bool, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.call(OpSameFile, a, a, b); err != nil {
+		return false, err
+	}
+	if mnt, ok := m.top(b); ok {
+		return mnt.Source == filepath.Clean(a), nil
+	}
+	if mnt, ok := m.top(a); ok {
+		return mnt.Source == filepath.Clean(b), nil
+	}
+	return mount.SameFile(a, b)
+}
+
+// VerifyBindOptions implements mount.Mounter.
+func (m *Mounter) VerifyBindOptions(mountPoint string, opts mount.BindOptions) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.call(OpVerifyBindOptions, mountPoint, mountPoint, opts); err != nil {
+		return err
+	}
+	mnt, ok := m.top(mountPoint)
+	switch {
+	case !ok:
+		return notMounted(mountPoint)
+	case opts.ReadOnly && !mnt.Options.ReadOnly,
+		opts.NoSuid && !mnt.Options.NoSuid,
+		opts.NoDev && !mnt.Options.NoDev,
+		opts.NoExec && !mnt.Options.NoExec:
+		return fmt.Errorf("fake mount options %+v not applied (has %+v)", opts, mnt.Options)
+	}
+	return nil
+}
+
+// ReadBindOptions implements mount.Mounter.
+func (m *Mounter) ReadBindOptions(mountPoint string) (mount.BindOptions, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.call(OpReadBindOptions, mountPoint, mountPoint); err != nil {
+		return mount.BindOptions{}, err
+	}
+	mnt, ok := m.top(mountPoint)
+	if !ok {
+		return mount.BindOptions{}, notMounted(mountPoint)
+	}
+	return mnt.Options, nil
+}
+
+// VerifySuperOptions implements mount.Mounter.
+func (m *Mounter) VerifySuperOptions(mountPoint string, want []string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.call(OpVerifySuperOptions, mountPoint, mountPoint, want); err != nil {
+		return err
+	}
+	mnt, ok := m.top(mountPoint)
+	if !ok {
+		return notMounted(mountPoint)
+	}
+	applied := mount.SplitOptions(mnt.Data)
+	for _, option := range want {
+		if !slices.Contains(applied, option) {
+			return fmt.Errorf("fake super option %q not applied", option)
+		}
+	}
+	return nil
+}
+
+// IsIDMapped implements mount.Mounter.
+func (m *Mounter) IsIDMapped(mountPoint string) (bool, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.call(OpIsIDMapped, mountPoint, mountPoint); err != nil {
+		return false, err
+	}
+	mnt, ok := m.top(mountPoint)
+	if !ok {
+		return false, notMounted(mountPoint)
+	}
+	return mnt.IDMap != nil, nil
+}
+
+// VerifyPropagation implements mount.Mounter.
+func (m *Mounter) VerifyPropagation(mountPoint string, p mount.Propagation) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.call(OpVerifyPropagation, mountPoint, mountPoint, p); err != nil {
+		return err
+	}
+	mnt, ok := m.top(mountPoint)
+	switch {
+	case !ok:
+		return notMounted(mountPoint)
+	case mnt.Propagation != p:
+		return fmt.Errorf("fake mount propagation is %q, not %q", mnt.Propagation, p)
+	}
+	return nil
+}
+
+// IsMountedIn implements mount.Mounter.
+func (m *Mounter) IsMountedIn(pid int, mountPoint string) (bool, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.call(OpIsMountedIn, mountPoint, pid, mountPoint); err != nil {
+		return false, err
+	}
+	_, ok := m.top(mountPoint)
+	return ok && !m.notPropagated, nil
+}
+
+// SharesMountNamespace implements mount.Mounter.
+func (m *Mounter) SharesMountNamespace(pid int) (bool, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.call(OpSharesMountNamespace, "", pid); err != nil {
+		return false, err
+	}
+	return m.sharesNamespace, nil
+}
+
+// SELinuxEnabled implements mount.Mounter.
+func (m *Mounter) SELinuxEnabled() bool {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	_ = m.call(OpSELinuxEnabled, "")
+	return !m.seLinuxDisabled
+}
+
+// GetSELinuxLabel implements mount.Mounter. Existing files that were never
+// labeled have the UnlabeledSELinuxLabel.
+func (m *Mounter) GetSELinuxLabel(path string) (string, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.call(OpGetSELinuxLabel, path, path); err != nil {
+		return "", err
+	}
+	if _, err := os.Lstat(path); err != nil {
+		return "", err
+	}
+	if label, ok := m.labels[filepath.Clean(path)]; ok {
+		return label, nil
+	}
+	return UnlabeledSELinuxLabel, nil
+}
+
+// SetSELinuxLabel implements mount.Mounter.
+func (m *Mounter) SetSELinuxLabel(path, label string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.call(OpSetSELinuxLabel, path, path, label); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(path); err != nil {
+		return err
+	}
+	m.labels[filepath.Clean(path)] = label
+	return nil
+}
+
+// call records a call and returns the failure programmed for it, if any.
+func (m *Mounter) call(op Op, path string, args ...any) error {
+	m.calls = append(m.calls, Call{Op: op, Args: args})
+	for _, f := range m.failures {
+		if f.op == op && (f.path == "" || filepath.Clean(f.path) == filepath.Clean(path)) {
+			return f.err
+		}
+	}
+	return nil
+}
+
+func (m *Mounter) bind(root, mountPoint string, mnt Mount) error {
+	rootInfo, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	mountPointInfo, err := os.Stat(mountPoint)
+	switch {
+	case err != nil:
+		return err
+	case rootInfo.IsDir() && !mountPointInfo.IsDir():
+		return fmt.Errorf("fake bind mount of %q onto %q: %w", root, mountPoint, syscall.ENOTDIR)
+	case !rootInfo.IsDir() && mountPointInfo.IsDir():
+		return fmt.Errorf("fake bind mount of %q onto %q: %w", root, mountPoint, syscall.EISDIR)
+	}
+	mnt.Source = filepath.Clean(root)
+	m.push(mountPoint, mnt)
+	return nil
+}
+
+func (m *Mounter) unmount(mountPoint string) error {
+	mnt, ok := m.pop(mountPoint)
+	if !ok {
+		return notMounted(mountPoint)
+	}
+	if mnt.Source != TmpfsSource {
+		return nil
+	}
+	// The contents of a tmpfs disappear with it.
+	entries, err := os.ReadDir(mountPoint)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(mountPoint, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Mounter) push(mountPoint string, mnt Mount) {
+	m.nextID++
+	mnt.ID = m.nextID
+	mountPoint = filepath.Clean(mountPoint)
+	m.mounts[mountPoint] = append(m.mounts[mountPoint], mnt)
+}
+
+func (m *Mounter) pop(mountPoint string) (Mount, bool) {
+	mountPoint = filepath.Clean(mountPoint)
+	stack := m.mounts[mountPoint]
+	if len(stack) == 0 {
+		return Mount{}, false
+	}
+	mnt := stack[len(stack)-1]
+	if len(stack) == 1 {
+		delete(m.mounts, mountPoint)
+	} else {
+		m.mounts[mountPoint] = stack[:len(stack)-1]
+	}
+	return mnt, true
+}
+
+func (m *Mounter) top(mountPoint string) (Mount, bool) {
+	stack := m.mounts[filepath.Clean(mountPoint)]
+	if len(stack) == 0 {
+		return Mount{}, false
+	}
+	return stack[len(stack)-1], true
+}
+
+func mountInfo(mountPoint string, mnt Mount) mount.MountInfo {
+	info := mount.MountInfo{
+		ID:         mnt.ID,
+		Root:       mnt.Source,
+		MountPoint: mountPoint,
+		FSType:     "none",
+		Source:     mnt.Source,
+	}
+	if mnt.Source == TmpfsSource {
+		info.Root = "/"
+		info.FSType = "tmpfs"
+		info.SuperOptions = mount.SplitOptions(mnt.Data)
+	}
+	return info
+}
+
+func notMounted(mountPoint string) error {
+	return fmt.Errorf("fake: %q is not a mount point: %w", mountPoint, syscall.EINVAL)
+}
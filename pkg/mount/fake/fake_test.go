@@ -0,0 +1,123 @@
+package fake
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/spiffe/spiffe-csi/pkg/mount"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMounter(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+	m := New()
+
+	opts := mount.BindOptions{ReadOnly: true, NoExec: true}
+	require.NoError(t, m.BindMount(src, dst, opts))
+	require.NoError(t, m.BindMount(src, dst, opts))
+
+	ok, err := m.IsBindMountOf(dst, src)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	require.NoError(t, m.VerifyBindOptions(dst, opts))
+	assert.Error(t, m.VerifyBindOptions(dst, mount.BindOptions{NoDev: true}))
+
+	infos, err := m.BindMountsOf(src)
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	assert.Equal(t, dst, infos[0].MountPoint)
+	assert.Less(t, infos[0].ID, infos[1].ID)
+
+	same, err := m.SameFile(src, dst)
+	require.NoError(t, err)
+	assert.True(t, same)
+
+	// Mounts stack and come off one at a time.
+	require.NoError(t, m.Unmount(dst))
+	assert.Len(t, m.Mounts(dst), 1)
+	require.NoError(t, m.Unmount(dst))
+	assert.Empty(t, m.Mounts(dst))
+	assert.ErrorIs(t, m.Unmount(dst), syscall.EINVAL)
+
+	assert.Len(t, m.Calls(OpBindMount), 2)
+	assert.Len(t, m.Calls(OpUnmount), 3)
+	assert.Equal(t, []any{src, dst, opts}, m.Calls(OpBindMount)[0].Args)
+}
+
+func TestMounterBindMismatch(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(file, nil, 0600))
+	m := New()
+
+	assert.ErrorIs(t, m.BindMountRW(dir, file), syscall.ENOTDIR)
+	assert.ErrorIs(t, m.BindMountRW(file, dir), syscall.EISDIR)
+	assert.Error(t, m.BindMountRW(filepath.Join(dir, "missing"), dir))
+	assert.Empty(t, m.Mounts(dir))
+	assert.Empty(t, m.Mounts(file))
+}
+
+func TestMounterTmpfs(t *testing.T) {
+	dst := t.TempDir()
+	m := New()
+
+	require.NoError(t, m.MountTmpfs(dst, "size=1m"))
+	require.NoError(t, os.WriteFile(filepath.Join(dst, "file"), nil, 0600))
+	require.NoError(t, m.VerifySuperOptions(dst, []string{"size=1m"}))
+	assert.Error(t, m.VerifySuperOptions(dst, []string{"mode=0755"}))
+
+	info, ok, err := m.GetMount(dst)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "tmpfs", info.FSType)
+
+	// The contents of a tmpfs go with it.
+	require.NoError(t, m.Unmount(dst))
+	entries, err := os.ReadDir(dst)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestMounterFailOn(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+	other := t.TempDir()
+	m := New()
+
+	m.FailOn(OpBindMountRW, dst, ErrInjected)
+	assert.ErrorIs(t, m.BindMountRW(src, dst), ErrInjected)
+	assert.Empty(t, m.Mounts(dst))
+	require.NoError(t, m.BindMountRW(src, other))
+
+	m.FailOn(OpIsMountPoint, "", ErrInjected)
+	_, err := m.IsMountPoint(other)
+	assert.ErrorIs(t, err, ErrInjected)
+
+	// Failed calls are recorded too.
+	assert.Len(t, m.Calls(OpBindMountRW), 2)
+}
+
+func TestMounterSELinux(t *testing.T) {
+	path := t.TempDir()
+	m := New()
+
+	assert.True(t, m.SELinuxEnabled())
+	label, err := m.GetSELinuxLabel(path)
+	require.NoError(t, err)
+	assert.Equal(t, UnlabeledSELinuxLabel, label)
+
+	require.NoError(t, m.SetSELinuxLabel(path, "system_u:object_r:container_file_t:s0"))
+	label, err = m.GetSELinuxLabel(path)
+	require.NoError(t, err)
+	assert.Equal(t, "system_u:object_r:container_file_t:s0", label)
+
+	_, err = m.GetSELinuxLabel(filepath.Join(path, "missing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	m.SetSELinuxEnabled(false)
+	assert.False(t, m.SELinuxEnabled())
+}
@@ -0,0 +1,614 @@
+package mount
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// ErrHelperDenied is returned when the mount helper refuses a request that
+// is outside of its policy.
+var ErrHelperDenied = errors.New("denied by the mount helper")
+
+// SelfTestPrefix is the name prefix of the mount points the driver creates
+// in the self-test directory.
+const SelfTestPrefix = ".spiffe-csi-self-test-"
+
+// helperFDEnv names the environment variable that holds the descriptor of
+// the socket a mount helper process serves requests on.
+const helperFDEnv = "SPIFFE_CSI_MOUNT_HELPER_FD"
+
+// helperMaxMessage bounds the size of requests and responses.
+const helperMaxMessage = 64 << 10
+
+// HelperPolicy is what a mount helper performs requests within.
+type HelperPolicy struct {
+	// Beneath is the kubelet root directory. Mount points have to be target
+	// paths of the kubelet below it,
+	// <Beneath>/pods/<pod UID>/volumes/kubernetes.io~csi/<volume>/mount, or
+	// one of InnerNames within such a path. It is required.
+	Beneath string
+
+	// InnerNames are the names of the paths inside a target path that may
+	// be mounted onto.
+	InnerNames []string
+
+	// SelfTestDir, if set, is the directory the driver runs its self-test
+	// in. Until EndSelfTest is called, paths directly within it named with
+	// SelfTestPrefix are accepted as mount points as well.
+	SelfTestDir string
+
+	// Sources are the directories, typically the Workload API socket
+	// directories, that bind mount sources and relabeled paths have to be,
+	// or be within. Paths within them are resolved beneath them like in
+	// OpenBeneath.
+	Sources []string
+
+	// Sockets are the Workload API sockets within Sources that are bind
+	// mounted on their own. Mounts are only unmounted, or have their
+	// propagation changed, if they are bind mounts of one of Sources or
+	// Sockets, or composite volume tmpfs mounts (see TmpfsOptions).
+	Sockets []string
+
+	// TmpfsOptions are the options the tmpfs of a composite volume is
+	// mounted with. A tmpfs with these options is taken for the driver's
+	// own if the helper mounted it or found a bind mount of one of Sources
+	// or Sockets on one of InnerNames inside it. Empty accepts no tmpfs.
+	TmpfsOptions string
+
+	// HostPID is the only PID whose mount namespace may be inspected. Zero
+	// allows none.
+	HostPID int
+}
+
+// IsHelperProcess returns whether the current process was started by
+// StartHelper, in which case it is expected to call ServeHelper.
+func IsHelperProcess() bool {
+	return os.Getenv(helperFDEnv) != ""
+}
+
+// ServeHelper serves the requests of the process that started the current
+// one with StartHelper, performing them through mounter if they are within
+// policy. It returns once that process has gone away.
+func ServeHelper(mounter Mounter, policy HelperPolicy) error {
+	if !filepath.IsAbs(policy.Beneath) {
+		return errors.New("mount helper requires an absolute directory to mount beneath")
+	}
+	fd, err := strconv.Atoi(os.Getenv(helperFDEnv))
+	if err != nil {
+		return fmt.Errorf("invalid mount helper socket %q", os.Getenv(helperFDEnv))
+	}
+	if err := os.Unsetenv(helperFDEnv); err != nil {
+		return err
+	}
+	conn := os.NewFile(uintptr(fd), "mount-helper")
+	defer conn.Close()
+	return serveHelper(conn, mounter, policy)
+}
+
+// Helper is a Mounter that has a mount helper process, running with the
+// privileges the current process gives up, perform every operation that
+// mounts, unmounts, relabels or looks into another mount namespace. The
+// remaining operations only read the mount information of the current
+// process and are performed locally, like with Namespace.
+//
+// Requests are sent one at a time over a socket pair, so a slow operation
+// delays the others.
+type Helper struct {
+	Local
+
+	mtx  sync.Mutex
+	conn *os.File
+
+	done chan struct{}
+	err  error
+}
+
+var _ Mounter = (*Helper)(nil)
+
+// StartHelper starts a mount helper process running the current executable
+// with args and returns the Helper talking to it. The executable has to call
+// ServeHelper when IsHelperProcess returns true.
+func StartHelper(args []string) (*Helper, error) {
+	return startHelper(args)
+}
+
+func newHelper(conn *os.File, cmd *exec.Cmd) *Helper {
+	h := &Helper{conn: conn, done: make(chan struct{})}
+	go func() {
+		if cmd != nil {
+			h.err = cmd.Wait()
+		}
+		close(h.done)
+	}()
+	return h
+}
+
+// Wait waits for the mount helper process to exit and returns its exit
+// status. The helper exits when Close is called or when it fails.
+func (h *Helper) Wait() error {
+	<-h.done
+	return h.err
+}
+
+// Close makes the mount helper process exit.
+func (h *Helper) Close() error {
+	return h.conn.Close()
+}
+
+// BindMountRW bind mounts root onto mountPoint.
+func (h *Helper) BindMountRW(root, mountPoint string) error {
+	return h.BindMount(root, mountPoint, BindOptions{})
+}
+
+// BindMount bind mounts root onto mountPoint with the given options.
+func (h *Helper) BindMount(root, mountPoint string, opts BindOptions) error {
+	_, err := h.call(helperRequest{Op: helperBind, Source: root, MountPoint: mountPoint, BindOptions: opts})
+	return err
+}
+
+// BindMountIDMapped bind mounts root onto mountPoint as an ID-mapped mount.
+func (h *Helper) BindMountIDMapped(root, mountPoint string, opts BindOptions, idmap IDMap) error {
+	_, err := h.call(helperRequest{Op: helperBind, Source: root, MountPoint: mountPoint, BindOptions: opts, IDMap: &idmap})
+	return err
+}
+
+// MountTmpfs mounts a tmpfs onto mountPoint.
+func (h *Helper) MountTmpfs(mountPoint, data string) error {
+	_, err := h.call(helperRequest{Op: helperTmpfs, MountPoint: mountPoint, Data: data})
+	return err
+}
+
+// Unmount unmounts the topmost mount on mountPoint.
+func (h *Helper) Unmount(mountPoint string) error {
+	_, err := h.call(helperRequest{Op: helperUnmount, MountPoint: mountPoint})
+	return err
+}
+
+// UnmountWithOptions unmounts the topmost mount on mountPoint, retrying
+// while it is busy.
+func (h *Helper) UnmountWithOptions(mountPoint string, opts UnmountOptions) (UnmountResult, error) {
+	resp, err := h.call(helperRequest{Op: helperUnmount, MountPoint: mountPoint, UnmountOptions: &opts})
+	return resp.UnmountResult, err
+}
+
+// SetPropagation changes the propagation type of the topmost mount on
+// mountPoint.
+func (h *Helper) SetPropagation(mountPoint string, p Propagation) error {
+	_, err := h.call(helperRequest{Op: helperSetPropagation, MountPoint: mountPoint, Propagation: p})
+	return err
+}
+
+// VerifyPropagation checks that the topmost mount on mountPoint has the
+// propagation type p.
+func (h *Helper) VerifyPropagation(mountPoint string, p Propagation) error {
+	_, err := h.call(helperRequest{Op: helperVerifyPropagation, MountPoint: mountPoint, Propagation: p})
+	return err
+}
+
+// IsMountPoint returns whether mountPoint is a mount point.
+func (h *Helper) IsMountPoint(mountPoint string) (bool, error) {
+	resp, err := h.call(helperRequest{Op: helperIsMountPoint, MountPoint: mountPoint})
+	return resp.OK, err
+}
+
+// IsMountedIn returns whether something is mounted on mountPoint in the
+// mount namespace of the process with the given PID.
+func (h *Helper) IsMountedIn(pid int, mountPoint string) (bool, error) {
+	resp, err := h.call(helperRequest{Op: helperIsMountedIn, PID: pid, MountPoint: mountPoint})
+	return resp.OK, err
+}
+
+// SharesMountNamespace returns whether the process with the given PID is in
+// the mount namespace of the mount helper, which is that of the current
+// process.
+func (h *Helper) SharesMountNamespace(pid int) (bool, error) {
+	resp, err := h.call(helperRequest{Op: helperSharesMountNamespace, PID: pid})
+	return resp.OK, err
+}
+
+// SetSELinuxLabel sets the SELinux label of path.
+func (h *Helper) SetSELinuxLabel(path, label string) error {
+	_, err := h.call(helperRequest{Op: helperSetSELinuxLabel, Source: path, Label: label})
+	return err
+}
+
+// EndSelfTest makes the mount helper refuse self-test mount points from now
+// on. It is meant to be called once the driver has started, before it
+// serves any request.
+func (h *Helper) EndSelfTest() error {
+	_, err := h.call(helperRequest{Op: helperEndSelfTest})
+	return err
+}
+
+func (h *Helper) call(req helperRequest) (helperResponse, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return helperResponse{}, err
+	}
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if _, err := h.conn.Write(b); err != nil {
+		return helperResponse{}, fmt.Errorf("unable to send %s request to the mount helper: %w", req.Op, err)
+	}
+	buf := make([]byte, helperMaxMessage)
+	n, err := h.conn.Read(buf)
+	switch {
+	case errors.Is(err, io.EOF):
+		return helperResponse{}, fmt.Errorf("mount helper exited during %s request", req.Op)
+	case err != nil:
+		return helperResponse{}, fmt.Errorf("unable to receive %s response from the mount helper: %w", req.Op, err)
+	}
+	var resp helperResponse
+	if err := json.Unmarshal(buf[:n], &resp); err != nil {
+		return helperResponse{}, fmt.Errorf("invalid %s response from the mount helper: %w", req.Op, err)
+	}
+	if resp.Error != nil {
+		return resp, resp.Error
+	}
+	return resp, nil
+}
+
+// helperOp is an operation of the mount helper protocol.
+type helperOp string
+
+const (
+	helperBind                 helperOp = "bind"
+	helperTmpfs                helperOp = "tmpfs"
+	helperUnmount              helperOp = "unmount"
+	helperSetPropagation       helperOp = "set-propagation"
+	helperVerifyPropagation    helperOp = "verify-propagation"
+	helperIsMountPoint         helperOp = "ismount"
+	helperIsMountedIn          helperOp = "mounted-in"
+	helperSharesMountNamespace helperOp = "shares-mount-namespace"
+	helperSetSELinuxLabel      helperOp = "set-selinux-label"
+	helperEndSelfTest          helperOp = "end-self-test"
+)
+
+type helperRequest struct {
+	Op             helperOp        `json:"op"`
+	Source         string          `json:"source,omitempty"`
+	MountPoint     string          `json:"mountPoint,omitempty"`
+	BindOptions    BindOptions     `json:"bindOptions"`
+	IDMap          *IDMap          `json:"idmap,omitempty"`
+	Data           string          `json:"data,omitempty"`
+	UnmountOptions *UnmountOptions `json:"unmountOptions,omitempty"`
+	Propagation    Propagation     `json:"propagation,omitempty"`
+	PID            int             `json:"pid,omitempty"`
+	Label          string          `json:"label,omitempty"`
+}
+
+type helperResponse struct {
+	OK            bool          `json:"ok,omitempty"`
+	UnmountResult UnmountResult `json:"unmountResult"`
+	Error         *helperError  `json:"error,omitempty"`
+}
+
+// helperErrorKinds are the errors callers check for that are carried across
+// the helper socket.
+var helperErrorKinds = []struct {
+	name string
+	err  error
+}{
+	{"denied", ErrHelperDenied},
+	{"not-beneath", ErrNotBeneath},
+	{"idmap-unsupported", ErrIDMapUnsupported},
+}
+
+// helperError is an error returned by the mount helper. It matches the
+// errno and the errors of helperErrorKinds that the original error did.
+type helperError struct {
+	Message string        `json:"message"`
+	Errno   syscall.Errno `json:"errno,omitempty"`
+	Kinds   []string      `json:"kinds,omitempty"`
+}
+
+func newHelperError(err error) *helperError {
+	e := &helperError{Message: err.Error()}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		e.Errno = errno
+	}
+	for _, kind := range helperErrorKinds {
+		if errors.Is(err, kind.err) {
+			e.Kinds = append(e.Kinds, kind.name)
+		}
+	}
+	return e
+}
+
+func (e *helperError) Error() string {
+	return e.Message
+}
+
+func (e *helperError) Unwrap() []error {
+	var errs []error
+	if e.Errno != 0 {
+		errs = append(errs, e.Errno)
+	}
+	for _, kind := range helperErrorKinds {
+		for _, name := range e.Kinds {
+			if name == kind.name {
+				errs = append(errs, kind.err)
+			}
+		}
+	}
+	return errs
+}
+
+func serveHelper(conn *os.File, mounter Mounter, policy HelperPolicy) error {
+	server := &helperServer{mounter: mounter, policy: policy, tmpfs: make(map[int]bool)}
+	buf := make([]byte, helperMaxMessage)
+	for {
+		n, err := conn.Read(buf)
+		switch {
+		case errors.Is(err, io.EOF):
+			return nil
+		case err != nil:
+			return fmt.Errorf("unable to receive request: %w", err)
+		}
+		var resp helperResponse
+		var req helperRequest
+		if err := json.Unmarshal(buf[:n], &req); err != nil {
+			resp.Error = newHelperError(fmt.Errorf("invalid request: %w", err))
+		} else {
+			resp = server.handle(req)
+		}
+		b, err := json.Marshal(resp)
+		if err != nil {
+			return err
+		}
+		if _, err := conn.Write(b); err != nil {
+			return fmt.Errorf("unable to send response: %w", err)
+		}
+	}
+}
+
+// helperServer performs the requests of a mount helper process, one at a
+// time.
+type helperServer struct {
+	mounter Mounter
+	policy  HelperPolicy
+
+	// tmpfs holds the IDs of the composite volume tmpfs mounts known to be
+	// the driver's. Once the bind mount inside one is unmounted, this is
+	// all that is left to tell it from any other tmpfs.
+	tmpfs map[int]bool
+
+	// selfTestDone is set once self-test mount points are no longer
+	// accepted.
+	selfTestDone bool
+}
+
+// handle performs req through the mounter if it is within the policy.
+func (s *helperServer) handle(req helperRequest) helperResponse {
+	resp, err := s.perform(req)
+	if err != nil {
+		resp.Error = newHelperError(err)
+	}
+	return resp
+}
+
+func (s *helperServer) perform(req helperRequest) (resp helperResponse, err error) {
+	p, mounter := s.policy, s.mounter
+	var sourceDir string
+	switch req.Op {
+	case helperBind, helperSetSELinuxLabel:
+		if sourceDir, err = p.checkSource(req.Source); err != nil {
+			return resp, err
+		}
+	case helperIsMountedIn, helperSharesMountNamespace:
+		if err := p.checkPID(req.PID); err != nil {
+			return resp, err
+		}
+	}
+	switch req.Op {
+	case helperSetSELinuxLabel, helperSharesMountNamespace, helperEndSelfTest:
+	default:
+		if err := p.checkMountPoint(req.MountPoint, !s.selfTestDone); err != nil {
+			return resp, err
+		}
+	}
+	var ownTmpfs int
+	switch req.Op {
+	case helperUnmount, helperSetPropagation:
+		if ownTmpfs, err = s.checkOwnMount(req.MountPoint); err != nil {
+			return resp, err
+		}
+	}
+
+	switch req.Op {
+	case helperBind:
+		// The source is bound through the magic link to what it resolved
+		// to, like a mount point.
+		return resp, atMountPoint(sourceDir, req.Source, func(source string) error {
+			if req.IDMap != nil {
+				return mounter.BindMountIDMapped(source, req.MountPoint, req.BindOptions, *req.IDMap)
+			}
+			return mounter.BindMount(source, req.MountPoint, req.BindOptions)
+		})
+	case helperTmpfs:
+		if err := mounter.MountTmpfs(req.MountPoint, req.Data); err != nil {
+			return resp, err
+		}
+		if info, ok, err := mounter.GetMount(req.MountPoint); err == nil && ok && s.isCompositeTmpfs(info) {
+			s.tmpfs[info.ID] = true
+		}
+		return resp, nil
+	case helperUnmount:
+		if req.UnmountOptions != nil {
+			resp.UnmountResult, err = mounter.UnmountWithOptions(req.MountPoint, *req.UnmountOptions)
+		} else {
+			err = mounter.Unmount(req.MountPoint)
+		}
+		if err == nil {
+			delete(s.tmpfs, ownTmpfs)
+		}
+		return resp, err
+	case helperSetPropagation:
+		return resp, mounter.SetPropagation(req.MountPoint, req.Propagation)
+	case helperVerifyPropagation:
+		return resp, mounter.VerifyPropagation(req.MountPoint, req.Propagation)
+	case helperIsMountPoint:
+		resp.OK, err = mounter.IsMountPoint(req.MountPoint)
+		return resp, err
+	case helperIsMountedIn:
+		resp.OK, err = mounter.IsMountedIn(req.PID, req.MountPoint)
+		return resp, err
+	case helperSharesMountNamespace:
+		resp.OK, err = mounter.SharesMountNamespace(req.PID)
+		return resp, err
+	case helperSetSELinuxLabel:
+		// Labels are set without following symlinks, so only the parent
+		// directory needs resolving, unless it is the source directory
+		// itself that is relabeled.
+		if filepath.Clean(req.Source) == filepath.Clean(sourceDir) {
+			return resp, mounter.SetSELinuxLabel(sourceDir, req.Label)
+		}
+		return resp, atParent(sourceDir, req.Source, func(path string) error {
+			return mounter.SetSELinuxLabel(path, req.Label)
+		})
+	case helperEndSelfTest:
+		s.selfTestDone = true
+		return resp, nil
+	default:
+		return resp, fmt.Errorf("%w: unknown operation %q", ErrHelperDenied, req.Op)
+	}
+}
+
+// checkOwnMount checks that the topmost mount on mountPoint, as seen by the
+// mounter, is one the driver makes: a bind mount of a source or socket, or a
+// composite volume tmpfs. If it is the latter, its ID is returned. Nothing
+// mounted is let through for the operation to fail on its own.
+//
+// A bind mount on one of the inner names of a target path shows the tmpfs
+// on the target path, if any, to be the driver's, which is noted before the
+// bind mount goes away.
+func (s *helperServer) checkOwnMount(mountPoint string) (int, error) {
+	info, ok, err := s.mounter.GetMount(mountPoint)
+	if err != nil || !ok {
+		return 0, err
+	}
+	if own, err := s.isBindOfSource(mountPoint); err != nil {
+		return 0, err
+	} else if own {
+		if slices.Contains(s.policy.InnerNames, filepath.Base(mountPoint)) {
+			if _, err := s.isOwnTmpfs(filepath.Dir(mountPoint)); err != nil {
+				return 0, err
+			}
+		}
+		return 0, nil
+	}
+	if s.isCompositeTmpfs(info) {
+		if own, err := s.isOwnTmpfs(mountPoint); err != nil {
+			return 0, err
+		} else if own {
+			return info.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q is not a mount made by the driver", ErrHelperDenied, mountPoint)
+}
+
+// isBindOfSource returns whether the topmost mount on mountPoint is a bind
+// mount of one of the sources or sockets.
+func (s *helperServer) isBindOfSource(mountPoint string) (bool, error) {
+	for _, source := range slices.Concat(s.policy.Sources, s.policy.Sockets) {
+		if ok, err := s.mounter.IsBindMountOf(mountPoint, source); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// isOwnTmpfs returns whether the topmost mount on mountPoint is a composite
+// volume tmpfs known to be the driver's, or one with a bind mount of a
+// source or socket inside it, which is then remembered.
+func (s *helperServer) isOwnTmpfs(mountPoint string) (bool, error) {
+	info, ok, err := s.mounter.GetMount(mountPoint)
+	if err != nil || !ok || !s.isCompositeTmpfs(info) {
+		return false, err
+	}
+	if s.tmpfs[info.ID] {
+		return true, nil
+	}
+	for _, name := range s.policy.InnerNames {
+		inner := filepath.Join(mountPoint, name)
+		if ok, err := s.mounter.IsMountPoint(inner); err != nil {
+			return false, err
+		} else if !ok {
+			continue
+		}
+		if own, err := s.isBindOfSource(inner); err != nil {
+			return false, err
+		} else if own {
+			s.tmpfs[info.ID] = true
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isCompositeTmpfs returns whether info is of a tmpfs mounted with the
+// options of a composite volume.
+func (s *helperServer) isCompositeTmpfs(info MountInfo) bool {
+	return s.policy.TmpfsOptions != "" && IsTmpfsWithOptions(info, s.policy.TmpfsOptions)
+}
+
+// checkMountPoint checks that mountPoint is a target path of the kubelet, a
+// path inside one that the layouts mount onto, or, if selfTest is set, a
+// self-test mount point.
+func (p HelperPolicy) checkMountPoint(mountPoint string, selfTest bool) error {
+	rel, err := relBeneath(p.Beneath, mountPoint)
+	if err != nil {
+		return fmt.Errorf("%w: mount point: %w", ErrHelperDenied, err)
+	} else if rel == "." {
+		return fmt.Errorf("%w: mount point %q is the directory itself", ErrHelperDenied, mountPoint)
+	}
+	if selfTest && p.isSelfTestMountPoint(mountPoint) {
+		return nil
+	}
+	parts := strings.Split(rel, "/")
+	isTarget := len(parts) >= 6 && parts[0] == "pods" && parts[2] == "volumes" && parts[3] == "kubernetes.io~csi" && parts[5] == "mount"
+	if isTarget && (len(parts) == 6 || len(parts) == 7 && slices.Contains(p.InnerNames, parts[6])) {
+		return nil
+	}
+	return fmt.Errorf("%w: mount point %q is not a target path of the kubelet or a path the volume layouts mount onto inside one", ErrHelperDenied, mountPoint)
+}
+
+// isSelfTestMountPoint returns whether mountPoint is named like a self-test
+// mount point directly within the self-test directory.
+func (p HelperPolicy) isSelfTestMountPoint(mountPoint string) bool {
+	mountPoint = filepath.Clean(mountPoint)
+	return p.SelfTestDir != "" &&
+		filepath.Dir(mountPoint) == filepath.Clean(p.SelfTestDir) &&
+		strings.HasPrefix(filepath.Base(mountPoint), SelfTestPrefix)
+}
+
+// checkSource checks that source is, or is within, one of the source
+// directories and returns that directory. The check is lexical; source is
+// then resolved beneath the directory for the operation itself.
+func (p HelperPolicy) checkSource(source string) (string, error) {
+	for _, dir := range p.Sources {
+		if _, err := relBeneath(dir, source); err == nil {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q is not within a source directory", ErrHelperDenied, source)
+}
+
+func (p HelperPolicy) checkPID(pid int) error {
+	if p.HostPID == 0 || pid != p.HostPID {
+		return fmt.Errorf("%w: PID %d is not the host PID", ErrHelperDenied, pid)
+	}
+	return nil
+}
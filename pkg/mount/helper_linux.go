@@ -0,0 +1,32 @@
+package mount
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func startHelper(args []string) (*Helper, error) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	conn := os.NewFile(uintptr(fds[0]), "mount-helper")
+	child := os.NewFile(uintptr(fds[1]), "mount-helper")
+	defer child.Close()
+
+	// The socket is the first of ExtraFiles, which start at descriptor 3.
+	cmd := exec.Command("/proc/self/exe", args...)
+	cmd.Env = append(os.Environ(), helperFDEnv+"=3")
+	cmd.ExtraFiles = []*os.File{child}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+	if err := cmd.Start(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newHelper(conn, cmd), nil
+}
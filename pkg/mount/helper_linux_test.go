@@ -0,0 +1,305 @@
+package mount
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// TestMain serves as the mount helper process started by TestStartHelper,
+// with a Dev under the scratch directory, beneath and source directories
+// given as arguments.
+func TestMain(m *testing.M) {
+	if IsHelperProcess() {
+		if err := serveDevHelper(os.Args[1], os.Args[2], os.Args[3]); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func serveDevHelper(scratchDir, beneath, source string) error {
+	d, err := NewDev(scratchDir)
+	if err != nil {
+		return err
+	}
+	return ServeHelper(d, HelperPolicy{Beneath: beneath, Sources: []string{source}})
+}
+
+type helperTest struct {
+	scratchDir string
+	beneath    string
+	source     string
+	mountPoint string
+}
+
+func newHelperTest(t *testing.T) helperTest {
+	dir := t.TempDir()
+	ht := helperTest{
+		scratchDir: filepath.Join(dir, "scratch"),
+		beneath:    filepath.Join(dir, "kubelet"),
+		source:     filepath.Join(dir, "agent"),
+	}
+	ht.mountPoint = filepath.Join(ht.beneath, "pods", "uid", "volumes", "kubernetes.io~csi", "volume", "mount")
+	require.NoError(t, os.MkdirAll(ht.mountPoint, 0755))
+	require.NoError(t, os.Mkdir(ht.source, 0755))
+	return ht
+}
+
+// startHelperInProcess serves a Helper from a goroutine through a Dev.
+func startHelperInProcess(t *testing.T, ht helperTest, policy HelperPolicy) *Helper {
+	d, err := NewDev(ht.scratchDir)
+	require.NoError(t, err)
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, 0)
+	require.NoError(t, err)
+	conn := os.NewFile(uintptr(fds[0]), "client")
+	serverConn := os.NewFile(uintptr(fds[1]), "server")
+
+	served := make(chan error, 1)
+	go func() {
+		defer serverConn.Close()
+		served <- serveHelper(serverConn, d, policy)
+	}()
+	t.Cleanup(func() {
+		assert.NoError(t, conn.Close())
+		assert.NoError(t, <-served)
+	})
+	return newHelper(conn, nil)
+}
+
+// devUnmount unmounts mountPoint through a Dev, bypassing the helper.
+func devUnmount(t *testing.T, ht helperTest, mountPoint string) error {
+	d, err := NewDev(ht.scratchDir)
+	require.NoError(t, err)
+	return d.Unmount(mountPoint)
+}
+
+func TestHelper(t *testing.T) {
+	ht := newHelperTest(t)
+	socket := filepath.Join(ht.source, "agent.sock")
+	h := startHelperInProcess(t, ht, HelperPolicy{
+		Beneath:      ht.beneath,
+		InnerNames:   []string{"agent.sock"},
+		SelfTestDir:  filepath.Join(ht.beneath, "pods"),
+		Sources:      []string{ht.source},
+		Sockets:      []string{socket},
+		TmpfsOptions: "size=1m,mode=0755",
+		HostPID:      1,
+	})
+
+	require.NoError(t, h.BindMount(ht.source, ht.mountPoint, BindOptions{ReadOnly: true}))
+	ok, err := h.IsMountPoint(ht.mountPoint)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	require.NoError(t, h.SetPropagation(ht.mountPoint, PropagationPrivate))
+	require.NoError(t, h.VerifyPropagation(ht.mountPoint, PropagationPrivate))
+	// Reads are performed locally.
+	same, err := h.SameFile(ht.source, ht.mountPoint)
+	require.NoError(t, err)
+	assert.True(t, same)
+
+	result, err := h.UnmountWithOptions(ht.mountPoint, UnmountOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Attempts)
+	ok, err = h.IsMountPoint(ht.mountPoint)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, h.MountTmpfs(ht.mountPoint, "size=1m,mode=0755"))
+	require.NoError(t, h.Unmount(ht.mountPoint))
+
+	// Sockets are bind mounted onto inner names, and self-tests mount
+	// directly within their directory.
+	socketMountPoint := filepath.Join(ht.mountPoint, "agent.sock")
+	require.NoError(t, os.WriteFile(socket, nil, 0600))
+	require.NoError(t, os.WriteFile(socketMountPoint, nil, 0600))
+	require.NoError(t, h.BindMountRW(socket, socketMountPoint))
+	require.NoError(t, h.SetPropagation(socketMountPoint, PropagationPrivate))
+	require.NoError(t, h.Unmount(socketMountPoint))
+	selfTestMountPoint := filepath.Join(ht.beneath, "pods", SelfTestPrefix+"123")
+	require.NoError(t, os.Mkdir(selfTestMountPoint, 0755))
+	require.NoError(t, h.BindMountRW(ht.source, selfTestMountPoint))
+	require.NoError(t, h.Unmount(selfTestMountPoint))
+	// Other directories within the self-test directory, such as those of
+	// pods, are not self-test mount points, and none are once self-tests
+	// are over.
+	podDir := filepath.Join(ht.beneath, "pods", "uid")
+	assert.ErrorIs(t, h.BindMountRW(ht.source, podDir), ErrHelperDenied)
+	require.NoError(t, h.EndSelfTest())
+	assert.ErrorIs(t, h.BindMountRW(ht.source, selfTestMountPoint), ErrHelperDenied)
+
+	// Errors keep what they match.
+	err = h.BindMountRW(ht.source, filepath.Join(filepath.Dir(filepath.Dir(ht.mountPoint)), "missing", "mount"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.ErrorIs(t, err, syscall.ENOENT)
+	assert.NotErrorIs(t, err, ErrHelperDenied)
+}
+
+func TestHelperPolicy(t *testing.T) {
+	ht := newHelperTest(t)
+	h := startHelperInProcess(t, ht, HelperPolicy{
+		Beneath: ht.beneath,
+		Sources: []string{ht.source},
+	})
+
+	for _, tt := range []struct {
+		desc      string
+		call      func() error
+		expectErr string
+	}{
+		{
+			desc:      "source outside of the source directories",
+			call:      func() error { return h.BindMountRW("/etc", ht.mountPoint) },
+			expectErr: `denied by the mount helper: "/etc" is not within a source directory`,
+		},
+		{
+			desc:      "source escaping the source directory",
+			call:      func() error { return h.BindMountRW(ht.source+"/../scratch", ht.mountPoint) },
+			expectErr: "is not within a source directory",
+		},
+		{
+			desc:      "mount point outside of the beneath directory",
+			call:      func() error { return h.BindMountRW(ht.source, "/mnt") },
+			expectErr: `denied by the mount helper: mount point: path is not beneath the directory: "/mnt" is not within`,
+		},
+		{
+			desc:      "mount point onto the beneath directory",
+			call:      func() error { return h.MountTmpfs(ht.beneath, "") },
+			expectErr: "is the directory itself",
+		},
+		{
+			desc:      "relative mount point",
+			call:      func() error { return h.Unmount("pods/uid/volumes/kubernetes.io~csi/volume/mount") },
+			expectErr: "have to be absolute",
+		},
+		{
+			desc:      "mount point outside of the kubelet layout",
+			call:      func() error { return h.BindMountRW(ht.source, filepath.Join(ht.beneath, "pods", "uid", "mount")) },
+			expectErr: "is not a target path of the kubelet",
+		},
+		{
+			desc: "mount point in the volumes of another plugin",
+			call: func() error {
+				return h.MountTmpfs(filepath.Join(ht.beneath, "pods", "uid", "volumes", "kubernetes.io~empty-dir", "volume"), "")
+			},
+			expectErr: "is not a target path of the kubelet",
+		},
+		{
+			desc:      "mount point inside a target path",
+			call:      func() error { return h.BindMountRW(ht.source, filepath.Join(ht.mountPoint, "etc")) },
+			expectErr: "is not a target path of the kubelet",
+		},
+		{
+			desc:      "relabeling outside of the source directories",
+			call:      func() error { return h.SetSELinuxLabel("/etc/shadow", "system_u:object_r:container_file_t:s0") },
+			expectErr: `"/etc/shadow" is not within a source directory`,
+		},
+		{
+			desc: "inspecting a mount namespace without a host PID",
+			call: func() error {
+				_, err := h.IsMountedIn(1, ht.mountPoint)
+				return err
+			},
+			expectErr: "denied by the mount helper: PID 1 is not the host PID",
+		},
+		{
+			desc: "unknown operation",
+			call: func() error {
+				_, err := h.call(helperRequest{Op: "mount", MountPoint: ht.mountPoint})
+				return err
+			},
+			expectErr: `denied by the mount helper: unknown operation "mount"`,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			err := tt.call()
+			assert.ErrorContains(t, err, tt.expectErr)
+			assert.ErrorIs(t, err, ErrHelperDenied)
+		})
+	}
+	assert.ErrorIs(t, h.BindMountRW(ht.source, "/mnt"), ErrNotBeneath)
+
+	// Sources are resolved beneath their directory, so a symlink within it
+	// cannot point elsewhere.
+	require.NoError(t, os.Symlink("/etc", filepath.Join(ht.source, "escape")))
+	assert.ErrorIs(t, h.BindMountRW(filepath.Join(ht.source, "escape"), ht.mountPoint), syscall.ELOOP)
+	assert.ErrorIs(t, h.SetSELinuxLabel(filepath.Join(ht.source, "escape", "shadow"), "system_u:object_r:container_file_t:s0"), syscall.ELOOP)
+
+	ok, err := h.IsMountPoint(ht.mountPoint)
+	require.NoError(t, err)
+	assert.False(t, ok, "denied requests must not mount anything")
+}
+
+func TestHelperForeignMounts(t *testing.T) {
+	ht := newHelperTest(t)
+	policy := HelperPolicy{
+		Beneath:      ht.beneath,
+		InnerNames:   []string{"workload-api"},
+		Sources:      []string{ht.source},
+		TmpfsOptions: "size=1m,mode=0755",
+	}
+	h := startHelperInProcess(t, ht, policy)
+	other := filepath.Join(ht.source, "other")
+	require.NoError(t, os.Mkdir(other, 0755))
+	inner := filepath.Join(ht.mountPoint, "workload-api")
+
+	assertDenied := func(t *testing.T, mountPoint string) {
+		t.Helper()
+		err := h.Unmount(mountPoint)
+		assert.ErrorIs(t, err, ErrHelperDenied)
+		assert.ErrorContains(t, err, "is not a mount made by the driver")
+		assert.ErrorIs(t, h.SetPropagation(mountPoint, PropagationPrivate), ErrHelperDenied)
+		ok, err := h.IsMountPoint(mountPoint)
+		require.NoError(t, err)
+		assert.True(t, ok, "denied requests must not unmount anything")
+	}
+
+	t.Run("bind mount of something within a source", func(t *testing.T) {
+		require.NoError(t, h.BindMountRW(other, ht.mountPoint))
+		assertDenied(t, ht.mountPoint)
+		require.NoError(t, devUnmount(t, ht, ht.mountPoint))
+	})
+
+	t.Run("tmpfs with other options", func(t *testing.T) {
+		require.NoError(t, h.MountTmpfs(ht.mountPoint, "size=1m,mode=0700"))
+		assertDenied(t, ht.mountPoint)
+		require.NoError(t, devUnmount(t, ht, ht.mountPoint))
+	})
+
+	t.Run("composite tmpfs of another helper", func(t *testing.T) {
+		other := startHelperInProcess(t, ht, policy)
+		require.NoError(t, other.MountTmpfs(ht.mountPoint, "size=1m,mode=0755"))
+		assertDenied(t, ht.mountPoint)
+
+		// A bind mount of a source inside shows it to be the driver's,
+		// which is remembered once the bind mount is gone.
+		require.NoError(t, os.Mkdir(inner, 0755))
+		require.NoError(t, other.BindMountRW(ht.source, inner))
+		require.NoError(t, h.Unmount(inner))
+		require.NoError(t, h.Unmount(ht.mountPoint))
+	})
+}
+
+func TestStartHelper(t *testing.T) {
+	ht := newHelperTest(t)
+	h, err := StartHelper([]string{ht.scratchDir, ht.beneath, ht.source})
+	require.NoError(t, err)
+
+	require.NoError(t, h.BindMountRW(ht.source, ht.mountPoint))
+	ok, err := h.IsMountPoint(ht.mountPoint)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.ErrorIs(t, h.BindMountRW("/", ht.mountPoint), ErrHelperDenied)
+	require.NoError(t, h.Unmount(ht.mountPoint))
+
+	require.NoError(t, h.Close())
+	require.NoError(t, h.Wait())
+	_, err = h.IsMountPoint(ht.mountPoint)
+	assert.Error(t, err)
+}
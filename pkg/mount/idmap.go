@@ -0,0 +1,37 @@
+package mount
+
+import "errors"
+
+// ErrIDMapUnsupported is returned when the kernel, or the filesystem being
+// bind mounted, does not support ID-mapped mounts.
+var ErrIDMapUnsupported = errors.New("ID-mapped mounts are not supported by the kernel or filesystem")
+
+// IDMapping maps a range of IDs inside a user namespace onto a range of IDs
+// on the host, like a line of /proc/[pid]/uid_map.
+type IDMapping struct {
+	ContainerID uint32
+	HostID      uint32
+	Size        uint32
+}
+
+// IDMap holds the UID and GID mappings of a user namespace.
+type IDMap struct {
+	UIDs []IDMapping
+	GIDs []IDMapping
+}
+
+// BindMountIDMapped bind mounts root onto mountPoint as an ID-mapped mount
+// using a user namespace with the given mappings, and applies the attributes
+// in opts to the new mount. Files owned by an ID on the host are presented
+// through the mount as owned by the ID it maps to, so a process in a user
+// namespace with the same mappings sees the original owners. ErrIDMapUnsupported
+// is returned (wrapped) if ID-mapped mounts are not available.
+func BindMountIDMapped(root, mountPoint string, opts BindOptions, idmap IDMap) error {
+	return bindMountIDMapped(root, mountPoint, opts, idmap)
+}
+
+// IsIDMapped returns whether the topmost mount on mountPoint is ID-mapped,
+// according to the mount information of the current process.
+func IsIDMapped(mountPoint string) (bool, error) {
+	return isIDMapped(mountPoint)
+}
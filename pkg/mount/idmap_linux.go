@@ -0,0 +1,106 @@
+package mount
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func bindMountIDMapped(root, mountPoint string, opts BindOptions, idmap IDMap) error {
+	return bindMountIDMappedAt("", root, mountPoint, opts, idmap)
+}
+
+// bindMountIDMappedAt is bindMountIDMapped with mountPoint resolved beneath
+// beneath, if set.
+func bindMountIDMappedAt(beneath, root, mountPoint string, opts BindOptions, idmap IDMap) error {
+	fd, err := idmappedTree(root, opts, idmap)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = unix.Close(fd) }()
+	return attachTreeAt(fd, beneath, mountPoint)
+}
+
+// idmappedTree clones the tree at root into a detached mount that is
+// ID-mapped according to idmap and has the attributes requested by opts.
+func idmappedTree(root string, opts BindOptions, idmap IDMap) (int, error) {
+	if len(idmap.UIDs) == 0 || len(idmap.GIDs) == 0 {
+		return -1, errors.New("ID-mapped mount requires both UID and GID mappings")
+	}
+
+	usernsFD, err := userNamespaceFD(idmap)
+	if err != nil {
+		return -1, fmt.Errorf("unable to create user namespace: %w", err)
+	}
+	defer func() { _ = unix.Close(usernsFD) }()
+
+	fd, err := cloneTree(root, &unix.MountAttr{
+		Attr_set:  opts.mountAttrs() | unix.MOUNT_ATTR_IDMAP,
+		Userns_fd: uint64(usernsFD), //nolint:gosec // file descriptors are never negative
+	})
+	// ENOSYS: no mount_setattr. EINVAL: no MOUNT_ATTR_IDMAP, or the
+	// filesystem does not support ID-mapped mounts.
+	if err != nil && (errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EINVAL)) {
+		return -1, fmt.Errorf("%w: %v", ErrIDMapUnsupported, err)
+	}
+	return fd, err
+}
+
+// userNamespaceFD returns a file descriptor for a new user namespace with the
+// given mappings. The user namespace is created by starting a child process
+// in it. The child is stopped by ptrace before it executes anything and is
+// killed once the namespace has been opened; the namespace lives on as long
+// as the descriptor is open.
+func userNamespaceFD(idmap IDMap) (int, error) {
+	// The child is traced by the thread that started it.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	cmd := exec.Command("/proc/self/exe")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER,
+		UidMappings: sysProcIDMap(idmap.UIDs),
+		GidMappings: sysProcIDMap(idmap.GIDs),
+		Ptrace:      true,
+		Pdeathsig:   syscall.SIGKILL,
+	}
+	if err := cmd.Start(); err != nil {
+		return -1, err
+	}
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+
+	fd, err := unix.Open("/proc/"+strconv.Itoa(cmd.Process.Pid)+"/ns/user", unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+	return fd, nil
+}
+
+func sysProcIDMap(mappings []IDMapping) []syscall.SysProcIDMap {
+	idmap := make([]syscall.SysProcIDMap, 0, len(mappings))
+	for _, m := range mappings {
+		idmap = append(idmap, syscall.SysProcIDMap{
+			ContainerID: int(m.ContainerID),
+			HostID:      int(m.HostID),
+			Size:        int(m.Size),
+		})
+	}
+	return idmap
+}
+
+func isIDMapped(mountPoint string) (bool, error) {
+	options, _, err := readMountOptions(mountPoint)
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(options, "idmapped"), nil
+}
@@ -1,11 +1,150 @@
 // Package mount provides filesystem mount operations for the CSI driver.
 package mount
 
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
 // BindMountRW performs a read-write bind mount from root to mountPoint
 func BindMountRW(root, mountPoint string) error {
 	return bindMountRW(root, mountPoint)
 }
 
+// BindOptions are the attributes applied to a bind mount. The zero value
+// results in a plain read-write bind mount.
+type BindOptions struct {
+	// ReadOnly makes the bind mount read-only.
+	ReadOnly bool
+
+	// NoSuid makes the bind mount ignore set-user-ID and set-group-ID bits
+	// and file capabilities.
+	NoSuid bool
+
+	// NoDev prevents access to device files through the bind mount.
+	NoDev bool
+
+	// NoExec prevents executing files through the bind mount.
+	NoExec bool
+}
+
+// HardenedBindOptions returns options for a nosuid, nodev and noexec bind
+// mount, optionally read-only.
+func HardenedBindOptions(readOnly bool) BindOptions {
+	return BindOptions{
+		ReadOnly: readOnly,
+		NoSuid:   true,
+		NoDev:    true,
+		NoExec:   true,
+	}
+}
+
+// BindMount bind mounts root onto mountPoint and applies the attributes in
+// opts to the new mount. The new mount API (open_tree/mount_setattr/
+// move_mount) is used so that the mount only becomes visible once all
+// attributes have been applied. On kernels without it, the mount is made with
+// a plain bind mount followed by a bind remount.
+func BindMount(root, mountPoint string, opts BindOptions) error {
+	return bindMount(root, mountPoint, opts)
+}
+
+// VerifyBindOptions checks, against the mount information of the current
+// process, that the topmost mount on mountPoint has every attribute
+// requested by opts.
+func VerifyBindOptions(mountPoint string, opts BindOptions) error {
+	return verifyBindOptions(mountPoint, opts)
+}
+
+// ReadBindOptions returns the attributes of the topmost mount on mountPoint,
+// according to the mount information of the current process.
+func ReadBindOptions(mountPoint string) (BindOptions, error) {
+	return readBindOptions(mountPoint)
+}
+
+// VerifySuperOptions checks, against the mount information of the current
+// process, that the superblock of the topmost mount on mountPoint has every
+// option in want (e.g. "context=..."). Double quotes around option values are
+// ignored when comparing.
+func VerifySuperOptions(mountPoint string, want []string) error {
+	return verifySuperOptions(mountPoint, want)
+}
+
+// SplitOptions splits a comma-separated list of mount options. Commas inside
+// double quotes do not split, since SELinux contexts can contain commas (e.g.
+// context="system_u:object_r:container_file_t:s0:c1,c2").
+func SplitOptions(s string) []string {
+	var options []string
+	inQuotes := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			inQuotes = !inQuotes
+		case ',':
+			if !inQuotes {
+				options = append(options, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(options, s[start:])
+}
+
+// IsTmpfsWithOptions returns whether info is of a tmpfs mounted with the size
+// and mode given in data (e.g. "size=1m,mode=0755"). The options are compared
+// by value, since the kernel reports them normalized (e.g.
+// "size=1024k,mode=755").
+func IsTmpfsWithOptions(info MountInfo, data string) bool {
+	return info.FSType == "tmpfs" && parseTmpfsOptions(info.SuperOptions) == parseTmpfsOptions(SplitOptions(data))
+}
+
+// tmpfsOptions are the tmpfs options IsTmpfsWithOptions compares.
+type tmpfsOptions struct {
+	size uint64
+	mode uint64
+}
+
+// parseTmpfsOptions parses the size (in bytes) and the mode of the root
+// directory from tmpfs options. What is unset or malformed is left zero.
+func parseTmpfsOptions(options []string) tmpfsOptions {
+	var opts tmpfsOptions
+	for _, option := range options {
+		if value, ok := strings.CutPrefix(option, "size="); ok {
+			var shift uint
+			switch {
+			case strings.HasSuffix(value, "k"):
+				shift = 10
+			case strings.HasSuffix(value, "m"):
+				shift = 20
+			case strings.HasSuffix(value, "g"):
+				shift = 30
+			}
+			if size, err := strconv.ParseUint(strings.TrimRight(value, "kmg"), 10, 64); err == nil {
+				opts.size = size << shift
+			}
+		}
+		if value, ok := strings.CutPrefix(option, "mode="); ok {
+			if mode, err := strconv.ParseUint(value, 8, 32); err == nil {
+				opts.mode = mode
+			}
+		}
+	}
+	return opts
+}
+
+// unquoteOption removes double quotes from an option value.
+func unquoteOption(option string) string {
+	return strings.ReplaceAll(option, `"`, "")
+}
+
+// MountTmpfs mounts a new tmpfs instance on mountPoint with the given
+// filesystem specific options (e.g. "size=1m,mode=0755"). The tmpfs is
+// always mounted nosuid, nodev and noexec.
+func MountTmpfs(mountPoint, data string) error {
+	return mountTmpfs(mountPoint, data)
+}
+
 // Unmount unmounts a mount
 func Unmount(mountPoint string) error {
 	return unmount(mountPoint)
@@ -15,3 +154,17 @@ func Unmount(mountPoint string) error {
 func IsMountPoint(mountPoint string) (bool, error) {
 	return isMountPoint(mountPoint)
 }
+
+// SameFile reports whether a and b refer to the same file, e.g. a bind
+// mounted file and the source of the bind mount.
+func SameFile(a, b string) (bool, error) {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(aInfo, bInfo), nil
+}
@@ -2,11 +2,12 @@ package mount
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"regexp"
-	"strconv"
+	"path/filepath"
+	"slices"
 	"strings"
 
 	"golang.org/x/sys/unix"
@@ -18,24 +19,316 @@ const (
 
 var (
 	// procMountInfo is the path the mount information presented by the proc
-	// filesystem for the current process. It is overridden in unit tests to
-	// test the parsing.
-	procMountInfo = "/proc/self/mountinfo"
+	// filesystem for the current thread, which is in a different mount
+	// namespace than the rest of the process when running for a Namespace.
+	// It is overridden in unit tests to test the parsing.
+	procMountInfo = "/proc/thread-self/mountinfo"
 )
 
-// mountPointIdx is the slice index of the mount point in a parsed mountinfo
-// record. proc(5) "/proc/[pid]/mountinfo" documents it as field 5.
-const mountPointIdx = 4
-
 func bindMountRW(root, mountPoint string) error {
 	return unix.Mount(root, mountPoint, "none", msBind, "")
 }
 
+func bindMount(root, mountPoint string, opts BindOptions) error {
+	return bindMountAt("", root, mountPoint, opts)
+}
+
+// bindMountAt is bindMount with mountPoint resolved beneath beneath, if set.
+// See atMountPoint.
+func bindMountAt(beneath, root, mountPoint string, opts BindOptions) error {
+	attrs := opts.mountAttrs()
+	if attrs == 0 {
+		return atMountPoint(beneath, mountPoint, func(mountPoint string) error {
+			return bindMountRW(root, mountPoint)
+		})
+	}
+
+	err := bindMountSetattr(beneath, root, mountPoint, attrs)
+	if !errors.Is(err, unix.ENOSYS) {
+		return err
+	}
+	if beneath == "" {
+		return bindMountRemount(root, mountPoint, opts)
+	}
+	// The mount point is resolved again for the remount, to get at the bind
+	// mount rather than at the directory underneath it.
+	if err := atMountPoint(beneath, mountPoint, func(mountPoint string) error {
+		return bindMountRW(root, mountPoint)
+	}); err != nil {
+		return err
+	}
+	return atMountPoint(beneath, mountPoint, func(mountPoint string) error {
+		return remountBindOptions(mountPoint, opts)
+	})
+}
+
+// bindMountSetattr clones the tree at root into a detached mount, applies
+// the attributes to it and then attaches it at mountPoint, resolved beneath
+// beneath, if set.
+func bindMountSetattr(beneath, root, mountPoint string, attrs uint64) error {
+	fd, err := cloneTree(root, &unix.MountAttr{Attr_set: attrs})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = unix.Close(fd) }()
+	return attachTreeAt(fd, beneath, mountPoint)
+}
+
+// cloneTree clones the tree at root into a detached mount and applies attr
+// to it, unless attr is nil. Closing the returned file descriptor of a
+// detached mount that was never attached unmounts it.
+func cloneTree(root string, attr *unix.MountAttr) (int, error) {
+	fd, err := unix.OpenTree(unix.AT_FDCWD, root, unix.OPEN_TREE_CLONE|unix.OPEN_TREE_CLOEXEC)
+	if err != nil {
+		return -1, fmt.Errorf("open_tree: %w", err)
+	}
+	if attr != nil {
+		if err := unix.MountSetattr(fd, "", unix.AT_EMPTY_PATH, attr); err != nil {
+			_ = unix.Close(fd)
+			return -1, fmt.Errorf("mount_setattr: %w", err)
+		}
+	}
+	return fd, nil
+}
+
+// attachTree attaches the detached mount fd at mountPoint, which is resolved
+// in the mount namespace of the calling thread.
+func attachTree(fd int, mountPoint string) error {
+	if err := unix.MoveMount(fd, "", unix.AT_FDCWD, mountPoint, unix.MOVE_MOUNT_F_EMPTY_PATH); err != nil {
+		return fmt.Errorf("move_mount: %w", err)
+	}
+	return nil
+}
+
+// bindMountRemount is the fallback for kernels without mount_setattr. The
+// bind mount is visible without the attributes for a brief moment.
+func bindMountRemount(root, mountPoint string, opts BindOptions) error {
+	if err := bindMountRW(root, mountPoint); err != nil {
+		return err
+	}
+	return remountBindOptions(mountPoint, opts)
+}
+
+// remountBindOptions applies opts to the bind mount on mountPoint by
+// remounting it. The bind mount is unmounted if that fails.
+func remountBindOptions(mountPoint string, opts BindOptions) error {
+	if err := unix.Mount("", mountPoint, "", unix.MS_REMOUNT|unix.MS_BIND|opts.mountFlags(), ""); err != nil {
+		if unmountErr := unmount(mountPoint); unmountErr != nil {
+			return fmt.Errorf("unable to remount bind mount: %w (and unable to unmount it: %v)", err, unmountErr)
+		}
+		return fmt.Errorf("unable to remount bind mount: %w", err)
+	}
+	return nil
+}
+
+func (opts BindOptions) mountAttrs() uint64 {
+	var attrs uint64
+	if opts.ReadOnly {
+		attrs |= unix.MOUNT_ATTR_RDONLY
+	}
+	if opts.NoSuid {
+		attrs |= unix.MOUNT_ATTR_NOSUID
+	}
+	if opts.NoDev {
+		attrs |= unix.MOUNT_ATTR_NODEV
+	}
+	if opts.NoExec {
+		attrs |= unix.MOUNT_ATTR_NOEXEC
+	}
+	return attrs
+}
+
+func (opts BindOptions) mountFlags() uintptr {
+	var flags uintptr
+	if opts.ReadOnly {
+		flags |= unix.MS_RDONLY
+	}
+	if opts.NoSuid {
+		flags |= unix.MS_NOSUID
+	}
+	if opts.NoDev {
+		flags |= unix.MS_NODEV
+	}
+	if opts.NoExec {
+		flags |= unix.MS_NOEXEC
+	}
+	return flags
+}
+
+func verifyBindOptions(mountPoint string, opts BindOptions) error {
+	options, _, err := readMountOptions(mountPoint)
+	if err != nil {
+		return err
+	}
+	return checkBindOptions(options, opts)
+}
+
+func readBindOptions(mountPoint string) (BindOptions, error) {
+	options, _, err := readMountOptions(mountPoint)
+	if err != nil {
+		return BindOptions{}, err
+	}
+	return BindOptions{
+		ReadOnly: slices.Contains(options, "ro"),
+		NoSuid:   slices.Contains(options, "nosuid"),
+		NoDev:    slices.Contains(options, "nodev"),
+		NoExec:   slices.Contains(options, "noexec"),
+	}, nil
+}
+
+func verifySuperOptions(mountPoint string, want []string) error {
+	_, superOptions, err := readMountOptions(mountPoint)
+	if err != nil {
+		return err
+	}
+	return checkSuperOptions(superOptions, want)
+}
+
+// readMountOptions returns the per-mount and superblock options of the
+// topmost mount on mountPoint.
+func readMountOptions(mountPoint string) ([]string, []string, error) {
+	f, err := os.Open(procMountInfo)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to open mount info: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	options, superOptions, ok, err := mountOptionsInReader(f, mountPoint)
+	switch {
+	case err != nil:
+		return nil, nil, err
+	case !ok:
+		return nil, nil, fmt.Errorf("%q is not a mount point", mountPoint)
+	}
+	return options, superOptions, nil
+}
+
+// checkBindOptions checks that the per-mount options of a mountinfo record
+// include every attribute requested by opts.
+func checkBindOptions(options []string, opts BindOptions) error {
+	var missing []string
+	for _, want := range []struct {
+		set    bool
+		option string
+	}{
+		{opts.ReadOnly, "ro"},
+		{opts.NoSuid, "nosuid"},
+		{opts.NoDev, "nodev"},
+		{opts.NoExec, "noexec"},
+	} {
+		if want.set && !slices.Contains(options, want.option) {
+			missing = append(missing, want.option)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("mount is missing options %s (has %s)", strings.Join(missing, ","), strings.Join(options, ","))
+	}
+	return nil
+}
+
+// checkSuperOptions checks that the superblock options of a mountinfo record
+// include every option in want.
+func checkSuperOptions(superOptions []string, want []string) error {
+	have := make([]string, 0, len(superOptions))
+	for _, option := range superOptions {
+		have = append(have, unquoteOption(option))
+	}
+	var missing []string
+	for _, option := range want {
+		if !slices.Contains(have, unquoteOption(option)) {
+			missing = append(missing, option)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("mount is missing superblock options %s (has %s)", strings.Join(missing, ","), strings.Join(superOptions, ","))
+	}
+	return nil
+}
+
+// mountOptionsInReader returns the per-mount options (field 6) and the
+// superblock options (the last field) of the last mountinfo record in r for
+// mountPoint. Later records are stacked on top of earlier ones, so the last
+// one is the mount visible at mountPoint.
+func mountOptionsInReader(r io.Reader, mountPoint string) ([]string, []string, bool, error) {
+	infos, err := ParseMountInfo(r)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	info, ok := topmostMount(infos, mountPoint)
+	if !ok {
+		return nil, nil, false, nil
+	}
+	return info.Options, info.SuperOptions, true, nil
+}
+
+func readMountInfo() ([]MountInfo, error) {
+	f, err := os.Open(procMountInfo)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open mount info: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	return ParseMountInfo(f)
+}
+
+func mountTmpfs(mountPoint, data string) error {
+	return unix.Mount("tmpfs", mountPoint, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, data)
+}
+
 func unmount(mountPoint string) error {
 	return unix.Unmount(mountPoint, 0)
 }
 
 func isMountPoint(mountPoint string) (bool, error) {
+	if ok, err := isMountPointStatx(mountPoint); err == nil {
+		return ok, nil
+	}
+	// Old kernels lack statx or the mount ID/root information. Other errors
+	// (e.g. a path that does not exist) also take the slow path so that the
+	// result does not depend on which of the two ran.
+	return isMountPointMountInfo(mountPoint)
+}
+
+// errStatxMountUnsupported is returned by isMountPointStatx when the kernel
+// reports neither the mount root attribute nor mount IDs.
+var errStatxMountUnsupported = errors.New("statx does not report mount information")
+
+// isMountPointStatx determines whether mountPoint is the root of a mount in
+// constant time. The STATX_ATTR_MOUNT_ROOT attribute (Linux 5.8+) answers
+// directly; otherwise the mount ID (STATX_MNT_ID, Linux 5.8+) of the path is
+// compared against that of its parent.
+func isMountPointStatx(mountPoint string) (bool, error) {
+	stx, err := statxMount(mountPoint)
+	if err != nil {
+		return false, err
+	}
+	if stx.Attributes_mask&unix.STATX_ATTR_MOUNT_ROOT != 0 {
+		return stx.Attributes&unix.STATX_ATTR_MOUNT_ROOT != 0, nil
+	}
+	if stx.Mask&unix.STATX_MNT_ID == 0 {
+		return false, errStatxMountUnsupported
+	}
+
+	parent, err := statxMount(filepath.Dir(mountPoint))
+	if err != nil {
+		return false, err
+	}
+	if parent.Mask&unix.STATX_MNT_ID == 0 {
+		return false, errStatxMountUnsupported
+	}
+	return stx.Mnt_id != parent.Mnt_id, nil
+}
+
+func statxMount(path string) (*unix.Statx_t, error) {
+	var stx unix.Statx_t
+	if err := unix.Statx(unix.AT_FDCWD, path, unix.AT_SYMLINK_NOFOLLOW|unix.AT_NO_AUTOMOUNT, unix.STATX_MNT_ID, &stx); err != nil {
+		return nil, err
+	}
+	return &stx, nil
+}
+
+// isMountPointMountInfo determines whether mountPoint is a mount point by
+// scanning the mount information of the current process.
+func isMountPointMountInfo(mountPoint string) (bool, error) {
 	f, err := os.Open(procMountInfo)
 	if err != nil {
 		return false, fmt.Errorf("unable to open mount info: %w", err)
@@ -65,13 +358,3 @@ func isMountPointInReader(r io.Reader, mountPoint string) (bool, error) {
 	}
 	return false, nil
 }
-
-var reOctal = regexp.MustCompile(`\\([0-7]{3})`)
-
-func unescapeOctal(s string) string {
-	return reOctal.ReplaceAllStringFunc(s, func(oct string) string {
-		// cannot fail due to regex constraints
-		r, _ := strconv.ParseUint(oct[1:], 8, 8)
-		return string([]byte{byte(r)})
-	})
-}
@@ -1,13 +1,17 @@
 package mount
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
 )
 
 func init() {
@@ -113,3 +117,283 @@ func BenchmarkIsMountPoint(b *testing.B) {
 		})
 	}
 }
+
+func TestIsMountPointStatx(t *testing.T) {
+	ok, err := isMountPointStatx("/")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = isMountPointStatx("/proc")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	dir := t.TempDir()
+	ok, err = isMountPointStatx(dir)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = isMountPointStatx(filepath.Join(dir, "missing"))
+	require.Error(t, err)
+
+	if os.Geteuid() != 0 {
+		return
+	}
+	useProcMountInfo(t)
+
+	t.Run("bind mounted directory", func(t *testing.T) {
+		mountPoint := t.TempDir()
+		require.NoError(t, BindMountRW(t.TempDir(), mountPoint))
+		t.Cleanup(func() { _ = Unmount(mountPoint) })
+
+		ok, err := isMountPointStatx(mountPoint)
+		require.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("bind mounted file", func(t *testing.T) {
+		dir := t.TempDir()
+		src := filepath.Join(dir, "src")
+		mountPoint := filepath.Join(dir, "dst")
+		require.NoError(t, os.WriteFile(src, nil, 0600))
+		require.NoError(t, os.WriteFile(mountPoint, nil, 0600))
+		require.NoError(t, BindMountRW(src, mountPoint))
+		t.Cleanup(func() { _ = Unmount(mountPoint) })
+
+		ok, err := isMountPointStatx(mountPoint)
+		require.NoError(t, err)
+		assert.True(t, ok)
+
+		ok, err = isMountPointMountInfo(mountPoint)
+		require.NoError(t, err)
+		assert.True(t, ok, "statx and mountinfo should agree")
+	})
+}
+
+// BenchmarkIsMountPointMountInfo measures the mountinfo scan over
+// testdata/mountinfo.
+func BenchmarkIsMountPointMountInfo(b *testing.B) {
+	const mountPoint = "/var/lib/kubelet/pods/c3a32fc0-f186-4974-8579-429dea58ec6d/volumes/kubernetes.io~csi/spire-agent-socket/mount"
+
+	b.ReportAllocs()
+	for b.Loop() {
+		_, _ = isMountPointMountInfo(mountPoint)
+	}
+}
+
+// BenchmarkIsMountPointStatx measures the statx based detection, which does
+// not depend on the number of mounts.
+func BenchmarkIsMountPointStatx(b *testing.B) {
+	dir := b.TempDir()
+
+	b.ReportAllocs()
+	for b.Loop() {
+		_, _ = isMountPointStatx(dir)
+	}
+}
+
+func TestMountOptionsInReader(t *testing.T) {
+	const mountInfo = `36 35 0:0 / /mnt/target rw,relatime - tmpfs tmpfs rw
+37 36 0:0 / /mnt/target ro,nosuid,nodev,noexec,relatime - tmpfs tmpfs rw,context="system_u:object_r:container_file_t:s0:c1,c2"
+38 35 0:0 / /mnt/other rw - tmpfs tmpfs rw
+`
+	options, superOptions, ok, err := mountOptionsInReader(strings.NewReader(mountInfo), "/mnt/target")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"ro", "nosuid", "nodev", "noexec", "relatime"}, options, "the topmost mount should win")
+	assert.Equal(t, []string{"rw", `context="system_u:object_r:container_file_t:s0:c1,c2"`}, superOptions)
+
+	_, _, ok, err = mountOptionsInReader(strings.NewReader(mountInfo), "/mnt/missing")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestCheckBindOptions(t *testing.T) {
+	assert.NoError(t, checkBindOptions([]string{"rw", "relatime"}, BindOptions{}))
+	assert.NoError(t, checkBindOptions([]string{"ro", "nosuid", "nodev", "noexec"}, HardenedBindOptions(true)))
+	assert.NoError(t, checkBindOptions([]string{"rw", "nosuid", "nodev", "noexec"}, HardenedBindOptions(false)))
+	assert.EqualError(t, checkBindOptions([]string{"rw", "nosuid"}, HardenedBindOptions(true)),
+		"mount is missing options ro,nodev,noexec (has rw,nosuid)")
+}
+
+func TestCheckSuperOptions(t *testing.T) {
+	superOptions := []string{"rw", `context="system_u:object_r:container_file_t:s0:c1,c2"`, "size=1024k"}
+	assert.NoError(t, checkSuperOptions(superOptions, nil))
+	assert.NoError(t, checkSuperOptions(superOptions, []string{`context="system_u:object_r:container_file_t:s0:c1,c2"`}))
+	assert.NoError(t, checkSuperOptions(superOptions, []string{"context=system_u:object_r:container_file_t:s0:c1,c2"}))
+	assert.EqualError(t, checkSuperOptions(superOptions, []string{"context=system_u:object_r:container_file_t:s0"}),
+		`mount is missing superblock options context=system_u:object_r:container_file_t:s0 (has rw,context="system_u:object_r:container_file_t:s0:c1,c2",size=1024k)`)
+}
+
+func TestBindMount(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("bind mounting requires root")
+	}
+	useProcMountInfo(t)
+
+	for _, tt := range []struct {
+		desc string
+		opts BindOptions
+	}{
+		{desc: "plain", opts: BindOptions{}},
+		{desc: "hardened", opts: HardenedBindOptions(false)},
+		{desc: "hardened read-only", opts: HardenedBindOptions(true)},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			root := t.TempDir()
+			mountPoint := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(root, "file"), nil, 0600))
+
+			require.NoError(t, BindMount(root, mountPoint, tt.opts))
+			t.Cleanup(func() { _ = Unmount(mountPoint) })
+
+			require.NoError(t, VerifyBindOptions(mountPoint, tt.opts))
+			opts, err := ReadBindOptions(mountPoint)
+			require.NoError(t, err)
+			assert.Equal(t, tt.opts, opts)
+			_, err = os.Stat(filepath.Join(mountPoint, "file"))
+			require.NoError(t, err)
+
+			err = os.WriteFile(filepath.Join(mountPoint, "other"), nil, 0600)
+			if tt.opts.ReadOnly {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+
+	t.Run("remount fallback", func(t *testing.T) {
+		root := t.TempDir()
+		mountPoint := t.TempDir()
+		opts := HardenedBindOptions(true)
+
+		require.NoError(t, bindMountRemount(root, mountPoint, opts))
+		t.Cleanup(func() { _ = Unmount(mountPoint) })
+
+		require.NoError(t, VerifyBindOptions(mountPoint, opts))
+	})
+}
+
+func TestBindMountIDMapped(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("bind mounting requires root")
+	}
+	useProcMountInfo(t)
+
+	root := t.TempDir()
+	mountPoint := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "file"), nil, 0600))
+	require.NoError(t, os.Lchown(filepath.Join(root, "file"), 0, 0))
+
+	opts := HardenedBindOptions(true)
+	err := BindMountIDMapped(root, mountPoint, opts, IDMap{
+		UIDs: []IDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}},
+		GIDs: []IDMapping{{ContainerID: 0, HostID: 200000, Size: 65536}},
+	})
+	if errors.Is(err, ErrIDMapUnsupported) {
+		t.Skipf("ID-mapped mounts are not supported: %v", err)
+	}
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = Unmount(mountPoint) })
+
+	idmapped, err := IsIDMapped(mountPoint)
+	require.NoError(t, err)
+	assert.True(t, idmapped)
+	require.NoError(t, VerifyBindOptions(mountPoint, opts))
+
+	// Root on the host is presented as the host IDs that root inside the
+	// user namespace maps to.
+	info, err := os.Stat(filepath.Join(mountPoint, "file"))
+	require.NoError(t, err)
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	require.True(t, ok)
+	assert.Equal(t, uint32(100000), stat.Uid)
+	assert.Equal(t, uint32(200000), stat.Gid)
+
+	t.Run("plain bind mount is not ID-mapped", func(t *testing.T) {
+		plainMountPoint := t.TempDir()
+		require.NoError(t, BindMountRW(root, plainMountPoint))
+		t.Cleanup(func() { _ = Unmount(plainMountPoint) })
+
+		idmapped, err := IsIDMapped(plainMountPoint)
+		require.NoError(t, err)
+		assert.False(t, idmapped)
+	})
+}
+
+func TestIsBindMountOfLive(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("bind mounting requires root")
+	}
+	useProcMountInfo(t)
+
+	source := t.TempDir()
+	mountPoint := t.TempDir()
+	require.NoError(t, BindMountRW(source, mountPoint))
+	t.Cleanup(func() { _ = Unmount(mountPoint) })
+
+	ok, err := IsBindMountOf(mountPoint, source)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = IsBindMountOf(mountPoint, t.TempDir())
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	info, ok, err := GetMount(mountPoint)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, mountPoint, info.MountPoint)
+
+	t.Run("deleted file", func(t *testing.T) {
+		dir := t.TempDir()
+		src := filepath.Join(dir, "src")
+		dst := filepath.Join(dir, "dst")
+		require.NoError(t, os.WriteFile(src, nil, 0600))
+		require.NoError(t, os.WriteFile(dst, nil, 0600))
+		require.NoError(t, BindMountRW(src, dst))
+		t.Cleanup(func() { _ = Unmount(dst) })
+
+		// Re-create the source, leaving the bind mount on the old inode.
+		require.NoError(t, os.Remove(src))
+		require.NoError(t, os.WriteFile(src, nil, 0600))
+
+		ok, err := IsBindMountOf(dst, src)
+		require.NoError(t, err)
+		assert.True(t, ok)
+	})
+}
+
+func TestSetPropagation(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("mounting requires root")
+	}
+	useProcMountInfo(t)
+
+	for _, p := range []Propagation{PropagationPrivate, PropagationSlave, PropagationUnbindable} {
+		t.Run(string(p), func(t *testing.T) {
+			// Bind mounts under a shared mount start out shared.
+			parent := t.TempDir()
+			require.NoError(t, MountTmpfs(parent, "size=1m"))
+			t.Cleanup(func() { _ = Unmount(parent) })
+			require.NoError(t, unix.Mount("", parent, "", unix.MS_SHARED, ""))
+
+			mountPoint := filepath.Join(parent, "mount")
+			require.NoError(t, os.Mkdir(mountPoint, 0700))
+			require.NoError(t, BindMountRW(t.TempDir(), mountPoint))
+			t.Cleanup(func() { _ = Unmount(mountPoint) })
+			require.Error(t, VerifyPropagation(mountPoint, p))
+
+			require.NoError(t, SetPropagation(mountPoint, p))
+			require.NoError(t, VerifyPropagation(mountPoint, p))
+		})
+	}
+}
+
+// useProcMountInfo points the package at the real mount information of the
+// process for the duration of the test.
+func useProcMountInfo(tb testing.TB) {
+	orig := procMountInfo
+	procMountInfo = "/proc/thread-self/mountinfo"
+	tb.Cleanup(func() { procMountInfo = orig })
+}
@@ -5,12 +5,37 @@ package mount
 
 import (
 	"errors"
+	"os"
 )
 
 func bindMountRW(string, string) error {
 	return errors.New("unsupported on this platform")
 }
 
+func bindMount(string, string, BindOptions) error {
+	return errors.New("unsupported on this platform")
+}
+
+func bindMountAt(string, string, string, BindOptions) error {
+	return errors.New("unsupported on this platform")
+}
+
+func verifyBindOptions(string, BindOptions) error {
+	return errors.New("unsupported on this platform")
+}
+
+func readBindOptions(string) (BindOptions, error) {
+	return BindOptions{}, errors.New("unsupported on this platform")
+}
+
+func verifySuperOptions(string, []string) error {
+	return errors.New("unsupported on this platform")
+}
+
+func mountTmpfs(string, string) error {
+	return errors.New("unsupported on this platform")
+}
+
 func unmount(string) error {
 	return errors.New("unsupported on this platform")
 }
@@ -18,3 +43,103 @@ func unmount(string) error {
 func isMountPoint(string) (bool, error) {
 	return false, errors.New("unsupported on this platform")
 }
+
+func getSELinuxLabel(string) (string, error) {
+	return "", errors.New("unsupported on this platform")
+}
+
+func setSELinuxLabel(string, string) error {
+	return errors.New("unsupported on this platform")
+}
+
+func seLinuxEnabled() bool {
+	return false
+}
+
+func bindMountIDMapped(string, string, BindOptions, IDMap) error {
+	return ErrIDMapUnsupported
+}
+
+func bindMountIDMappedAt(string, string, string, BindOptions, IDMap) error {
+	return ErrIDMapUnsupported
+}
+
+func isIDMapped(string) (bool, error) {
+	return false, errors.New("unsupported on this platform")
+}
+
+func readMountInfo() ([]MountInfo, error) {
+	return nil, errors.New("unsupported on this platform")
+}
+
+func unmountWithOptions(string, UnmountOptions) (UnmountResult, error) {
+	return UnmountResult{}, errors.New("unsupported on this platform")
+}
+
+func unmountAt(string, string) error {
+	return errors.New("unsupported on this platform")
+}
+
+func unmountWithOptionsAt(string, string, UnmountOptions) (UnmountResult, error) {
+	return UnmountResult{}, errors.New("unsupported on this platform")
+}
+
+func openBeneath(string, string) (*os.File, error) {
+	return nil, errors.New("unsupported on this platform")
+}
+
+func mkdirBeneath(string, string, os.FileMode) error {
+	return errors.New("unsupported on this platform")
+}
+
+func createBeneath(string, string, os.FileMode) error {
+	return errors.New("unsupported on this platform")
+}
+
+func writeFileBeneath(string, string, []byte, os.FileMode) error {
+	return errors.New("unsupported on this platform")
+}
+
+func checkBeneath() error {
+	return errors.New("unsupported on this platform")
+}
+
+func removeBeneath(string, string) error {
+	return errors.New("unsupported on this platform")
+}
+
+func isCorruptedMountPoint(string) bool {
+	return false
+}
+
+func isCorruptedMountError(error) bool {
+	return false
+}
+
+func setPropagation(string, Propagation) error {
+	return errors.New("unsupported on this platform")
+}
+
+func readMountInfoOf(int) ([]MountInfo, error) {
+	return nil, errors.New("unsupported on this platform")
+}
+
+func sharesMountNamespace(int) (bool, error) {
+	return false, errors.New("unsupported on this platform")
+}
+
+func runInNamespace(int, func() error) error {
+	return errors.New("unsupported on this platform")
+}
+
+func bindMountIn(int, string, string, string, BindOptions) error {
+	return errors.New("unsupported on this platform")
+}
+
+func bindMountIDMappedIn(int, string, string, string, BindOptions, IDMap) error {
+	return ErrIDMapUnsupported
+}
+
+func startHelper([]string) (*Helper, error) {
+	return nil, errors.New("unsupported on this platform")
+}
@@ -0,0 +1,31 @@
+package mount
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitOptions(t *testing.T) {
+	assert.Equal(t, []string{"rw"}, SplitOptions("rw"))
+	assert.Equal(t, []string{"nosuid", "nodev", "noexec"}, SplitOptions("nosuid,nodev,noexec"))
+	assert.Equal(t, []string{"nosuid", `context="system_u:object_r:container_file_t:s0:c1,c2"`, "noexec"},
+		SplitOptions(`nosuid,context="system_u:object_r:container_file_t:s0:c1,c2",noexec`))
+	assert.Equal(t, []string{""}, SplitOptions(""))
+}
+
+func TestIsTmpfsWithOptions(t *testing.T) {
+	for _, tt := range []struct {
+		info   MountInfo
+		expect bool
+	}{
+		{MountInfo{FSType: "tmpfs", SuperOptions: []string{"size=1m", "mode=0755"}}, true},
+		{MountInfo{FSType: "tmpfs", SuperOptions: []string{"rw", "seclabel", "size=1024k", "mode=755"}}, true},
+		{MountInfo{FSType: "tmpfs", SuperOptions: []string{"rw", "size=1024k", "mode=700"}}, false},
+		{MountInfo{FSType: "tmpfs", SuperOptions: []string{"rw", "size=65536k", "mode=755"}}, false},
+		{MountInfo{FSType: "tmpfs", SuperOptions: []string{"rw"}}, false},
+		{MountInfo{FSType: "ramfs", SuperOptions: []string{"size=1m", "mode=0755"}}, false},
+	} {
+		assert.Equal(t, tt.expect, IsTmpfsWithOptions(tt.info, "size=1m,mode=0755"), "%+v", tt.info)
+	}
+}
@@ -0,0 +1,195 @@
+package mount
+
+// Mounter is the set of mount and mount information operations the driver
+// performs. Local implements it with the package functions. Alternative
+// implementations, e.g. ones performing the operations elsewhere, are made
+// available by name through RegisterBackend.
+type Mounter interface {
+	// BindMountRW performs a read-write bind mount from root to mountPoint.
+	BindMountRW(root, mountPoint string) error
+
+	// BindMount bind mounts root onto mountPoint with the attributes in
+	// opts.
+	BindMount(root, mountPoint string, opts BindOptions) error
+
+	// BindMountIDMapped bind mounts root onto mountPoint as an ID-mapped
+	// mount. It fails with ErrIDMapUnsupported where ID-mapped mounts are
+	// not supported.
+	BindMountIDMapped(root, mountPoint string, opts BindOptions, idmap IDMap) error
+
+	// MountTmpfs mounts a new nosuid, nodev and noexec tmpfs instance on
+	// mountPoint.
+	MountTmpfs(mountPoint, data string) error
+
+	// Unmount unmounts the topmost mount on mountPoint.
+	Unmount(mountPoint string) error
+
+	// UnmountWithOptions unmounts the topmost mount on mountPoint, retrying
+	// while it is busy.
+	UnmountWithOptions(mountPoint string, opts UnmountOptions) (UnmountResult, error)
+
+	// SetPropagation changes the propagation type of the topmost mount on
+	// mountPoint.
+	SetPropagation(mountPoint string, p Propagation) error
+
+	// IsMountPoint returns whether mountPoint is a mount point.
+	IsMountPoint(mountPoint string) (bool, error)
+
+	// GetMount returns the record of the topmost mount on mountPoint. The
+	// bool is false if nothing is mounted on mountPoint.
+	GetMount(mountPoint string) (MountInfo, bool, error)
+
+	// IsBindMountOf returns whether the topmost mount on mountPoint is a
+	// bind mount of source.
+	IsBindMountOf(mountPoint, source string) (bool, error)
+
+	// BindMountsOf returns the records of the bind mounts of source.
+	BindMountsOf(source string) ([]MountInfo, error)
+
+	// SameFile reports whether a and b refer to the same file.
+	SameFile(a, b string) (bool, error)
+
+	// VerifyBindOptions checks that the topmost mount on mountPoint has
+	// every attribute requested by opts.
+	VerifyBindOptions(mountPoint string, opts BindOptions) error
+
+	// ReadBindOptions returns the attributes of the topmost mount on
+	// mountPoint.
+	ReadBindOptions(mountPoint string) (BindOptions, error)
+
+	// VerifySuperOptions checks that the superblock of the topmost mount on
+	// mountPoint has every option in want.
+	VerifySuperOptions(mountPoint string, want []string) error
+
+	// IsIDMapped returns whether the topmost mount on mountPoint is
+	// ID-mapped.
+	IsIDMapped(mountPoint string) (bool, error)
+
+	// VerifyPropagation checks that the topmost mount on mountPoint has the
+	// propagation type p.
+	VerifyPropagation(mountPoint string, p Propagation) error
+
+	// IsMountedIn returns whether something is mounted on mountPoint in the
+	// mount namespace of the process with the given PID.
+	IsMountedIn(pid int, mountPoint string) (bool, error)
+
+	// SharesMountNamespace returns whether the process with the given PID
+	// is in the mount namespace of the current process.
+	SharesMountNamespace(pid int) (bool, error)
+
+	// SELinuxEnabled returns whether SELinux is enabled.
+	SELinuxEnabled() bool
+
+	// GetSELinuxLabel returns the SELinux label of path.
+	GetSELinuxLabel(path string) (string, error)
+
+	// SetSELinuxLabel sets the SELinux label of path.
+	SetSELinuxLabel(path, label string) error
+}
+
+// Local is the Mounter operating in the mount namespace of the current
+// process, through the package functions.
+type Local struct {
+	// Beneath, if set, is a directory that the mount points of mount and
+	// unmount operations have to be within. They are then resolved beneath
+	// it without following symlinks, like in OpenBeneath, and the
+	// operations act on what was resolved rather than on a path that may
+	// have been swapped for a symlink in the meantime.
+	Beneath string
+}
+
+var _ Mounter = Local{}
+
+// BindMountRW calls BindMountRW.
+func (l Local) BindMountRW(root, mountPoint string) error {
+	return bindMountAt(l.Beneath, root, mountPoint, BindOptions{})
+}
+
+// BindMount calls BindMount.
+func (l Local) BindMount(root, mountPoint string, opts BindOptions) error {
+	return bindMountAt(l.Beneath, root, mountPoint, opts)
+}
+
+// BindMountIDMapped calls BindMountIDMapped.
+func (l Local) BindMountIDMapped(root, mountPoint string, opts BindOptions, idmap IDMap) error {
+	return bindMountIDMappedAt(l.Beneath, root, mountPoint, opts, idmap)
+}
+
+// MountTmpfs calls MountTmpfs.
+func (l Local) MountTmpfs(mountPoint, data string) error {
+	return atMountPoint(l.Beneath, mountPoint, func(mountPoint string) error {
+		return MountTmpfs(mountPoint, data)
+	})
+}
+
+// Unmount calls Unmount.
+func (l Local) Unmount(mountPoint string) error { return unmountAt(l.Beneath, mountPoint) }
+
+// UnmountWithOptions calls UnmountWithOptions.
+func (l Local) UnmountWithOptions(mountPoint string, opts UnmountOptions) (UnmountResult, error) {
+	return unmountWithOptionsAt(l.Beneath, mountPoint, opts)
+}
+
+// SetPropagation calls SetPropagation.
+func (l Local) SetPropagation(mountPoint string, p Propagation) error {
+	return atMountPoint(l.Beneath, mountPoint, func(mountPoint string) error {
+		return SetPropagation(mountPoint, p)
+	})
+}
+
+// IsMountPoint calls IsMountPoint.
+func (Local) IsMountPoint(mountPoint string) (bool, error) { return IsMountPoint(mountPoint) }
+
+// GetMount calls GetMount.
+func (Local) GetMount(mountPoint string) (MountInfo, bool, error) { return GetMount(mountPoint) }
+
+// IsBindMountOf calls IsBindMountOf.
+func (Local) IsBindMountOf(mountPoint, source string) (bool, error) {
+	return IsBindMountOf(mountPoint, source)
+}
+
+// BindMountsOf calls BindMountsOf.
+func (Local) BindMountsOf(source string) ([]MountInfo, error) { return BindMountsOf(source) }
+
+// SameFile calls SameFile.
+func (Local) SameFile(a, b string) (bool, error) { return SameFile(a, b) }
+
+// VerifyBindOptions calls VerifyBindOptions.
+func (Local) VerifyBindOptions(mountPoint string, opts BindOptions) error {
+	return VerifyBindOptions(mountPoint, opts)
+}
+
+// ReadBindOptions calls ReadBindOptions.
+func (Local) ReadBindOptions(mountPoint string) (BindOptions, error) {
+	return ReadBindOptions(mountPoint)
+}
+
+// VerifySuperOptions calls VerifySuperOptions.
+func (Local) VerifySuperOptions(mountPoint string, want []string) error {
+	return VerifySuperOptions(mountPoint, want)
+}
+
+// IsIDMapped calls IsIDMapped.
+func (Local) IsIDMapped(mountPoint string) (bool, error) { return IsIDMapped(mountPoint) }
+
+// VerifyPropagation calls VerifyPropagation.
+func (Local) VerifyPropagation(mountPoint string, p Propagation) error {
+	return VerifyPropagation(mountPoint, p)
+}
+
+// IsMountedIn calls IsMountedIn.
+func (Local) IsMountedIn(pid int, mountPoint string) (bool, error) {
+	return IsMountedIn(pid, mountPoint)
+}
+
+// SharesMountNamespace calls SharesMountNamespace.
+func (Local) SharesMountNamespace(pid int) (bool, error) { return SharesMountNamespace(pid) }
+
+// SELinuxEnabled calls SELinuxEnabled.
+func (Local) SELinuxEnabled() bool { return SELinuxEnabled() }
+
+// GetSELinuxLabel calls GetSELinuxLabel.
+func (Local) GetSELinuxLabel(path string) (string, error) { return GetSELinuxLabel(path) }
+
+// SetSELinuxLabel calls SetSELinuxLabel.
+func (Local) SetSELinuxLabel(path, label string) error { return SetSELinuxLabel(path, label) }
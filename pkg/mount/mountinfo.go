@@ -0,0 +1,410 @@
+package mount
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// mountPointIdx is the slice index of the mount point in a parsed mountinfo
+// record. proc(5) "/proc/[pid]/mountinfo" documents it as field 5.
+const mountPointIdx = 4
+
+// mountOptionsIdx is the slice index of the per-mount options in a parsed
+// mountinfo record (field 6).
+const mountOptionsIdx = 5
+
+// MountInfo is a record of /proc/[pid]/mountinfo, as documented in proc(5).
+// Path fields have their octal escapes (e.g. "\040" for a space) decoded.
+type MountInfo struct {
+	// ID is the unique ID of the mount.
+	ID int
+
+	// ParentID is the ID of the parent mount, or of the mount itself for
+	// the root of the mount tree.
+	ParentID int
+
+	// Major and Minor are the device numbers of the filesystem.
+	Major uint32
+	Minor uint32
+
+	// Root is the path of the directory of the filesystem that forms the
+	// root of the mount, e.g. the source directory of a bind mount.
+	Root string
+
+	// MountPoint is the path of the mount point relative to the root of the
+	// process.
+	MountPoint string
+
+	// Options are the per-mount options, e.g. "ro" or "nosuid".
+	Options []string
+
+	// OptionalFields are the raw optional fields, e.g. "shared:2".
+	OptionalFields []string
+
+	// Shared is the peer group ID if the mount is shared, or zero.
+	Shared int
+
+	// Master is the peer group ID of the master if the mount is a slave, or
+	// zero.
+	Master int
+
+	// PropagateFrom is the peer group ID of the closest dominant peer group
+	// of a slave mount that receives propagation from a group it cannot
+	// see, or zero.
+	PropagateFrom int
+
+	// Unbindable is set if the mount is unbindable.
+	Unbindable bool
+
+	// FSType is the filesystem type, e.g. "tmpfs" or "ext4".
+	FSType string
+
+	// Source is the filesystem specific mount source, e.g. "/dev/sda1".
+	Source string
+
+	// SuperOptions are the per-superblock options.
+	SuperOptions []string
+}
+
+// ParseMountInfo parses mountinfo records from r. Records are returned in the
+// order they appear, which puts mounts stacked on the same mount point after
+// the mounts they cover. Malformed records are skipped and counted (see
+// SkippedMountInfoRecords) rather than failing the whole table, which every
+// lookup of a mount would otherwise fail on.
+func ParseMountInfo(r io.Reader) ([]MountInfo, error) {
+	var infos []MountInfo
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		info, err := parseMountInfoLine(line)
+		if err != nil {
+			skippedMountInfoRecords.Add(1)
+			continue
+		}
+		infos = append(infos, info)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan mount info: %w", err)
+	}
+	return infos, nil
+}
+
+// skippedMountInfoRecords counts the records ParseMountInfo skipped.
+var skippedMountInfoRecords atomic.Uint64
+
+// SkippedMountInfoRecords returns the number of malformed mountinfo records
+// ParseMountInfo has skipped so far.
+func SkippedMountInfoRecords() uint64 {
+	return skippedMountInfoRecords.Load()
+}
+
+// ReadMountInfo returns the mount information of the current process.
+func ReadMountInfo() ([]MountInfo, error) {
+	return readMountInfo()
+}
+
+func parseMountInfoLine(line string) (MountInfo, error) {
+	// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+	// (1)(2)(3)   (4)   (5)      (6)      (7)   (8) (9)   (10)         (11)
+	fields := strings.Fields(line)
+	sep := -1
+	for i := mountOptionsIdx + 1; i < len(fields); i++ {
+		if fields[i] == "-" {
+			sep = i
+			break
+		}
+	}
+	if sep < 0 || len(fields) < sep+4 {
+		return MountInfo{}, fmt.Errorf("malformed mount info record %q", line)
+	}
+
+	var info MountInfo
+	var err error
+	if info.ID, err = strconv.Atoi(fields[0]); err != nil {
+		return MountInfo{}, fmt.Errorf("malformed mount ID in mount info record %q: %w", line, err)
+	}
+	if info.ParentID, err = strconv.Atoi(fields[1]); err != nil {
+		return MountInfo{}, fmt.Errorf("malformed parent ID in mount info record %q: %w", line, err)
+	}
+	if info.Major, info.Minor, err = parseDevice(fields[2]); err != nil {
+		return MountInfo{}, fmt.Errorf("malformed device in mount info record %q: %w", line, err)
+	}
+	info.Root = unescapeOctal(fields[3])
+	info.MountPoint = unescapeOctal(fields[mountPointIdx])
+	info.Options = strings.Split(fields[mountOptionsIdx], ",")
+	info.OptionalFields = fields[mountOptionsIdx+1 : sep]
+	for _, field := range info.OptionalFields {
+		tag, value, _ := strings.Cut(field, ":")
+		var dst *int
+		switch tag {
+		case "shared":
+			dst = &info.Shared
+		case "master":
+			dst = &info.Master
+		case "propagate_from":
+			dst = &info.PropagateFrom
+		case "unbindable":
+			info.Unbindable = true
+			continue
+		default:
+			// Unknown optional fields are to be ignored.
+			continue
+		}
+		if *dst, err = strconv.Atoi(value); err != nil {
+			return MountInfo{}, fmt.Errorf("malformed optional field %q in mount info record %q: %w", field, line, err)
+		}
+	}
+	info.FSType = unescapeOctal(fields[sep+1])
+	info.Source = unescapeOctal(fields[sep+2])
+	for _, option := range SplitOptions(fields[sep+3]) {
+		info.SuperOptions = append(info.SuperOptions, unescapeOctal(option))
+	}
+	return info, nil
+}
+
+func parseDevice(s string) (uint32, uint32, error) {
+	majorStr, minorStr, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected major:minor, got %q", s)
+	}
+	major, err := strconv.ParseUint(majorStr, 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	minor, err := strconv.ParseUint(minorStr, 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint32(major), uint32(minor), nil
+}
+
+var reOctal = regexp.MustCompile(`\\([0-7]{3})`)
+
+func unescapeOctal(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	return reOctal.ReplaceAllStringFunc(s, func(oct string) string {
+		// cannot fail due to regex constraints
+		r, _ := strconv.ParseUint(oct[1:], 8, 8)
+		return string([]byte{byte(r)})
+	})
+}
+
+// MountInfoFilter selects mountinfo records.
+type MountInfoFilter func(MountInfo) bool
+
+// FilterMountInfo returns the records matching every filter.
+func FilterMountInfo(infos []MountInfo, filters ...MountInfoFilter) []MountInfo {
+	var out []MountInfo
+outer:
+	for _, info := range infos {
+		for _, filter := range filters {
+			if !filter(info) {
+				continue outer
+			}
+		}
+		out = append(out, info)
+	}
+	return out
+}
+
+// MountPointFilter selects the records of mounts on mountPoint.
+func MountPointFilter(mountPoint string) MountInfoFilter {
+	mountPoint = filepath.Clean(mountPoint)
+	return func(info MountInfo) bool {
+		return info.MountPoint == mountPoint
+	}
+}
+
+// PrefixFilter selects the records of mounts on prefix or below it. The
+// prefix matches whole path components, so "/var/lib/kubelet" does not
+// select "/var/lib/kubelet-other".
+func PrefixFilter(prefix string) MountInfoFilter {
+	prefix = filepath.Clean(prefix)
+	return func(info MountInfo) bool {
+		return isPathWithin(info.MountPoint, prefix)
+	}
+}
+
+// RootFilter selects the records of mounts whose root within their
+// filesystem is root, e.g. the bind mounts of a given directory.
+func RootFilter(root string) MountInfoFilter {
+	root = filepath.Clean(root)
+	return func(info MountInfo) bool {
+		return info.Root == root
+	}
+}
+
+// DeviceFilter selects the records of mounts of the filesystem with the
+// given device numbers.
+func DeviceFilter(major, minor uint32) MountInfoFilter {
+	return func(info MountInfo) bool {
+		return info.Major == major && info.Minor == minor
+	}
+}
+
+// isPathWithin returns whether path is dir or below it. Both must be clean.
+func isPathWithin(path, dir string) bool {
+	switch {
+	case path == dir:
+		return true
+	case dir == "/":
+		return strings.HasPrefix(path, "/")
+	default:
+		return strings.HasPrefix(path, dir+"/")
+	}
+}
+
+// GetMount returns the record of the topmost mount on mountPoint from the
+// mount information of the current process. The bool is false if nothing is
+// mounted on mountPoint.
+func GetMount(mountPoint string) (MountInfo, bool, error) {
+	infos, err := readMountInfo()
+	if err != nil {
+		return MountInfo{}, false, err
+	}
+	info, ok := topmostMount(infos, filepath.Clean(mountPoint))
+	return info, ok, nil
+}
+
+// IsBindMountOf returns whether the topmost mount on mountPoint is a bind
+// mount of source, i.e. a mount of the same filesystem (device) whose root is
+// the path of source within that filesystem. A bind mount of a file that has
+// since been deleted from source still counts; it is a stale bind mount of
+// source rather than a foreign mount.
+func IsBindMountOf(mountPoint, source string) (bool, error) {
+	source, err := resolvePath(source)
+	if err != nil {
+		return false, err
+	}
+	infos, err := readMountInfo()
+	if err != nil {
+		return false, err
+	}
+	return isBindMountOf(infos, filepath.Clean(mountPoint), source), nil
+}
+
+// resolvePath resolves the symlinks in path. A missing final component is
+// tolerated so that bind mounts of files that no longer exist can still be
+// matched.
+func resolvePath(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		dir, dirErr := filepath.EvalSymlinks(filepath.Dir(path))
+		if dirErr != nil {
+			return "", err
+		}
+		return filepath.Join(dir, filepath.Base(path)), nil
+	}
+	return resolved, err
+}
+
+// deletedSuffixes are appended by the kernel to the root of mounts whose
+// root has been unlinked. mountinfo uses "//deleted"; " (deleted)" is the
+// d_path form found in other proc files.
+var deletedSuffixes = []string{"//deleted", " (deleted)"}
+
+func isBindMountOf(infos []MountInfo, mountPoint, source string) bool {
+	target, ok := topmostMount(infos, mountPoint)
+	if !ok {
+		return false
+	}
+	major, minor, root, ok := locatePath(infos, source)
+	return ok && isBindOf(target, major, minor, root)
+}
+
+// BindMountsOf returns the records of every mount of source, other than the
+// mount source itself is reached through, from the mount information of the
+// current process. Mounts stacked on the same mount point are returned in
+// stacking order.
+func BindMountsOf(source string) ([]MountInfo, error) {
+	source, err := resolvePath(source)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := readMountInfo()
+	if err != nil {
+		return nil, err
+	}
+	return bindMountsOf(infos, source), nil
+}
+
+func bindMountsOf(infos []MountInfo, source string) []MountInfo {
+	major, minor, root, ok := locatePath(infos, source)
+	if !ok {
+		return nil
+	}
+	var out []MountInfo
+	for _, info := range infos {
+		if info.MountPoint != source && isBindOf(info, major, minor, root) {
+			out = append(out, info)
+		}
+	}
+	return out
+}
+
+// isBindOf returns whether info is a mount of the given path within the
+// filesystem on the given device.
+func isBindOf(info MountInfo, major, minor uint32, root string) bool {
+	if info.Major != major || info.Minor != minor {
+		return false
+	}
+	if info.Root == root {
+		return true
+	}
+	for _, suffix := range deletedSuffixes {
+		if info.Root == root+suffix {
+			return true
+		}
+	}
+	return false
+}
+
+// locatePath returns the device of the filesystem holding path and the path
+// of path within that filesystem, according to the mount containing path.
+func locatePath(infos []MountInfo, path string) (uint32, uint32, string, bool) {
+	var containing *MountInfo
+	for i := range infos {
+		info := &infos[i]
+		if !isPathWithin(path, info.MountPoint) {
+			continue
+		}
+		// The deepest mount point wins, and of mounts stacked on the same
+		// mount point, the last one.
+		if containing == nil || len(info.MountPoint) >= len(containing.MountPoint) {
+			containing = info
+		}
+	}
+	if containing == nil {
+		return 0, 0, "", false
+	}
+	rel, err := filepath.Rel(containing.MountPoint, path)
+	if err != nil {
+		return 0, 0, "", false
+	}
+	return containing.Major, containing.Minor, filepath.Join(containing.Root, rel), true
+}
+
+// topmostMount returns the last record for mountPoint, which is the mount
+// visible there.
+func topmostMount(infos []MountInfo, mountPoint string) (MountInfo, bool) {
+	for i := len(infos) - 1; i >= 0; i-- {
+		if infos[i].MountPoint == mountPoint {
+			return infos[i], true
+		}
+	}
+	return MountInfo{}, false
+}
@@ -0,0 +1,211 @@
+package mount
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMountInfo(t *testing.T) {
+	const mountInfo = `36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+37 36 0:45 / /mnt/has\040space ro,nosuid shared:2 master:3 propagate_from:4 unbindable - tmpfs tmp\040fs rw,context="system_u:object_r:container_file_t:s0:c1,c2"
+38 35 0:46 /dir\011tab /mnt/plain rw - overlay overlay rw,lowerdir=/a\054b
+`
+	infos, err := ParseMountInfo(strings.NewReader(mountInfo))
+	require.NoError(t, err)
+	assert.Equal(t, []MountInfo{
+		{
+			ID:             36,
+			ParentID:       35,
+			Major:          98,
+			Minor:          0,
+			Root:           "/mnt1",
+			MountPoint:     "/mnt2",
+			Options:        []string{"rw", "noatime"},
+			OptionalFields: []string{"master:1"},
+			Master:         1,
+			FSType:         "ext3",
+			Source:         "/dev/root",
+			SuperOptions:   []string{"rw", "errors=continue"},
+		},
+		{
+			ID:             37,
+			ParentID:       36,
+			Major:          0,
+			Minor:          45,
+			Root:           "/",
+			MountPoint:     "/mnt/has space",
+			Options:        []string{"ro", "nosuid"},
+			OptionalFields: []string{"shared:2", "master:3", "propagate_from:4", "unbindable"},
+			Shared:         2,
+			Master:         3,
+			PropagateFrom:  4,
+			Unbindable:     true,
+			FSType:         "tmpfs",
+			Source:         "tmp fs",
+			SuperOptions:   []string{"rw", `context="system_u:object_r:container_file_t:s0:c1,c2"`},
+		},
+		{
+			ID:             38,
+			ParentID:       35,
+			Major:          0,
+			Minor:          46,
+			Root:           "/dir\ttab",
+			MountPoint:     "/mnt/plain",
+			Options:        []string{"rw"},
+			OptionalFields: []string{},
+			FSType:         "overlay",
+			Source:         "overlay",
+			SuperOptions:   []string{"rw", "lowerdir=/a,b"},
+		},
+	}, infos)
+}
+
+func TestParseMountInfoErrors(t *testing.T) {
+	for _, tt := range []struct {
+		desc      string
+		line      string
+		expectErr string
+	}{
+		{
+			desc:      "missing separator",
+			line:      "36 35 98:0 / /mnt rw ext3 /dev/root rw",
+			expectErr: `malformed mount info record "36 35 98:0 / /mnt rw ext3 /dev/root rw"`,
+		},
+		{
+			desc:      "missing super options",
+			line:      "36 35 98:0 / /mnt rw - ext3 /dev/root",
+			expectErr: `malformed mount info record "36 35 98:0 / /mnt rw - ext3 /dev/root"`,
+		},
+		{
+			desc:      "bad mount ID",
+			line:      "x 35 98:0 / /mnt rw - ext3 /dev/root rw",
+			expectErr: `malformed mount ID in mount info record "x 35 98:0 / /mnt rw - ext3 /dev/root rw": strconv.Atoi: parsing "x": invalid syntax`,
+		},
+		{
+			desc:      "bad device",
+			line:      "36 35 98 / /mnt rw - ext3 /dev/root rw",
+			expectErr: `malformed device in mount info record "36 35 98 / /mnt rw - ext3 /dev/root rw": expected major:minor, got "98"`,
+		},
+		{
+			desc:      "bad optional field",
+			line:      "36 35 98:0 / /mnt rw shared:x - ext3 /dev/root rw",
+			expectErr: `malformed optional field "shared:x" in mount info record "36 35 98:0 / /mnt rw shared:x - ext3 /dev/root rw": strconv.Atoi: parsing "x": invalid syntax`,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, err := parseMountInfoLine(tt.line)
+			require.EqualError(t, err, tt.expectErr)
+		})
+	}
+}
+
+func TestParseMountInfoSkipsMalformedRecords(t *testing.T) {
+	skipped := SkippedMountInfoRecords()
+	infos, err := ParseMountInfo(strings.NewReader(`36 35 98:0 / /mnt1 rw - ext3 /dev/root rw
+x 35 98:0 / /mnt2 rw - ext3 /dev/root rw
+38 35 98:0 / /mnt3 rw - ext3 /dev/root rw
+`))
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	assert.Equal(t, "/mnt1", infos[0].MountPoint)
+	assert.Equal(t, "/mnt3", infos[1].MountPoint)
+	assert.Equal(t, skipped+1, SkippedMountInfoRecords())
+}
+
+func TestFilterMountInfo(t *testing.T) {
+	f, err := os.Open("testdata/mountinfo")
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+
+	infos, err := ParseMountInfo(f)
+	require.NoError(t, err)
+	require.Len(t, infos, 28)
+
+	mountPoints := func(infos []MountInfo) []string {
+		var out []string
+		for _, info := range infos {
+			out = append(out, info.MountPoint)
+		}
+		return out
+	}
+
+	assert.Equal(t, []string{"/spire-agent-socket"},
+		mountPoints(FilterMountInfo(infos, MountPointFilter("/spire-agent-socket/"))))
+
+	podMounts := FilterMountInfo(infos, PrefixFilter("/var/lib/kubelet/pods/c3a32fc0-f186-4974-8579-429dea58ec6d"))
+	assert.Equal(t, []string{
+		"/var/lib/kubelet/pods/c3a32fc0-f186-4974-8579-429dea58ec6d/volumes/kubernetes.io~projected/kube-api-access-56mpv",
+		"/var/lib/kubelet/pods/c3a32fc0-f186-4974-8579-429dea58ec6d/volumes/kubernetes.io~csi/spire-agent-socket/mount",
+	}, mountPoints(podMounts))
+	assert.Empty(t, FilterMountInfo(infos, PrefixFilter("/var/lib/kubelet/pods/c3a32fc0")))
+	assert.Len(t, FilterMountInfo(infos, PrefixFilter("/")), 28)
+
+	// The CSI volume is a bind mount of the agent socket directory, so it
+	// shares its root and device.
+	agentRoot := "/docker/volumes/ae14d1dc9612d7d30d542a76b17f1a4df12a2167161454111652d5b863be332c/_data/spire-agent-socket-dir"
+	assert.Equal(t, []string{
+		"/spire-agent-socket",
+		"/var/lib/kubelet/pods/c3a32fc0-f186-4974-8579-429dea58ec6d/volumes/kubernetes.io~csi/spire-agent-socket/mount",
+		"/var/lib/kubelet/pods/72e3589e-908c-476f-9f95-2b1884552d1c/volumes/kubernetes.io~csi/spire-agent-socket/mount",
+	}, mountPoints(FilterMountInfo(infos, RootFilter(agentRoot), DeviceFilter(254, 1))))
+	assert.Empty(t, FilterMountInfo(infos, RootFilter(agentRoot), DeviceFilter(254, 2)))
+}
+
+func TestIsBindMountOf(t *testing.T) {
+	f, err := os.Open("testdata/mountinfo")
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+
+	infos, err := ParseMountInfo(f)
+	require.NoError(t, err)
+
+	const (
+		csiMount       = "/var/lib/kubelet/pods/c3a32fc0-f186-4974-8579-429dea58ec6d/volumes/kubernetes.io~csi/spire-agent-socket/mount"
+		projectedMount = "/var/lib/kubelet/pods/c3a32fc0-f186-4974-8579-429dea58ec6d/volumes/kubernetes.io~projected/kube-api-access-56mpv"
+	)
+	assert.True(t, isBindMountOf(infos, csiMount, "/spire-agent-socket"))
+	assert.False(t, isBindMountOf(infos, csiMount, "/spire-agent-socket/sub"), "different root")
+	assert.False(t, isBindMountOf(infos, csiMount, "/dev"), "different device")
+	assert.False(t, isBindMountOf(infos, projectedMount, "/spire-agent-socket"))
+	assert.False(t, isBindMountOf(infos, csiMount+"/missing", "/spire-agent-socket"), "not a mount point")
+
+	// A bind mount of a file nested in a mount of its own filesystem, whose
+	// source has since been deleted, is still a bind mount of the source.
+	const socketMounts = `1 0 8:1 / / rw - ext4 /dev/sda1 rw
+2 1 8:2 /agent /run/agent rw - ext4 /dev/sda2 rw
+3 1 8:2 /agent/sock /mnt/sock rw - ext4 /dev/sda2 rw
+4 1 8:2 /agent/sock\040(deleted) /mnt/stale rw - ext4 /dev/sda2 rw
+5 1 8:1 /run/agent/sock /mnt/shadowed rw - ext4 /dev/sda1 rw
+6 1 8:2 /agent/sock//deleted /mnt/stale2 rw - ext4 /dev/sda2 rw
+`
+	infos, err = ParseMountInfo(strings.NewReader(socketMounts))
+	require.NoError(t, err)
+	assert.True(t, isBindMountOf(infos, "/mnt/sock", "/run/agent/sock"))
+	assert.True(t, isBindMountOf(infos, "/mnt/stale", "/run/agent/sock"))
+	assert.True(t, isBindMountOf(infos, "/mnt/stale2", "/run/agent/sock"))
+	assert.False(t, isBindMountOf(infos, "/mnt/shadowed", "/run/agent/sock"), "bind of the path under the mount")
+}
+
+func TestBindMountsOf(t *testing.T) {
+	const mountInfo = `1 0 8:1 / / rw - ext4 /dev/sda1 rw
+2 1 8:2 /agent /run/agent rw - ext4 /dev/sda2 rw
+3 1 8:2 /agent /mnt/a rw - ext4 /dev/sda2 rw
+4 3 8:2 /agent /mnt/a rw - ext4 /dev/sda2 rw
+5 1 8:2 /agent /mnt/b rw - ext4 /dev/sda2 rw
+6 1 8:2 /other /mnt/c rw - ext4 /dev/sda2 rw
+7 1 0:40 / /mnt/d rw - tmpfs tmpfs rw
+`
+	infos, err := ParseMountInfo(strings.NewReader(mountInfo))
+	require.NoError(t, err)
+
+	var ids []int
+	for _, info := range bindMountsOf(infos, "/run/agent") {
+		ids = append(ids, info.ID)
+	}
+	assert.Equal(t, []int{3, 4, 5}, ids)
+	assert.Empty(t, bindMountsOf(infos, "/run/agent/missing"))
+}
@@ -0,0 +1,121 @@
+package mount
+
+import "path/filepath"
+
+// ReadMountInfoOf returns the mount information of the mount namespace of
+// the process with the given PID. Paths are relative to the root directory
+// of that process.
+func ReadMountInfoOf(pid int) ([]MountInfo, error) {
+	return readMountInfoOf(pid)
+}
+
+// IsMountedIn returns whether something is mounted on mountPoint in the mount
+// namespace of the process with the given PID, e.g. PID 1 of the host when
+// running with the host PID namespace. It is used to confirm that a mount
+// made by the current process propagated to that namespace.
+func IsMountedIn(pid int, mountPoint string) (bool, error) {
+	infos, err := readMountInfoOf(pid)
+	if err != nil {
+		return false, err
+	}
+	_, ok := topmostMount(infos, filepath.Clean(mountPoint))
+	return ok, nil
+}
+
+// SharesMountNamespace returns whether the process with the given PID is in
+// the mount namespace of the current process.
+func SharesMountNamespace(pid int) (bool, error) {
+	return sharesMountNamespace(pid)
+}
+
+// Namespace is a Mounter that performs mount operations in the mount
+// namespace of another process, typically PID 1 of the host when running in
+// the host PID namespace. Mounts made through it land directly in that
+// namespace instead of having to propagate there from the mount namespace of
+// the current process.
+//
+// Mount points are resolved in the namespace of the process. Bind mount
+// sources are resolved in the namespace of the current process, so that the
+// same source paths work with or without a Namespace. Besides IsMountPoint
+// and VerifyPropagation, the mount information is read in the namespace of
+// the current process, which therefore has to receive the mounts through
+// mount propagation.
+//
+// If Beneath is set, mount points are resolved beneath it like with Local,
+// in the namespace of the process.
+type Namespace struct {
+	Local
+
+	// PID is the process whose mount namespace the operations run in.
+	PID int
+}
+
+var _ Mounter = Namespace{}
+
+// BindMountRW bind mounts root onto mountPoint.
+func (ns Namespace) BindMountRW(root, mountPoint string) error {
+	return bindMountIn(ns.PID, ns.Beneath, root, mountPoint, BindOptions{})
+}
+
+// BindMount bind mounts root onto mountPoint with the given options.
+func (ns Namespace) BindMount(root, mountPoint string, opts BindOptions) error {
+	return bindMountIn(ns.PID, ns.Beneath, root, mountPoint, opts)
+}
+
+// BindMountIDMapped bind mounts root onto mountPoint as an ID-mapped mount.
+func (ns Namespace) BindMountIDMapped(root, mountPoint string, opts BindOptions, idmap IDMap) error {
+	return bindMountIDMappedIn(ns.PID, ns.Beneath, root, mountPoint, opts, idmap)
+}
+
+// MountTmpfs mounts a tmpfs onto mountPoint.
+func (ns Namespace) MountTmpfs(mountPoint, data string) error {
+	return runInNamespace(ns.PID, func() error {
+		return atMountPoint(ns.Beneath, mountPoint, func(mountPoint string) error {
+			return mountTmpfs(mountPoint, data)
+		})
+	})
+}
+
+// Unmount unmounts the topmost mount on mountPoint.
+func (ns Namespace) Unmount(mountPoint string) error {
+	return runInNamespace(ns.PID, func() error {
+		return unmountAt(ns.Beneath, mountPoint)
+	})
+}
+
+// UnmountWithOptions unmounts the topmost mount on mountPoint, retrying
+// while it is busy.
+func (ns Namespace) UnmountWithOptions(mountPoint string, opts UnmountOptions) (result UnmountResult, err error) {
+	err = runInNamespace(ns.PID, func() error {
+		result, err = unmountWithOptionsAt(ns.Beneath, mountPoint, opts)
+		return err
+	})
+	return result, err
+}
+
+// SetPropagation changes the propagation type of the topmost mount on
+// mountPoint.
+func (ns Namespace) SetPropagation(mountPoint string, p Propagation) error {
+	return runInNamespace(ns.PID, func() error {
+		return atMountPoint(ns.Beneath, mountPoint, func(mountPoint string) error {
+			return setPropagation(mountPoint, p)
+		})
+	})
+}
+
+// VerifyPropagation checks that the topmost mount on mountPoint has the
+// propagation type p.
+func (ns Namespace) VerifyPropagation(mountPoint string, p Propagation) error {
+	return runInNamespace(ns.PID, func() error {
+		return VerifyPropagation(mountPoint, p)
+	})
+}
+
+// IsMountPoint returns whether mountPoint is a mount point.
+func (ns Namespace) IsMountPoint(mountPoint string) (ok bool, err error) {
+	err = runInNamespace(ns.PID, func() error {
+		ok, err = isMountPoint(mountPoint)
+		return err
+	})
+	return ok, err
+}
@@ -0,0 +1,114 @@
+package mount
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+func readMountInfoOf(pid int) ([]MountInfo, error) {
+	f, err := os.Open(procPIDPath(pid, "mountinfo"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to open mount info of PID %d: %w", pid, err)
+	}
+	defer func() { _ = f.Close() }()
+	return ParseMountInfo(f)
+}
+
+func sharesMountNamespace(pid int) (bool, error) {
+	var self, other unix.Stat_t
+	if err := unix.Stat("/proc/self/ns/mnt", &self); err != nil {
+		return false, fmt.Errorf("unable to stat own mount namespace: %w", err)
+	}
+	if err := unix.Stat(procPIDPath(pid, "ns/mnt"), &other); err != nil {
+		return false, fmt.Errorf("unable to stat mount namespace of PID %d: %w", pid, err)
+	}
+	return self.Dev == other.Dev && self.Ino == other.Ino, nil
+}
+
+func procPIDPath(pid int, name string) string {
+	return "/proc/" + strconv.Itoa(pid) + "/" + name
+}
+
+// runInNamespace runs fn on an OS thread of its own that has joined the
+// mount namespace of the process with the given PID.
+func runInNamespace(pid int, fn func() error) error {
+	nsFD, err := unix.Open(procPIDPath(pid, "ns/mnt"), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("unable to open mount namespace of PID %d: %w", pid, err)
+	}
+	defer func() { _ = unix.Close(nsFD) }()
+
+	errCh := make(chan error, 1)
+	go func() {
+		// The thread never returns to the mount namespace of the process,
+		// so it is never unlocked either. The runtime terminates a thread
+		// that is still locked when its goroutine exits rather than
+		// scheduling other goroutines on it.
+		runtime.LockOSThread()
+
+		// setns refuses to move a thread that shares its root and working
+		// directory with the other threads of the process, which Go
+		// threads do.
+		if err := unix.Unshare(unix.CLONE_FS); err != nil {
+			errCh <- fmt.Errorf("unable to unshare filesystem attributes: %w", err)
+			return
+		}
+		if err := unix.Setns(nsFD, unix.CLONE_NEWNS); err != nil {
+			errCh <- fmt.Errorf("unable to enter mount namespace of PID %d: %w", pid, err)
+			return
+		}
+		errCh <- fn()
+	}()
+	return <-errCh
+}
+
+// bindMountIn clones root in the mount namespace of the current process and
+// attaches the clone at mountPoint in the mount namespace of pid. A detached
+// mount can be attached in any mount namespace, which is what lets the
+// source be something not visible in the target namespace.
+func bindMountIn(pid int, beneath, root, mountPoint string, opts BindOptions) error {
+	attrs := opts.mountAttrs()
+	remount := false
+	fd, err := cloneTree(root, &unix.MountAttr{Attr_set: attrs})
+	if errors.Is(err, unix.ENOSYS) && attrs != 0 {
+		// No mount_setattr; the options are applied by remounting once
+		// the clone has been attached.
+		remount = true
+		fd, err = cloneTree(root, nil)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = unix.Close(fd) }()
+
+	return runInNamespace(pid, func() error {
+		if err := attachTreeAt(fd, beneath, mountPoint); err != nil {
+			return err
+		}
+		if !remount {
+			return nil
+		}
+		// Resolved again to get at the attached clone rather than at the
+		// directory underneath it.
+		return atMountPoint(beneath, mountPoint, func(mountPoint string) error {
+			return remountBindOptions(mountPoint, opts)
+		})
+	})
+}
+
+func bindMountIDMappedIn(pid int, beneath, root, mountPoint string, opts BindOptions, idmap IDMap) error {
+	fd, err := idmappedTree(root, opts, idmap)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = unix.Close(fd) }()
+
+	return runInNamespace(pid, func() error {
+		return attachTreeAt(fd, beneath, mountPoint)
+	})
+}
@@ -0,0 +1,112 @@
+package mount
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestIsMountedIn(t *testing.T) {
+	mounted, err := IsMountedIn(os.Getpid(), "/proc")
+	require.NoError(t, err)
+	assert.True(t, mounted)
+
+	mounted, err = IsMountedIn(os.Getpid(), t.TempDir())
+	require.NoError(t, err)
+	assert.False(t, mounted)
+
+	_, err = IsMountedIn(-1, "/proc")
+	assert.ErrorContains(t, err, "unable to open mount info of PID -1")
+}
+
+func TestSharesMountNamespace(t *testing.T) {
+	shares, err := SharesMountNamespace(os.Getpid())
+	require.NoError(t, err)
+	assert.True(t, shares)
+
+	_, err = SharesMountNamespace(-1)
+	assert.ErrorContains(t, err, "unable to stat mount namespace of PID -1")
+}
+
+func TestNamespace(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("mounting requires root")
+	}
+	useProcMountInfo(t)
+
+	// The parent is private so that nothing mounted below it in the mount
+	// namespace of the child propagates back to this one.
+	parent := t.TempDir()
+	require.NoError(t, MountTmpfs(parent, "size=1m"))
+	t.Cleanup(func() { _ = Unmount(parent) })
+	require.NoError(t, unix.Mount("", parent, "", unix.MS_PRIVATE, ""))
+	mountPoint := filepath.Join(parent, "mount")
+	require.NoError(t, os.Mkdir(mountPoint, 0700))
+
+	cmd := exec.Command("sleep", "60")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNS}
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+	ns := Namespace{PID: cmd.Process.Pid}
+
+	shares, err := SharesMountNamespace(ns.PID)
+	require.NoError(t, err)
+	require.False(t, shares)
+
+	// The source only exists in this mount namespace.
+	source := t.TempDir()
+	require.NoError(t, MountTmpfs(source, "size=1m"))
+	t.Cleanup(func() { _ = Unmount(source) })
+	require.NoError(t, unix.Mount("", source, "", unix.MS_PRIVATE, ""))
+	require.NoError(t, os.WriteFile(filepath.Join(source, "file"), []byte("hello"), 0600))
+
+	require.NoError(t, ns.BindMount(source, mountPoint, BindOptions{ReadOnly: true, NoExec: true}))
+
+	mounted, err := ns.IsMountPoint(mountPoint)
+	require.NoError(t, err)
+	assert.True(t, mounted)
+	mounted, err = IsMountPoint(mountPoint)
+	require.NoError(t, err)
+	assert.False(t, mounted, "mount leaked into the current mount namespace")
+
+	infos, err := ReadMountInfoOf(ns.PID)
+	require.NoError(t, err)
+	info, ok := topmostMount(infos, mountPoint)
+	require.True(t, ok)
+	assert.Subset(t, info.Options, []string{"ro", "noexec"})
+	assert.Equal(t, "tmpfs", info.FSType)
+
+	require.NoError(t, ns.SetPropagation(mountPoint, PropagationUnbindable))
+	require.NoError(t, ns.VerifyPropagation(mountPoint, PropagationUnbindable))
+
+	require.NoError(t, ns.Unmount(mountPoint))
+	mounted, err = ns.IsMountPoint(mountPoint)
+	require.NoError(t, err)
+	assert.False(t, mounted)
+
+	require.NoError(t, ns.MountTmpfs(mountPoint, "size=1m"))
+	result, err := ns.UnmountWithOptions(mountPoint, DefaultUnmountOptions())
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Attempts)
+
+	// Mount points are resolved beneath the root in the mount namespace of
+	// the process.
+	ns.Beneath = parent
+	require.NoError(t, ns.BindMount(source, mountPoint, BindOptions{ReadOnly: true}))
+	mounted, err = ns.IsMountPoint(mountPoint)
+	require.NoError(t, err)
+	assert.True(t, mounted)
+	require.NoError(t, ns.SetPropagation(mountPoint, PropagationPrivate))
+	require.NoError(t, ns.VerifyPropagation(mountPoint, PropagationPrivate))
+	require.NoError(t, ns.Unmount(mountPoint))
+	assert.ErrorIs(t, ns.MountTmpfs(source, "size=1m"), ErrNotBeneath)
+}
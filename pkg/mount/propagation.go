@@ -0,0 +1,76 @@
+package mount
+
+import "fmt"
+
+// Propagation is the propagation type of a mount, see mount_namespaces(7).
+type Propagation string
+
+const (
+	// PropagationUnchanged leaves the propagation type a mount inherits
+	// from its parent as is.
+	PropagationUnchanged Propagation = ""
+
+	// PropagationPrivate stops mount and unmount events from propagating
+	// to and from the mount.
+	PropagationPrivate Propagation = "private"
+
+	// PropagationSlave lets the mount receive events from its peer group
+	// but not propagate any back. A mount that is not shared stays
+	// private.
+	PropagationSlave Propagation = "slave"
+
+	// PropagationUnbindable makes the mount private and prevents it from
+	// being bind mounted.
+	PropagationUnbindable Propagation = "unbindable"
+)
+
+// ParsePropagation parses a propagation type name. The empty string means
+// PropagationUnchanged.
+func ParsePropagation(s string) (Propagation, error) {
+	switch p := Propagation(s); p {
+	case PropagationUnchanged, PropagationPrivate, PropagationSlave, PropagationUnbindable:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unsupported mount propagation %q: must be one of private, slave or unbindable", s)
+	}
+}
+
+// SetPropagation changes the propagation type of the topmost mount on
+// mountPoint. Mounts below it are not affected.
+func SetPropagation(mountPoint string, p Propagation) error {
+	return setPropagation(mountPoint, p)
+}
+
+// VerifyPropagation checks, against the optional fields of the mount
+// information of the current process, that the topmost mount on mountPoint
+// has the propagation type p.
+func VerifyPropagation(mountPoint string, p Propagation) error {
+	info, ok, err := GetMount(mountPoint)
+	switch {
+	case err != nil:
+		return err
+	case !ok:
+		return fmt.Errorf("%q is not a mount point", mountPoint)
+	}
+	return checkPropagation(info, p)
+}
+
+func checkPropagation(info MountInfo, p Propagation) error {
+	var ok bool
+	switch p {
+	case PropagationUnchanged:
+		ok = true
+	case PropagationPrivate:
+		ok = info.Shared == 0 && info.Master == 0 && !info.Unbindable
+	case PropagationSlave:
+		ok = info.Shared == 0 && !info.Unbindable
+	case PropagationUnbindable:
+		ok = info.Shared == 0 && info.Master == 0 && info.Unbindable
+	default:
+		return fmt.Errorf("unsupported mount propagation %q", p)
+	}
+	if !ok {
+		return fmt.Errorf("mount propagation is not %s (optional fields %q)", p, info.OptionalFields)
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package mount
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+func setPropagation(mountPoint string, p Propagation) error {
+	var flags uintptr
+	switch p {
+	case PropagationUnchanged:
+		return nil
+	case PropagationPrivate:
+		flags = unix.MS_PRIVATE
+	case PropagationSlave:
+		flags = unix.MS_SLAVE
+	case PropagationUnbindable:
+		flags = unix.MS_UNBINDABLE
+	default:
+		return fmt.Errorf("unsupported mount propagation %q", p)
+	}
+	return unix.Mount("", mountPoint, "", flags, "")
+}
@@ -0,0 +1,45 @@
+package mount
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePropagation(t *testing.T) {
+	for _, s := range []string{"", "private", "slave", "unbindable"} {
+		p, err := ParsePropagation(s)
+		require.NoError(t, err)
+		assert.Equal(t, Propagation(s), p)
+	}
+	_, err := ParsePropagation("shared")
+	require.EqualError(t, err, `unsupported mount propagation "shared": must be one of private, slave or unbindable`)
+}
+
+func TestCheckPropagation(t *testing.T) {
+	private := MountInfo{}
+	shared := MountInfo{OptionalFields: []string{"shared:2"}, Shared: 2}
+	slave := MountInfo{OptionalFields: []string{"master:2"}, Master: 2}
+	sharedSlave := MountInfo{OptionalFields: []string{"shared:3", "master:2"}, Shared: 3, Master: 2}
+	unbindable := MountInfo{OptionalFields: []string{"unbindable"}, Unbindable: true}
+
+	for _, tt := range []struct {
+		propagation Propagation
+		accepted    []MountInfo
+		rejected    []MountInfo
+	}{
+		{PropagationUnchanged, []MountInfo{private, shared, slave, sharedSlave, unbindable}, nil},
+		{PropagationPrivate, []MountInfo{private}, []MountInfo{shared, slave, sharedSlave, unbindable}},
+		{PropagationSlave, []MountInfo{slave, private}, []MountInfo{shared, sharedSlave, unbindable}},
+		{PropagationUnbindable, []MountInfo{unbindable}, []MountInfo{private, shared, slave, sharedSlave}},
+	} {
+		for _, info := range tt.accepted {
+			assert.NoError(t, checkPropagation(info, tt.propagation), "%s: %v", tt.propagation, info.OptionalFields)
+		}
+		for _, info := range tt.rejected {
+			assert.Error(t, checkPropagation(info, tt.propagation), "%s: %v", tt.propagation, info.OptionalFields)
+		}
+	}
+	assert.EqualError(t, checkPropagation(shared, PropagationPrivate), `mount propagation is not private (optional fields ["shared:2"])`)
+}
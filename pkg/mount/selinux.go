@@ -0,0 +1,58 @@
+package mount
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// seLinuxLabelXattr is the extended attribute holding the SELinux label of a
+// file.
+const seLinuxLabelXattr = "security.selinux"
+
+// ParseSELinuxContextOption returns the label carried by a "context=" mount
+// option, e.g. `context="system_u:object_r:container_file_t:s0:c1,c2"`. The
+// label must be a complete user:role:type:level label.
+func ParseSELinuxContextOption(option string) (string, error) {
+	label, ok := strings.CutPrefix(option, "context=")
+	if !ok {
+		return "", fmt.Errorf("%q is not an SELinux context mount option", option)
+	}
+	label = unquoteOption(label)
+	if parts := strings.SplitN(label, ":", 4); len(parts) != 4 || slices.Contains(parts, "") {
+		return "", fmt.Errorf("invalid SELinux label %q: expected user:role:type:level", label)
+	}
+	return label, nil
+}
+
+// SharedSELinuxLabel returns the label with the MCS categories removed from
+// its level, leaving only the lowest sensitivity. Every container of that
+// type dominates the resulting label, which makes it suitable for files that
+// are shared between pods, like the Workload API socket. Labelling a shared
+// file with the categories of one pod would lock all other pods out of it.
+func SharedSELinuxLabel(label string) string {
+	parts := strings.SplitN(label, ":", 4)
+	if len(parts) != 4 {
+		return label
+	}
+	sensitivity, _, _ := strings.Cut(parts[3], ":")
+	sensitivity, _, _ = strings.Cut(sensitivity, "-")
+	parts[3] = sensitivity
+	return strings.Join(parts, ":")
+}
+
+// GetSELinuxLabel returns the SELinux label of path. Symlinks are not
+// followed.
+func GetSELinuxLabel(path string) (string, error) {
+	return getSELinuxLabel(path)
+}
+
+// SetSELinuxLabel sets the SELinux label of path. Symlinks are not followed.
+func SetSELinuxLabel(path, label string) error {
+	return setSELinuxLabel(path, label)
+}
+
+// SELinuxEnabled returns whether SELinux is enabled on the host.
+func SELinuxEnabled() bool {
+	return seLinuxEnabled()
+}
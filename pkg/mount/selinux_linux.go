@@ -0,0 +1,38 @@
+package mount
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// seLinuxEnforcePath exists when the SELinux filesystem is mounted, which is
+// the case whenever SELinux is enabled.
+const seLinuxEnforcePath = "/sys/fs/selinux/enforce"
+
+func getSELinuxLabel(path string) (string, error) {
+	buf := make([]byte, 256)
+	for {
+		n, err := unix.Lgetxattr(path, seLinuxLabelXattr, buf)
+		if errors.Is(err, unix.ERANGE) {
+			buf = make([]byte, len(buf)*2)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		// The label is NUL terminated.
+		return strings.TrimRight(string(buf[:n]), "\x00"), nil
+	}
+}
+
+func setSELinuxLabel(path, label string) error {
+	return unix.Lsetxattr(path, seLinuxLabelXattr, []byte(label), 0)
+}
+
+func seLinuxEnabled() bool {
+	_, err := os.Stat(seLinuxEnforcePath)
+	return err == nil
+}
@@ -0,0 +1,34 @@
+package mount
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSELinuxContextOption(t *testing.T) {
+	label, err := ParseSELinuxContextOption(`context="system_u:object_r:container_file_t:s0:c1,c2"`)
+	require.NoError(t, err)
+	assert.Equal(t, "system_u:object_r:container_file_t:s0:c1,c2", label)
+
+	label, err = ParseSELinuxContextOption("context=system_u:object_r:container_file_t:s0")
+	require.NoError(t, err)
+	assert.Equal(t, "system_u:object_r:container_file_t:s0", label)
+
+	_, err = ParseSELinuxContextOption("nosuid")
+	require.EqualError(t, err, `"nosuid" is not an SELinux context mount option`)
+
+	_, err = ParseSELinuxContextOption("context=container_file_t")
+	require.EqualError(t, err, `invalid SELinux label "container_file_t": expected user:role:type:level`)
+
+	_, err = ParseSELinuxContextOption("context=system_u::container_file_t:s0")
+	require.EqualError(t, err, `invalid SELinux label "system_u::container_file_t:s0": expected user:role:type:level`)
+}
+
+func TestSharedSELinuxLabel(t *testing.T) {
+	assert.Equal(t, "system_u:object_r:container_file_t:s0", SharedSELinuxLabel("system_u:object_r:container_file_t:s0:c1,c2"))
+	assert.Equal(t, "system_u:object_r:container_file_t:s0", SharedSELinuxLabel("system_u:object_r:container_file_t:s0"))
+	assert.Equal(t, "system_u:object_r:spc_t:s0", SharedSELinuxLabel("system_u:object_r:spc_t:s0-s0:c0.c1023"))
+	assert.Equal(t, "bogus", SharedSELinuxLabel("bogus"))
+}
@@ -0,0 +1,74 @@
+package mount
+
+import (
+	"errors"
+	"time"
+)
+
+// UnmountOptions configure how UnmountWithOptions deals with mounts that
+// cannot be unmounted right away.
+type UnmountOptions struct {
+	// Retries is how many times an unmount failing because the mount is
+	// busy is retried.
+	Retries int
+
+	// Backoff is the delay before the first retry. It doubles with every
+	// retry, up to MaxBackoff.
+	Backoff time.Duration
+
+	// MaxBackoff caps the delay between retries. Zero means no cap.
+	MaxBackoff time.Duration
+
+	// Detach lazily detaches the mount (MNT_DETACH) as a last resort if it
+	// is still busy after the retries. The mount disappears from the mount
+	// table right away and is cleaned up once it is no longer in use.
+	Detach bool
+}
+
+// DefaultUnmountOptions retries busy unmounts for about three seconds and
+// does not detach.
+func DefaultUnmountOptions() UnmountOptions {
+	return UnmountOptions{
+		Retries:    5,
+		Backoff:    100 * time.Millisecond,
+		MaxBackoff: time.Second,
+	}
+}
+
+// UnmountResult describes how an unmount went.
+type UnmountResult struct {
+	// Attempts is the number of unmount calls made, including a detach.
+	Attempts int
+
+	// Detached is set if the mount was lazily detached.
+	Detached bool
+
+	// Corrupted is set if the mount was found to be corrupted (e.g. its
+	// filesystem server is gone) before it was unmounted.
+	Corrupted bool
+}
+
+// UnmountWithOptions unmounts mountPoint, retrying with backoff while the
+// mount is busy and, if configured, lazily detaching it as a last resort.
+// Corrupted mounts, which fail any access with errors like ENOTCONN or
+// ESTALE, are unmounted as well.
+func UnmountWithOptions(mountPoint string, opts UnmountOptions) (UnmountResult, error) {
+	return unmountWithOptions(mountPoint, opts)
+}
+
+// IsCorruptedMountPoint returns whether mountPoint is a mount point whose
+// filesystem fails any access, e.g. a network or FUSE filesystem whose server
+// is gone.
+func IsCorruptedMountPoint(mountPoint string) bool {
+	return isCorruptedMountPoint(mountPoint)
+}
+
+// IsCorruptedMountError returns whether err is one of the errors returned when
+// accessing a corrupted mount.
+func IsCorruptedMountError(err error) bool {
+	return isCorruptedMountError(err)
+}
+
+// ErrMountBusy is returned (wrapped) when a mount is still busy after all
+// retries.
+var ErrMountBusy = errors.New("mount is busy")
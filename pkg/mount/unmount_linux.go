@@ -0,0 +1,78 @@
+package mount
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	// unmountSyscall, statMountPoint and sleep are replaced in unit tests.
+	unmountSyscall = unix.Unmount
+	statMountPoint = os.Stat
+	sleep          = time.Sleep
+)
+
+func unmountWithOptions(mountPoint string, opts UnmountOptions) (UnmountResult, error) {
+	return unmountWithFlags(mountPoint, opts, 0)
+}
+
+// unmountWithFlags is unmountWithOptions with flags added to every unmount
+// system call.
+func unmountWithFlags(mountPoint string, opts UnmountOptions, flags int) (UnmountResult, error) {
+	result := UnmountResult{
+		Corrupted: isCorruptedMountPoint(mountPoint),
+	}
+	backoff := opts.Backoff
+	for {
+		result.Attempts++
+		err := unmountSyscall(mountPoint, flags)
+		switch {
+		case err == nil:
+			return result, nil
+		case isCorruptedMountError(err):
+			// Some kernels fail to unmount corrupted mounts; detaching
+			// them does not touch the filesystem.
+			result.Corrupted = true
+			result.Attempts++
+			if err := unmountSyscall(mountPoint, flags|unix.MNT_DETACH); err != nil {
+				return result, fmt.Errorf("unable to detach corrupted mount: %w", err)
+			}
+			result.Detached = true
+			return result, nil
+		case !errors.Is(err, unix.EBUSY):
+			return result, err
+		case result.Attempts <= opts.Retries:
+			sleep(backoff)
+			backoff *= 2
+			if opts.MaxBackoff > 0 && backoff > opts.MaxBackoff {
+				backoff = opts.MaxBackoff
+			}
+			continue
+		case opts.Detach:
+			result.Attempts++
+			if err := unmountSyscall(mountPoint, flags|unix.MNT_DETACH); err != nil {
+				return result, fmt.Errorf("unable to detach busy mount: %w", err)
+			}
+			result.Detached = true
+			return result, nil
+		default:
+			return result, fmt.Errorf("%w after %d attempts: %w", ErrMountBusy, result.Attempts, err)
+		}
+	}
+}
+
+func isCorruptedMountPoint(mountPoint string) bool {
+	_, err := statMountPoint(mountPoint)
+	return isCorruptedMountError(err)
+}
+
+func isCorruptedMountError(err error) bool {
+	return errors.Is(err, unix.ENOTCONN) ||
+		errors.Is(err, unix.ESTALE) ||
+		errors.Is(err, unix.EIO) ||
+		errors.Is(err, unix.EHOSTDOWN)
+}
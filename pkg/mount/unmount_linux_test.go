@@ -0,0 +1,138 @@
+package mount
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestUnmountWithOptions(t *testing.T) {
+	type call struct {
+		flags int
+		err   error
+	}
+	for _, tt := range []struct {
+		desc          string
+		opts          UnmountOptions
+		statErr       error
+		calls         []call
+		expectResult  UnmountResult
+		expectErr     string
+		expectSleeps  []time.Duration
+		expectErrBusy bool
+	}{
+		{
+			desc:         "success",
+			opts:         DefaultUnmountOptions(),
+			calls:        []call{{0, nil}},
+			expectResult: UnmountResult{Attempts: 1},
+		},
+		{
+			desc:         "busy then success",
+			opts:         UnmountOptions{Retries: 3, Backoff: time.Millisecond, MaxBackoff: 3 * time.Millisecond},
+			calls:        []call{{0, unix.EBUSY}, {0, unix.EBUSY}, {0, unix.EBUSY}, {0, nil}},
+			expectResult: UnmountResult{Attempts: 4},
+			expectSleeps: []time.Duration{time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond},
+		},
+		{
+			desc:          "still busy",
+			opts:          UnmountOptions{Retries: 1, Backoff: time.Millisecond},
+			calls:         []call{{0, unix.EBUSY}, {0, unix.EBUSY}},
+			expectResult:  UnmountResult{Attempts: 2},
+			expectErr:     "mount is busy after 2 attempts: device or resource busy",
+			expectSleeps:  []time.Duration{time.Millisecond},
+			expectErrBusy: true,
+		},
+		{
+			desc:         "busy then detached",
+			opts:         UnmountOptions{Retries: 1, Backoff: time.Millisecond, Detach: true},
+			calls:        []call{{0, unix.EBUSY}, {0, unix.EBUSY}, {unix.MNT_DETACH, nil}},
+			expectResult: UnmountResult{Attempts: 3, Detached: true},
+			expectSleeps: []time.Duration{time.Millisecond},
+		},
+		{
+			desc:         "corrupted mount is unmounted",
+			opts:         DefaultUnmountOptions(),
+			statErr:      unix.ENOTCONN,
+			calls:        []call{{0, nil}},
+			expectResult: UnmountResult{Attempts: 1, Corrupted: true},
+		},
+		{
+			desc:         "corrupted mount is detached if unmounting fails",
+			opts:         DefaultUnmountOptions(),
+			statErr:      unix.ESTALE,
+			calls:        []call{{0, unix.ESTALE}, {unix.MNT_DETACH, nil}},
+			expectResult: UnmountResult{Attempts: 2, Corrupted: true, Detached: true},
+		},
+		{
+			desc:         "other errors are not retried",
+			opts:         DefaultUnmountOptions(),
+			calls:        []call{{0, unix.EINVAL}},
+			expectResult: UnmountResult{Attempts: 1},
+			expectErr:    "invalid argument",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			var calls []call
+			var sleeps []time.Duration
+			origUnmount, origStat, origSleep := unmountSyscall, statMountPoint, sleep
+			t.Cleanup(func() { unmountSyscall, statMountPoint, sleep = origUnmount, origStat, origSleep })
+			unmountSyscall = func(target string, flags int) error {
+				assert.Equal(t, "/mnt/target", target)
+				require.Less(t, len(calls), len(tt.calls), "unexpected unmount call")
+				err := tt.calls[len(calls)].err
+				calls = append(calls, call{flags, err})
+				return err
+			}
+			statMountPoint = func(string) (os.FileInfo, error) {
+				return nil, tt.statErr
+			}
+			sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+
+			result, err := UnmountWithOptions("/mnt/target", tt.opts)
+			if tt.expectErr != "" {
+				require.EqualError(t, err, tt.expectErr)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectErrBusy, errors.Is(err, ErrMountBusy))
+			assert.Equal(t, tt.expectResult, result)
+			assert.Equal(t, tt.calls, calls)
+			assert.Equal(t, tt.expectSleeps, sleeps)
+		})
+	}
+}
+
+func TestUnmountWithOptionsBusy(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("bind mounting requires root")
+	}
+
+	mountPoint := t.TempDir()
+	require.NoError(t, BindMountRW(t.TempDir(), mountPoint))
+	t.Cleanup(func() { _ = Unmount(mountPoint) })
+
+	// An open file keeps the mount busy.
+	f, err := os.Create(filepath.Join(mountPoint, "file"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = f.Close() })
+
+	_, err = UnmountWithOptions(mountPoint, UnmountOptions{Retries: 1, Backoff: time.Millisecond})
+	if err == nil {
+		t.Skip("mount was not busy")
+	}
+	require.ErrorIs(t, err, ErrMountBusy)
+
+	result, err := UnmountWithOptions(mountPoint, UnmountOptions{Retries: 1, Backoff: time.Millisecond, Detach: true})
+	require.NoError(t, err)
+	assert.True(t, result.Detached)
+	ok, err := IsMountPoint(mountPoint)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,134 @@
+// Package sandbox restricts the privileges of the driver process once it
+// has started: it drops the capabilities the driver does not need and, where
+// the kernel supports it, confines filesystem access with Landlock.
+package sandbox
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spiffe/spiffe-csi/pkg/logkeys"
+)
+
+// Capability is a Linux capability, e.g. CAP_SYS_ADMIN.
+type Capability int
+
+// The capabilities the driver may need.
+const (
+	CapChown         Capability = 0
+	CapDACOverride   Capability = 1
+	CapDACReadSearch Capability = 2
+	CapFowner        Capability = 3
+	CapSetGID        Capability = 6
+	CapSetUID        Capability = 7
+	CapSetPCap       Capability = 8
+	CapSysChroot     Capability = 18
+	CapSysPtrace     Capability = 19
+	CapSysAdmin      Capability = 21
+)
+
+var capabilityNames = [...]string{
+	"CAP_CHOWN", "CAP_DAC_OVERRIDE", "CAP_DAC_READ_SEARCH", "CAP_FOWNER",
+	"CAP_FSETID", "CAP_KILL", "CAP_SETGID", "CAP_SETUID", "CAP_SETPCAP",
+	"CAP_LINUX_IMMUTABLE", "CAP_NET_BIND_SERVICE", "CAP_NET_BROADCAST",
+	"CAP_NET_ADMIN", "CAP_NET_RAW", "CAP_IPC_LOCK", "CAP_IPC_OWNER",
+	"CAP_SYS_MODULE", "CAP_SYS_RAWIO", "CAP_SYS_CHROOT", "CAP_SYS_PTRACE",
+	"CAP_SYS_PACCT", "CAP_SYS_ADMIN", "CAP_SYS_BOOT", "CAP_SYS_NICE",
+	"CAP_SYS_RESOURCE", "CAP_SYS_TIME", "CAP_SYS_TTY_CONFIG", "CAP_MKNOD",
+	"CAP_LEASE", "CAP_AUDIT_WRITE", "CAP_AUDIT_CONTROL", "CAP_SETFCAP",
+	"CAP_MAC_OVERRIDE", "CAP_MAC_ADMIN", "CAP_SYSLOG", "CAP_WAKE_ALARM",
+	"CAP_BLOCK_SUSPEND", "CAP_AUDIT_READ", "CAP_PERFMON", "CAP_BPF",
+	"CAP_CHECKPOINT_RESTORE",
+}
+
+// String returns the name of the capability, e.g. "CAP_SYS_ADMIN".
+func (c Capability) String() string {
+	if c >= 0 && int(c) < len(capabilityNames) {
+		return capabilityNames[c]
+	}
+	return fmt.Sprintf("CAP_%d", int(c))
+}
+
+// Config is the sandbox to apply.
+type Config struct {
+	// Capabilities are the capabilities to keep. Every other capability is
+	// dropped from the permitted, effective, inheritable, ambient and,
+	// if the process may do so, bounding sets.
+	Capabilities []Capability
+
+	// LandlockPaths, if set, are the directories below which the process
+	// keeps access to the filesystem once the Landlock ruleset is applied.
+	// Landlock denies mounting and unmounting altogether, so it must only
+	// be used if the process does not do those itself.
+	LandlockPaths []string
+
+	// LandlockSkipReason explains why no Landlock ruleset is applied if
+	// LandlockPaths is empty. It is passed through to the report.
+	LandlockSkipReason string
+}
+
+// Report describes the restrictions that were applied.
+type Report struct {
+	// Capabilities are the capabilities left in the permitted set.
+	Capabilities []string `json:"capabilities"`
+
+	// DroppedCapabilities are the capabilities removed from the permitted
+	// set.
+	DroppedCapabilities []string `json:"droppedCapabilities"`
+
+	// BoundingSetDropped is whether the capabilities were also dropped
+	// from the bounding set, which takes CAP_SETPCAP.
+	BoundingSetDropped bool `json:"boundingSetDropped"`
+
+	// NoNewPrivs is whether the no_new_privs flag was set.
+	NoNewPrivs bool `json:"noNewPrivs"`
+
+	// Landlock describes the Landlock ruleset.
+	Landlock LandlockReport `json:"landlock"`
+
+	// ProcStatus holds the security related fields of /proc/self/status
+	// (e.g. CapEff) after the restrictions were applied, as a check of
+	// the above.
+	ProcStatus map[string]string `json:"procStatus,omitempty"`
+}
+
+// LandlockReport describes the Landlock ruleset that was applied.
+type LandlockReport struct {
+	// ABI is the Landlock ABI version of the kernel, or 0 if the kernel
+	// does not support Landlock.
+	ABI int `json:"abi"`
+
+	// Enforced is whether a ruleset was applied.
+	Enforced bool `json:"enforced"`
+
+	// Paths are the directories access remains allowed below.
+	Paths []string `json:"paths,omitempty"`
+
+	// Reason explains why no ruleset was applied.
+	Reason string `json:"reason,omitempty"`
+}
+
+// LogValues returns the report as key-value pairs for structured logging.
+func (r Report) LogValues() []any {
+	values := []any{
+		logkeys.Capabilities, strings.Join(r.Capabilities, ","),
+		logkeys.BoundingSetDropped, r.BoundingSetDropped,
+		logkeys.NoNewPrivs, r.NoNewPrivs,
+		logkeys.LandlockABI, r.Landlock.ABI,
+		logkeys.LandlockEnforced, r.Landlock.Enforced,
+	}
+	if r.Landlock.Enforced {
+		values = append(values, logkeys.LandlockPaths, strings.Join(r.Landlock.Paths, ","))
+	} else {
+		values = append(values, logkeys.LandlockReason, r.Landlock.Reason)
+	}
+	return values
+}
+
+// Apply applies the sandbox to every thread of the process. The process has
+// to be built without cgo, since the threads are otherwise not all reachable.
+// Restrictions cannot be lifted again, so Apply is meant to be called once,
+// when the process is done starting up.
+func Apply(config Config) (Report, error) {
+	return apply(config)
+}
@@ -0,0 +1,226 @@
+package sandbox
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func apply(config Config) (Report, error) {
+	var report Report
+
+	// Landlock is applied first: without no_new_privs, restricting
+	// takes CAP_SYS_ADMIN, which may be among the dropped capabilities.
+	if err := allThreads(unix.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return report, fmt.Errorf("unable to set no_new_privs: %w", err)
+	}
+	report.NoNewPrivs = true
+
+	landlock, err := applyLandlock(config)
+	report.Landlock = landlock
+	if err != nil {
+		return report, err
+	}
+
+	if err := dropCapabilities(config.Capabilities, &report); err != nil {
+		return report, err
+	}
+
+	// Best effort: /proc may be out of reach of the Landlock ruleset.
+	report.ProcStatus, _ = readProcStatus()
+	return report, nil
+}
+
+// allThreads performs a system call on every thread of the process.
+func allThreads(trap, a1, a2, a3, a4, a5 uintptr) error {
+	_, _, errno := syscall.AllThreadsSyscall6(trap, a1, a2, a3, a4, a5, 0)
+	if errno == syscall.ENOTSUP {
+		return errors.New("unable to reach every thread of a process built with cgo")
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func dropCapabilities(keep []Capability, report *Report) error {
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&hdr, &data[0]); err != nil {
+		return fmt.Errorf("unable to get capabilities: %w", err)
+	}
+	has := func(set func(*unix.CapUserData) uint32, c Capability) bool {
+		return set(&data[c/32])&(1<<(c%32)) != 0
+	}
+	permitted := func(d *unix.CapUserData) uint32 { return d.Permitted }
+	effective := func(d *unix.CapUserData) uint32 { return d.Effective }
+
+	// Dropping from the bounding set takes CAP_SETPCAP, which an
+	// unprivileged process (e.g. in development mode) does not have.
+	lastCap := lastCapability()
+	report.BoundingSetDropped = has(effective, CapSetPCap)
+	if report.BoundingSetDropped {
+		for c := Capability(0); c <= lastCap; c++ {
+			if slices.Contains(keep, c) {
+				continue
+			}
+			if err := allThreads(unix.SYS_PRCTL, unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0); err != nil {
+				return fmt.Errorf("unable to drop %s from the bounding set: %w", c, err)
+			}
+		}
+	}
+	// Old kernels lack ambient capabilities, so there are none to clear.
+	if err := allThreads(unix.SYS_PRCTL, unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil && !errors.Is(err, unix.EINVAL) {
+		return fmt.Errorf("unable to clear ambient capabilities: %w", err)
+	}
+
+	var mask [2]uint32
+	for c := Capability(0); c <= lastCap; c++ {
+		switch {
+		case !has(permitted, c):
+		case slices.Contains(keep, c):
+			mask[c/32] |= 1 << (c % 32)
+			report.Capabilities = append(report.Capabilities, c.String())
+		default:
+			report.DroppedCapabilities = append(report.DroppedCapabilities, c.String())
+		}
+	}
+	for i := range data {
+		data[i].Permitted &= mask[i]
+		data[i].Effective &= mask[i]
+		data[i].Inheritable &= mask[i]
+	}
+	if err := allThreads(unix.SYS_CAPSET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0, 0, 0); err != nil {
+		return fmt.Errorf("unable to set capabilities: %w", err)
+	}
+	return nil
+}
+
+// lastCapability returns the highest capability known to the kernel.
+func lastCapability() Capability {
+	b, err := os.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err != nil {
+		return unix.CAP_LAST_CAP
+	}
+	c, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return unix.CAP_LAST_CAP
+	}
+	return Capability(c)
+}
+
+// Filesystem access rights handled by Landlock, by ABI version.
+const (
+	landlockAccessFSv1 = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+		unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_FIFO |
+		unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM
+	landlockAccessFSv2 = landlockAccessFSv1 | unix.LANDLOCK_ACCESS_FS_REFER
+	landlockAccessFSv3 = landlockAccessFSv2 | unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	landlockAccessFSv5 = landlockAccessFSv3 | unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+)
+
+func landlockAccessFS(abi int) uint64 {
+	switch {
+	case abi >= 5:
+		return landlockAccessFSv5
+	case abi >= 3:
+		return landlockAccessFSv3
+	case abi == 2:
+		return landlockAccessFSv2
+	default:
+		return landlockAccessFSv1
+	}
+}
+
+func applyLandlock(config Config) (LandlockReport, error) {
+	var report LandlockReport
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		report.Reason = fmt.Sprintf("not supported by the kernel: %v", errno)
+		if len(config.LandlockPaths) == 0 {
+			report.Reason = config.LandlockSkipReason
+		}
+		return report, nil
+	}
+	report.ABI = int(abi)
+	if len(config.LandlockPaths) == 0 {
+		report.Reason = config.LandlockSkipReason
+		return report, nil
+	}
+
+	access := landlockAccessFS(report.ABI)
+	attr := unix.LandlockRulesetAttr{Access_fs: access}
+	rulesetFD, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return report, fmt.Errorf("unable to create Landlock ruleset: %w", errno)
+	}
+	defer func() { _ = unix.Close(int(rulesetFD)) }()
+
+	for _, path := range config.LandlockPaths {
+		if err := addLandlockRule(int(rulesetFD), path, access); err != nil {
+			return report, err
+		}
+	}
+	if err := allThreads(unix.SYS_LANDLOCK_RESTRICT_SELF, rulesetFD, 0, 0, 0, 0); err != nil {
+		return report, fmt.Errorf("unable to apply Landlock ruleset: %w", err)
+	}
+	report.Enforced = true
+	report.Paths = slices.Clone(config.LandlockPaths)
+	return report, nil
+}
+
+func addLandlockRule(rulesetFD int, path string, access uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("unable to open Landlock path %q: %w", path, err)
+	}
+	defer func() { _ = unix.Close(fd) }()
+
+	rule := unix.LandlockPathBeneathAttr{
+		Allowed_access: access,
+		Parent_fd:      int32(fd), //nolint:gosec // file descriptors fit
+	}
+	if _, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(rulesetFD), unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&rule)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("unable to add Landlock rule for %q: %w", path, errno)
+	}
+	return nil
+}
+
+// procStatusFields are the fields of /proc/self/status included in reports.
+var procStatusFields = []string{"CapInh", "CapPrm", "CapEff", "CapBnd", "CapAmb", "NoNewPrivs", "Seccomp"}
+
+func readProcStatus() (map[string]string, error) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	status := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if ok && slices.Contains(procStatusFields, key) {
+			status[key] = strings.TrimSpace(value)
+		}
+	}
+	return status, scanner.Err()
+}
@@ -0,0 +1,155 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// sandboxTestEnv holds the JSON encoded sandboxTestInput when the test binary
+// is run as the process to sandbox.
+const sandboxTestEnv = "SPIFFE_CSI_SANDBOX_TEST"
+
+type sandboxTestInput struct {
+	Config Config
+	Files  []string
+}
+
+type sandboxTestOutput struct {
+	Report   Report
+	ApplyErr string
+	// ReadErrs holds the error reading each of the files after the sandbox
+	// was applied, or an empty string.
+	ReadErrs []string
+}
+
+func TestMain(m *testing.M) {
+	if input, ok := os.LookupEnv(sandboxTestEnv); ok {
+		runSandboxed(input)
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func runSandboxed(input string) {
+	var in sandboxTestInput
+	if err := json.Unmarshal([]byte(input), &in); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	var out sandboxTestOutput
+	report, err := Apply(in.Config)
+	out.Report = report
+	if err != nil {
+		out.ApplyErr = err.Error()
+	}
+	for _, file := range in.Files {
+		errString := ""
+		if _, err := os.ReadFile(file); err != nil {
+			errString = err.Error()
+		}
+		out.ReadErrs = append(out.ReadErrs, errString)
+	}
+	_ = json.NewEncoder(os.Stdout).Encode(out)
+}
+
+func runSandboxedTest(t *testing.T, in sandboxTestInput) sandboxTestOutput {
+	t.Helper()
+	// The test binary shares the build mode of the sandboxed process.
+	if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_GETPID, 0, 0, 0); errno == syscall.ENOTSUP {
+		t.Skip("the sandbox cannot be applied to a test binary built with cgo; run with CGO_ENABLED=0")
+	}
+	input, err := json.Marshal(in)
+	require.NoError(t, err)
+	exe, err := os.Executable()
+	require.NoError(t, err)
+	cmd := exec.Command(exe)
+	cmd.Env = append(os.Environ(), sandboxTestEnv+"="+string(input))
+	stdout, err := cmd.Output()
+	require.NoError(t, err)
+	var out sandboxTestOutput
+	require.NoError(t, json.Unmarshal(stdout, &out))
+	return out
+}
+
+func TestApplyCapabilities(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("dropping capabilities requires root")
+	}
+	out := runSandboxedTest(t, sandboxTestInput{
+		Config: Config{
+			Capabilities:       []Capability{CapSysAdmin, CapDACReadSearch},
+			LandlockSkipReason: "not requested",
+		},
+	})
+	require.Empty(t, out.ApplyErr)
+	assert.Equal(t, []string{"CAP_DAC_READ_SEARCH", "CAP_SYS_ADMIN"}, out.Report.Capabilities)
+	assert.Contains(t, out.Report.DroppedCapabilities, "CAP_SYS_PTRACE")
+	assert.True(t, out.Report.BoundingSetDropped)
+	assert.True(t, out.Report.NoNewPrivs)
+	assert.Equal(t, "not requested", out.Report.Landlock.Reason)
+	assert.False(t, out.Report.Landlock.Enforced)
+
+	mask := fmt.Sprintf("%016x", uint64(1)<<CapSysAdmin|uint64(1)<<CapDACReadSearch)
+	assert.Equal(t, mask, out.Report.ProcStatus["CapEff"])
+	assert.Equal(t, mask, out.Report.ProcStatus["CapPrm"])
+	assert.Equal(t, mask, out.Report.ProcStatus["CapBnd"])
+	assert.Equal(t, "1", out.Report.ProcStatus["NoNewPrivs"])
+}
+
+func TestApplyLandlock(t *testing.T) {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		t.Skipf("Landlock is not supported: %v", errno)
+	}
+
+	allowed := t.TempDir()
+	denied := t.TempDir()
+	for _, dir := range []string{allowed, denied} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), []byte("hello"), 0600))
+	}
+
+	out := runSandboxedTest(t, sandboxTestInput{
+		Config: Config{
+			LandlockPaths: []string{allowed, "/proc"},
+		},
+		Files: []string{filepath.Join(allowed, "file"), filepath.Join(denied, "file")},
+	})
+	require.Empty(t, out.ApplyErr)
+	assert.Equal(t, LandlockReport{
+		ABI:      int(abi),
+		Enforced: true,
+		Paths:    []string{allowed, "/proc"},
+	}, out.Report.Landlock)
+	assert.Equal(t, "1", out.Report.ProcStatus["NoNewPrivs"])
+	require.Len(t, out.ReadErrs, 2)
+	assert.Empty(t, out.ReadErrs[0])
+	assert.Contains(t, out.ReadErrs[1], "permission denied")
+}
+
+func TestApplyLandlockMissingPath(t *testing.T) {
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION); errno != 0 {
+		t.Skipf("Landlock is not supported: %v", errno)
+	}
+	missing := filepath.Join(t.TempDir(), "missing")
+	out := runSandboxedTest(t, sandboxTestInput{
+		Config: Config{LandlockPaths: []string{missing}},
+	})
+	assert.Contains(t, out.ApplyErr, fmt.Sprintf("unable to open Landlock path %q", missing))
+	assert.False(t, out.Report.Landlock.Enforced)
+}
+
+func TestCapabilityString(t *testing.T) {
+	assert.Equal(t, "CAP_CHOWN", CapChown.String())
+	assert.Equal(t, "CAP_SYS_ADMIN", CapSysAdmin.String())
+	assert.Equal(t, "CAP_CHECKPOINT_RESTORE", Capability(40).String())
+	assert.Equal(t, "CAP_63", Capability(63).String())
+}
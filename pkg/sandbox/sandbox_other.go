@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package sandbox
+
+func apply(Config) (Report, error) {
+	return Report{
+		Landlock: LandlockReport{Reason: "unsupported on this platform"},
+	}, nil
+}
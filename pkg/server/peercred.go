@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+
+	"github.com/go-logr/logr"
+	"github.com/spiffe/spiffe-csi/pkg/logkeys"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// Peer identifies the process on the other end of a CSI socket connection.
+// It is read with SO_PEERCRED when the connection is accepted, so it
+// reflects the process that connected, not whoever uses the connection
+// later.
+type Peer struct {
+	UID uint32
+	GID uint32
+
+	// PID is 0 if the process is not in the PID namespace of the driver.
+	PID int32
+
+	// Executable is the target of /proc/<pid>/exe, or empty if it could
+	// not be read. It is read after the connection is accepted, so a peer
+	// that exits right away could in theory have its PID reused.
+	Executable string
+}
+
+// PeerAuthInfo is the credentials.AuthInfo of connections authenticated with
+// peer credentials.
+type PeerAuthInfo struct {
+	credentials.CommonAuthInfo
+	Peer Peer
+}
+
+// AuthType returns the name of the authentication type.
+func (PeerAuthInfo) AuthType() string { return "peercred" }
+
+// unidentifiedAuthInfo is the credentials.AuthInfo of connections whose peer
+// could not be identified.
+type unidentifiedAuthInfo struct {
+	credentials.CommonAuthInfo
+}
+
+func (unidentifiedAuthInfo) AuthType() string { return "peercred" }
+
+// PeerFromContext returns the peer of the connection an RPC came in on.
+func PeerFromContext(ctx context.Context) (Peer, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return Peer{}, false
+	}
+	authInfo, ok := p.AuthInfo.(PeerAuthInfo)
+	if !ok {
+		return Peer{}, false
+	}
+	return authInfo.Peer, true
+}
+
+// PeerPolicy is an allowlist of the peers that may connect to the CSI socket.
+// The zero value allows every peer.
+type PeerPolicy struct {
+	// UIDs are the allowed UIDs. If empty, every UID is allowed.
+	UIDs []uint32
+
+	// Executables are the allowed executables, as the targets of
+	// /proc/<pid>/exe. If empty, every executable is allowed.
+	Executables []string
+}
+
+// IsZero returns whether the policy allows every peer.
+func (p PeerPolicy) IsZero() bool {
+	return len(p.UIDs) == 0 && len(p.Executables) == 0
+}
+
+// Check returns an error if peer is not allowed.
+func (p PeerPolicy) Check(peer Peer) error {
+	if len(p.UIDs) > 0 && !slices.Contains(p.UIDs, peer.UID) {
+		return fmt.Errorf("peer UID %d is not allowed", peer.UID)
+	}
+	if len(p.Executables) > 0 && !slices.Contains(p.Executables, peer.Executable) {
+		if peer.Executable == "" {
+			return errors.New("peer executable is unknown")
+		}
+		return fmt.Errorf("peer executable %q is not allowed", peer.Executable)
+	}
+	return nil
+}
+
+// peerCredentials are transport credentials for unix sockets that identify
+// the peer of each connection and reject peers not allowed by the policy.
+// The connection itself is used as is.
+type peerCredentials struct {
+	log    logr.Logger
+	policy PeerPolicy
+}
+
+var _ credentials.TransportCredentials = (*peerCredentials)(nil)
+
+func (c *peerCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	peer, err := readPeer(conn)
+	if err != nil {
+		if !c.policy.IsZero() {
+			c.log.Error(err, "Rejected CSI socket connection")
+			return nil, nil, err
+		}
+		// Without a policy, failing to identify the peer (e.g. on
+		// platforms without SO_PEERCRED) is no reason to reject it.
+		// Logged with Info, since Error disregards the verbosity.
+		c.log.V(1).Info("Unable to identify CSI socket peer", logkeys.Error, err.Error())
+		return conn, unidentifiedAuthInfo{credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity}}, nil
+	}
+	if err := c.policy.Check(peer); err != nil {
+		c.log.Error(err, "Rejected CSI socket connection", peerLogValues(peer)...)
+		return nil, nil, err
+	}
+	return conn, PeerAuthInfo{
+		// Like with credentials/local, a unix socket does not leave the
+		// host.
+		CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity},
+		Peer:           peer,
+	}, nil
+}
+
+func (c *peerCredentials) ClientHandshake(context.Context, string, net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("peer credentials are only supported by servers")
+}
+
+func (c *peerCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "peercred"}
+}
+
+func (c *peerCredentials) Clone() credentials.TransportCredentials {
+	clone := *c
+	return &clone
+}
+
+func (c *peerCredentials) OverrideServerName(string) error {
+	return nil
+}
+
+// peerLogValues returns the log values identifying peer.
+func peerLogValues(peer Peer) []any {
+	return []any{
+		logkeys.PeerUID, peer.UID,
+		logkeys.PeerGID, peer.GID,
+		logkeys.PeerPID, peer.PID,
+		logkeys.PeerExecutable, peer.Executable,
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package server
+
+import (
+	"errors"
+	"net"
+)
+
+func readPeer(net.Conn) (Peer, error) {
+	return Peer{}, errors.New("unsupported on this platform")
+}
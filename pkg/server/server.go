@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/go-logr/logr"
@@ -19,6 +20,19 @@ type Config struct {
 	Log           logr.Logger
 	CSISocketPath string
 	Driver        Driver
+
+	// PeerPolicy restricts the processes that may connect to the CSI
+	// socket, as identified with SO_PEERCRED. The zero value allows every
+	// process. The identity of callers is logged with each RPC either way.
+	PeerPolicy PeerPolicy
+
+	// SocketMode, if set, is the mode the CSI socket is given once
+	// created.
+	SocketMode os.FileMode
+
+	// SocketGroup, if set, is the GID the CSI socket is given once
+	// created.
+	SocketGroup *int
 }
 
 // Driver is the interface that the CSI driver must implement.
@@ -27,32 +41,92 @@ type Driver interface {
 	csi.NodeServer
 }
 
-// Run starts the gRPC server and blocks until it exits.
-func Run(config Config) error {
-	if config.CSISocketPath == "" {
-		return errors.New("CSI socket path is required")
+// Serve serves the driver on listener, which Listen created for config, and
+// blocks until the server exits.
+func Serve(config Config, listener net.Listener) error {
+	config.Log.Info("Listening...")
+	return newServer(config).Serve(listener)
+}
+
+// Listen creates the CSI socket, replacing any left behind, and applies the
+// configured mode and group to it. To keep the socket from being reachable
+// with the mode it is created with, it is then created in a private
+// directory next to the CSI socket path and only renamed into place once
+// the mode and group are applied.
+func Listen(config Config) (net.Listener, error) {
+	switch {
+	case config.CSISocketPath == "":
+		return nil, errors.New("CSI socket path is required")
+	case config.SocketMode&^os.ModePerm != 0:
+		return nil, fmt.Errorf("invalid CSI socket mode %#o", config.SocketMode)
+	case config.SocketGroup != nil && *config.SocketGroup < 0:
+		return nil, fmt.Errorf("invalid CSI socket group %d", *config.SocketGroup)
 	}
 
 	if err := os.Remove(config.CSISocketPath); err != nil && !os.IsNotExist(err) {
 		config.Log.Error(err, "Unable to remove CSI socket")
 	}
 
-	listener, err := net.Listen("unix", config.CSISocketPath)
+	if config.SocketMode == 0 && config.SocketGroup == nil {
+		listener, err := net.Listen("unix", config.CSISocketPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create CSI socket listener: %w", err)
+		}
+		return listener, nil
+	}
+
+	dir, err := os.MkdirTemp(filepath.Dir(config.CSISocketPath), ".csi-socket-")
 	if err != nil {
-		return fmt.Errorf("unable to create CSI socket listener: %w", err)
+		return nil, fmt.Errorf("unable to create CSI socket directory: %w", err)
 	}
+	defer func() { _ = os.RemoveAll(dir) }()
 
+	socketPath := filepath.Join(dir, filepath.Base(config.CSISocketPath))
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create CSI socket listener: %w", err)
+	}
+	// The socket is renamed, so closing the listener cannot unlink it.
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := setSocketPermissions(socketPath, config); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	if err := os.Rename(socketPath, config.CSISocketPath); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("unable to move CSI socket into place: %w", err)
+	}
+	return listener, nil
+}
+
+// setSocketPermissions applies the configured group and mode to the socket
+// at socketPath.
+func setSocketPermissions(socketPath string, config Config) error {
+	if config.SocketGroup != nil {
+		if err := os.Chown(socketPath, -1, *config.SocketGroup); err != nil {
+			return fmt.Errorf("unable to set group of CSI socket: %w", err)
+		}
+	}
+	if config.SocketMode != 0 {
+		if err := os.Chmod(socketPath, config.SocketMode); err != nil {
+			return fmt.Errorf("unable to set mode of CSI socket: %w", err)
+		}
+	}
+	return nil
+}
+
+// newServer creates the gRPC server serving the driver.
+func newServer(config Config) *grpc.Server {
 	rpcLogger := rpcLogger{Log: config.Log}
 
 	server := grpc.NewServer(
+		grpc.Creds(&peerCredentials{log: config.Log, policy: config.PeerPolicy}),
 		grpc.UnaryInterceptor(rpcLogger.UnaryRPCLogger),
 		grpc.StreamInterceptor(rpcLogger.StreamRPCLogger),
 	)
 	csi.RegisterIdentityServer(server, config.Driver)
 	csi.RegisterNodeServer(server, config.Driver)
-
-	config.Log.Info("Listening...")
-	return server.Serve(listener)
+	return server
 }
 
 type rpcLogger struct {
@@ -60,7 +134,7 @@ type rpcLogger struct {
 }
 
 func (l rpcLogger) UnaryRPCLogger(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	log := l.Log.WithValues(logkeys.FullMethod, info.FullMethod)
+	log := l.withCaller(ctx).WithValues(logkeys.FullMethod, info.FullMethod)
 	resp, err := handler(ctx, req)
 	if err != nil {
 		log.Error(err, "RPC failed")
@@ -71,7 +145,7 @@ func (l rpcLogger) UnaryRPCLogger(ctx context.Context, req interface{}, info *gr
 }
 
 func (l rpcLogger) StreamRPCLogger(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-	log := l.Log.WithValues(logkeys.FullMethod, info.FullMethod)
+	log := l.withCaller(ss.Context()).WithValues(logkeys.FullMethod, info.FullMethod)
 	err := handler(srv, ss)
 	if err != nil {
 		log.Error(err, "RPC failed")
@@ -80,3 +154,12 @@ func (l rpcLogger) StreamRPCLogger(srv interface{}, ss grpc.ServerStream, info *
 	}
 	return err
 }
+
+// withCaller returns the logger with the identity of the caller of the RPC,
+// if known.
+func (l rpcLogger) withCaller(ctx context.Context) logr.Logger {
+	if peer, ok := PeerFromContext(ctx); ok {
+		return l.Log.WithValues(peerLogValues(peer)...)
+	}
+	return l.Log
+}
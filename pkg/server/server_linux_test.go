@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+type fakeDriver struct {
+	csi.UnimplementedIdentityServer
+	csi.UnimplementedNodeServer
+
+	peers chan Peer
+}
+
+func (d *fakeDriver) GetPluginInfo(ctx context.Context, _ *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
+	peer, ok := PeerFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Internal, "peer unknown")
+	}
+	d.peers <- peer
+	return &csi.GetPluginInfoResponse{Name: "csi.spiffe.io"}, nil
+}
+
+func TestPeerPolicy(t *testing.T) {
+	exe, err := os.Executable()
+	require.NoError(t, err)
+	self := Peer{
+		UID:        uint32(os.Getuid()),
+		GID:        uint32(os.Getgid()),
+		PID:        int32(os.Getpid()),
+		Executable: exe,
+	}
+
+	for _, tt := range []struct {
+		desc       string
+		policy     PeerPolicy
+		expectCode codes.Code
+	}{
+		{
+			desc:       "no policy",
+			expectCode: codes.OK,
+		},
+		{
+			desc:       "allowed UID",
+			policy:     PeerPolicy{UIDs: []uint32{self.UID + 1, self.UID}},
+			expectCode: codes.OK,
+		},
+		{
+			desc:       "disallowed UID",
+			policy:     PeerPolicy{UIDs: []uint32{self.UID + 1}},
+			expectCode: codes.Unavailable,
+		},
+		{
+			desc:       "allowed executable",
+			policy:     PeerPolicy{UIDs: []uint32{self.UID}, Executables: []string{exe}},
+			expectCode: codes.OK,
+		},
+		{
+			desc:       "disallowed executable",
+			policy:     PeerPolicy{Executables: []string{"/usr/bin/kubelet"}},
+			expectCode: codes.Unavailable,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			driver := &fakeDriver{peers: make(chan Peer, 1)}
+			client := startServer(t, Config{
+				Log:           logr.Discard(),
+				CSISocketPath: shortSocketPath(t),
+				Driver:        driver,
+				PeerPolicy:    tt.policy,
+			})
+
+			_, err := client.GetPluginInfo(context.Background(), &csi.GetPluginInfoRequest{})
+			require.Equal(t, tt.expectCode, status.Code(err), "%v", err)
+			if err == nil {
+				assert.Equal(t, self, <-driver.peers)
+			}
+		})
+	}
+}
+
+func TestListen(t *testing.T) {
+	socketPath := shortSocketPath(t)
+	require.NoError(t, os.WriteFile(socketPath, nil, 0600))
+	gid := os.Getgid()
+
+	listener, err := Listen(Config{
+		Log:           logr.Discard(),
+		CSISocketPath: socketPath,
+		SocketMode:    0660,
+		SocketGroup:   &gid,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	info, err := os.Stat(socketPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeSocket|0660, info.Mode())
+	assert.Equal(t, uint32(gid), info.Sys().(*syscall.Stat_t).Gid)
+
+	// The socket was created in a private directory, which is gone, and
+	// moved into place.
+	entries, err := os.ReadDir(filepath.Dir(socketPath))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "csi.sock", entries[0].Name())
+
+	conn, err := net.Dial("unix", socketPath)
+	require.NoError(t, err)
+	_ = conn.Close()
+
+	invalidGroup := -1
+	_, err = Listen(Config{CSISocketPath: socketPath, SocketGroup: &invalidGroup})
+	require.EqualError(t, err, "invalid CSI socket group -1")
+	_, err = Listen(Config{CSISocketPath: socketPath, SocketMode: os.ModeSetuid | 0600})
+	require.EqualError(t, err, "invalid CSI socket mode 040000600")
+	_, err = Listen(Config{})
+	require.EqualError(t, err, "CSI socket path is required")
+}
+
+func startServer(t *testing.T, config Config) csi.IdentityClient {
+	listener, err := Listen(config)
+	require.NoError(t, err)
+	server := newServer(config)
+	t.Cleanup(server.Stop)
+	go func() { _ = server.Serve(listener) }()
+
+	conn, err := grpc.NewClient("unix://"+config.CSISocketPath,
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return csi.NewIdentityClient(conn)
+}
+
+// shortSocketPath returns a socket path short enough for a unix socket.
+func shortSocketPath(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "csi")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return filepath.Join(dir, "csi.sock")
+}